@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/XIU2/SNIProxy/sniproxy"
+)
+
+const (
+	remoteConfigTimeout = 30 * time.Second // 获取远程配置文件的超时时间
+	maxRemoteConfigSize = 16 << 20         // 远程配置文件的大小上限
+)
+
+var (
+	remoteConfigMu   sync.Mutex
+	remoteConfigETag string   // 上一次获取到的 ETag（轮询时用于 If-None-Match）
+	remoteConfigSum  [32]byte // 上一次获取到的内容的 SHA-256（服务器不支持 ETag 时用于判断内容是否改变）
+
+	configPollInterval atomic.Int64 // 当前配置中的 config_poll_interval（秒）
+)
+
+// 配置文件是否为远程地址（http:// 或 https:// 开头）
+func isRemoteConfig(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// 获取远程配置文件，conditional 为真时（轮询）内容没有改变则返回 changed 为假
+func fetchRemoteConfig(url string, conditional bool) (data []byte, changed bool, err error) {
+	remoteConfigMu.Lock()
+	defer remoteConfigMu.Unlock()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if conditional && remoteConfigETag != "" {
+		req.Header.Set("If-None-Match", remoteConfigETag)
+	}
+	resp, err := (&http.Client{Timeout: remoteConfigTimeout}).Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if conditional && resp.StatusCode == http.StatusNotModified {
+		return nil, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	data, err = io.ReadAll(io.LimitReader(resp.Body, maxRemoteConfigSize+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data) > maxRemoteConfigSize {
+		return nil, false, fmt.Errorf("配置文件超过 %d MB", maxRemoteConfigSize>>20)
+	}
+	sum := sha256.Sum256(data)
+	changed = !conditional || sum != remoteConfigSum
+	remoteConfigETag, remoteConfigSum = resp.Header.Get("ETag"), sum // 内容无效时也记录，同样的内容不会在每次轮询时重复报错
+	return data, changed, nil
+}
+
+// 读取远程配置文件
+func loadRemoteConfig(url string) (*sniproxy.Config, error) {
+	data, _, err := fetchRemoteConfig(url, false)
+	if err != nil {
+		return nil, fmt.Errorf("配置文件 %s 获取失败: %v", url, err)
+	}
+	return sniproxy.ParseConfig(data, url)
+}
+
+// 定时获取远程配置文件（config_poll_interval），内容改变时重载配置；获取失败或新配置无效时继续使用当前配置
+// config_poll_interval 在重载配置后改为 0 时停止轮询（从 0 改为其他值需要重启）
+func pollRemoteConfig(p *sniproxy.Proxy, url string) {
+	for {
+		interval := configPollInterval.Load()
+		if interval <= 0 {
+			return
+		}
+		time.Sleep(time.Duration(interval) * time.Second)
+		data, changed, err := fetchRemoteConfig(url, true)
+		if err != nil {
+			p.Log(fmt.Sprintf("获取配置文件 %s 失败, 继续使用当前配置: %v", url, err), sniproxy.LevelWarn)
+			continue
+		}
+		if !changed {
+			continue
+		}
+		p.Log(fmt.Sprintf("配置文件 %s 已改变, 重载配置...", url), sniproxy.LevelInfo)
+		sdNotifyReloading()
+		cfg, err := sniproxy.ParseConfig(data, url)
+		if err == nil {
+			cfg.LogFile, cfg.Debug = LogFilePath, EnableDebug
+		}
+		applyConfig(p, cfg, err)
+		sdNotify("READY=1")
+	}
+}
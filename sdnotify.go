@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/XIU2/SNIProxy/sniproxy"
+)
+
+// 向 systemd 发送状态通知（sd_notify，服务配置为 Type=notify 时使用），不是由 systemd 启动（没有 NOTIFY_SOCKET）时不发送
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if socket[0] == '@' { // 抽象命名空间的 socket
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		sniproxy.Log("发送 systemd 通知失败: "+err.Error(), sniproxy.LevelWarn)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		sniproxy.Log("发送 systemd 通知失败: "+err.Error(), sniproxy.LevelWarn)
+	}
+}
+
+// 配置了 WatchdogSec 时（systemd 设置 WATCHDOG_USEC）定期发送 WATCHDOG=1，间隔为超时时间的一半
+// 每次发送前检查 SNI Proxy 是否正常运行（Healthy），不正常（例如接受连接的线程卡住、死锁）时不发送，由 systemd 重启
+func startWatchdog(p *sniproxy.Proxy) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) { // 看门狗是给其他进程的
+		return
+	}
+	interval := time.Duration(usec) * time.Microsecond / 2
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var result chan error // 正在进行的检查（上一次检查没有返回时不再开始新的检查）
+		failing := false
+		for range ticker.C {
+			if result == nil {
+				result = make(chan error, 1)
+				go func(result chan error) { result <- p.Healthy(interval) }(result)
+			}
+			var err error
+			select {
+			case err = <-result:
+				result = nil
+			case <-time.After(interval / 2):
+				err = errors.New("检查没有返回（可能已死锁）")
+			}
+			if err != nil {
+				if !failing {
+					sniproxy.Log("运行状态异常, 停止发送 systemd 看门狗心跳: "+err.Error(), sniproxy.LevelError)
+				}
+				failing = true
+				continue
+			}
+			if failing {
+				sniproxy.Log("运行状态已恢复, 继续发送 systemd 看门狗心跳", sniproxy.LevelInfo)
+				failing = false
+			}
+			sdNotify("WATCHDOG=1")
+		}
+	}()
+}
+
+// 开始重载配置的通知（sd_notify(3) 要求 RELOADING=1 同时带有 CLOCK_MONOTONIC 的当前时间，单位为微秒）
+func sdNotifyReloading() {
+	state := "RELOADING=1"
+	if usec := monotonicUsec(); usec > 0 {
+		state += "\nMONOTONIC_USEC=" + strconv.FormatInt(usec, 10)
+	}
+	sdNotify(state)
+}
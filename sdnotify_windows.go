@@ -0,0 +1,6 @@
+package main
+
+// Windows 下没有 systemd，不需要 CLOCK_MONOTONIC
+func monotonicUsec() int64 {
+	return 0
+}
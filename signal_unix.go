@@ -0,0 +1,31 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/XIU2/SNIProxy/sniproxy"
+)
+
+// 除退出、重载配置外额外处理的信号（Windows 下没有这些信号）
+var extraSignals = []os.Signal{syscall.SIGUSR1, syscall.SIGUSR2}
+
+// 处理额外的信号，返回是否已处理
+func handleExtraSignal(p *sniproxy.Proxy, s os.Signal) bool {
+	switch s {
+	case syscall.SIGUSR1: // 重新打开日志文件（配合 logrotate 等外部工具）
+		if err := p.ReopenLogFile(); err != nil {
+			fmt.Printf("重新打开日志文件失败: %v\n", err)
+			return true
+		}
+		p.Log("接收到信号 SIGUSR1, 已重新打开日志文件", sniproxy.LevelInfo)
+		return true
+	case syscall.SIGUSR2: // 输出运行状态（不需要开启 metrics_addr）
+		p.LogStats()
+		return true
+	}
+	return false
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os"
+
+	"github.com/XIU2/SNIProxy/sniproxy"
+)
+
+// Windows 下没有 SIGUSR1、SIGUSR2 等信号
+var extraSignals []os.Signal
+
+// 处理额外的信号，返回是否已处理
+func handleExtraSignal(p *sniproxy.Proxy, s os.Signal) bool {
+	return false
+}
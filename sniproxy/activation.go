@@ -0,0 +1,120 @@
+package sniproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const sdListenFDsStart = 3 // systemd 传入的第一个 socket 的文件描述符（SD_LISTEN_FDS_START）
+
+// systemd socket activation 传入的 socket（进程中只读取一次，被某个监听使用后移除）
+var activatedSockets struct {
+	once        sync.Once
+	mu          sync.Mutex
+	listeners   []net.Listener
+	packetConns []net.PacketConn
+}
+
+// 读取 systemd 传入的 socket（LISTEN_FDS、LISTEN_PID），读取后清除这些环境变量，避免被子进程继承
+func loadActivatedSockets() {
+	activatedSockets.once.Do(func() {
+		defer os.Unsetenv("LISTEN_PID")
+		defer os.Unsetenv("LISTEN_FDS")
+		defer os.Unsetenv("LISTEN_FDNAMES")
+		if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+			return
+		}
+		n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil {
+			return
+		}
+		for fd := sdListenFDsStart; fd < sdListenFDsStart+n; fd++ {
+			f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+			if l, err := net.FileListener(f); err == nil { // TCP socket（FileListener 会复制文件描述符）
+				activatedSockets.listeners = append(activatedSockets.listeners, l)
+			} else if c, err := net.FilePacketConn(f); err == nil { // UDP socket（quic 模式）
+				activatedSockets.packetConns = append(activatedSockets.packetConns, c)
+			} else {
+				Log(fmt.Sprintf("无法使用 systemd 传入的文件描述符 %d: %v", fd, err), LevelWarn)
+			}
+			f.Close()
+		}
+	})
+}
+
+// 监听 TCP 地址（开启 socket_activation 时优先使用 systemd 传入的相同地址的 socket），返回是否为 systemd 传入的 socket
+func listenTCP(ctx context.Context, lc *net.ListenConfig, cfg *Config, addr string) (net.Listener, bool, error) {
+	if path, ok := unixSocketPath(addr); ok { // Unix socket（unix:路径）
+		l, err := listenUnix(path, cfg.unixSocketMode)
+		return l, false, err
+	}
+	if cfg.SocketActivation {
+		loadActivatedSockets()
+		activatedSockets.mu.Lock()
+		for i, l := range activatedSockets.listeners {
+			if sameListenAddr(addr, l.Addr()) {
+				activatedSockets.listeners = append(activatedSockets.listeners[:i], activatedSockets.listeners[i+1:]...)
+				activatedSockets.mu.Unlock()
+				return l, true, nil
+			}
+		}
+		activatedSockets.mu.Unlock()
+	}
+	l, err := lc.Listen(ctx, "tcp", addr)
+	return l, false, err
+}
+
+// 监听 UDP 地址（quic 模式，与 listenTCP 相同）
+func listenUDP(ctx context.Context, lc *net.ListenConfig, cfg *Config, addr string) (net.PacketConn, bool, error) {
+	if cfg.SocketActivation {
+		loadActivatedSockets()
+		activatedSockets.mu.Lock()
+		for i, c := range activatedSockets.packetConns {
+			if sameListenAddr(addr, c.LocalAddr()) {
+				activatedSockets.packetConns = append(activatedSockets.packetConns[:i], activatedSockets.packetConns[i+1:]...)
+				activatedSockets.mu.Unlock()
+				return c, true, nil
+			}
+		}
+		activatedSockets.mu.Unlock()
+	}
+	c, err := lc.ListenPacket(ctx, "udp", addr)
+	return c, false, err
+}
+
+// 没有被任何监听使用的 systemd 传入的 socket 数量
+func unusedActivatedSockets() int {
+	activatedSockets.mu.Lock()
+	defer activatedSockets.mu.Unlock()
+	return len(activatedSockets.listeners) + len(activatedSockets.packetConns)
+}
+
+// 配置中的监听地址是否与 socket 实际的地址相同（省略 IP 时与监听所有地址的 socket 相同）
+func sameListenAddr(addr string, actual net.Addr) bool {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	var ip net.IP
+	var port int
+	switch a := actual.(type) {
+	case *net.TCPAddr:
+		ip, port = a.IP, a.Port
+	case *net.UDPAddr:
+		ip, port = a.IP, a.Port
+	default:
+		return false
+	}
+	if want, err := net.LookupPort(actual.Network(), portStr); err != nil || want != port {
+		return false
+	}
+	if host == "" {
+		return ip.IsUnspecified()
+	}
+	want := net.ParseIP(host)
+	return want != nil && (want.Equal(ip) || want.IsUnspecified() && ip.IsUnspecified())
+}
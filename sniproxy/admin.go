@@ -0,0 +1,237 @@
+package sniproxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const maxAdminBodySize = 64 << 10 // 管理 API 请求体的最大长度
+
+// 管理 API 中的一个监听及其规则
+type adminListener struct {
+	Index      int      `json:"index"` // 在所有监听中的位置（修改规则时通过 listener 参数指定）
+	ListenAddr []string `json:"listen_addr"`
+	Mode       string   `json:"mode"`
+	Rules      []string `json:"rules"`
+}
+
+// POST /rules 的请求体
+type adminRuleRequest struct {
+	Rule     string `json:"rule"`
+	Listener int    `json:"listener"` // 默认为第一个监听
+}
+
+// 启动管理 API（需要 Authorization: Bearer <admin_token>）
+func (p *Proxy) startAdminServer(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	localListeners.add(listener.Addr())
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rules", p.handleRules)
+	mux.HandleFunc("/rules/", p.handleRule)
+	mux.HandleFunc("/connections", p.handleConnections)
+	server := &http.Server{Handler: p.adminAuth(mux), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			p.serviceLogger(fmt.Sprintf("管理 API 出错: %v", err), LevelError)
+		}
+	}()
+	p.serviceLogger(fmt.Sprintf("管理 API: http://%v", listener.Addr()), LevelInfo)
+	return server, nil
+}
+
+// 检查 Bearer token（使用当前配置中的 admin_token，重载配置后立即生效）
+func (p *Proxy) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := "Bearer " + p.getConfig().AdminToken
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAdminError(w, http.StatusUnauthorized, errors.New("未授权"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GET /rules 返回所有监听的规则，POST /rules 添加一条规则
+func (p *Proxy) handleRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		cfg := p.getConfig()
+		list := make([]adminListener, 0, len(cfg.listeners))
+		for i, l := range cfg.listeners {
+			list = append(list, adminListener{Index: i, ListenAddr: l.ListenAddr, Mode: l.Mode, Rules: append([]string{}, l.ForwardRules...)})
+		}
+		writeAdminJSON(w, http.StatusOK, list)
+	case http.MethodPost:
+		var req adminRuleRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBodySize)).Decode(&req); err != nil {
+			writeAdminError(w, http.StatusBadRequest, fmt.Errorf("请求无效: %v", err))
+			return
+		}
+		if req.Rule = strings.TrimSpace(req.Rule); req.Rule == "" {
+			writeAdminError(w, http.StatusBadRequest, errors.New("rule 不能为空"))
+			return
+		}
+		p.updateRules(w, req.Listener, http.StatusCreated, func(rules []string) ([]string, error) {
+			for _, rule := range rules {
+				if rule == req.Rule {
+					return nil, errAdminConflict
+				}
+			}
+			return append(rules, req.Rule), nil
+		}, "添加规则 "+req.Rule)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("不支持的请求方法"))
+	}
+}
+
+// DELETE /rules/{rule} 删除一条规则（规则需要 URL 编码，通过 ?listener= 指定监听，默认为第一个）
+func (p *Proxy) handleRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("不支持的请求方法"))
+		return
+	}
+	rule, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/rules/"))
+	if err != nil || rule == "" {
+		writeAdminError(w, http.StatusBadRequest, errors.New("规则无效"))
+		return
+	}
+	index := 0
+	if s := r.URL.Query().Get("listener"); s != "" {
+		if index, err = strconv.Atoi(s); err != nil {
+			writeAdminError(w, http.StatusBadRequest, fmt.Errorf("listener 无效: %s", s))
+			return
+		}
+	}
+	p.updateRules(w, index, http.StatusOK, func(rules []string) ([]string, error) {
+		for i, existing := range rules {
+			if existing == rule {
+				return append(rules[:i:i], rules[i+1:]...), nil
+			}
+		}
+		return nil, errAdminNotFound
+	}, "删除规则 "+rule)
+}
+
+// GET /connections 返回所有活动的 TCP 连接（客户端、SNI 域名、目标、开始时间、目前为止转发的字节数）
+func (p *Proxy) handleConnections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("不支持的请求方法"))
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, p.conns.snapshot())
+}
+
+var (
+	errAdminNotFound = errors.New("规则不存在")
+	errAdminConflict = errors.New("规则已存在")
+)
+
+// 在原始配置的副本上修改指定监听的规则并立即生效（只影响之后的新连接），开启 admin_persist 时写回配置文件
+func (p *Proxy) updateRules(w http.ResponseWriter, index, status int, update func([]string) ([]string, error), action string) {
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+	cfg := *p.source
+	rules := cfg.listenerRules(index)
+	if rules == nil {
+		writeAdminError(w, http.StatusNotFound, fmt.Errorf("监听 %d 不存在", index))
+		return
+	}
+	updated, err := update(append([]string{}, (*rules)...))
+	switch {
+	case errors.Is(err, errAdminNotFound):
+		writeAdminError(w, http.StatusNotFound, err)
+		return
+	case errors.Is(err, errAdminConflict):
+		writeAdminError(w, http.StatusConflict, err)
+		return
+	}
+	*rules = updated
+	if err := p.reload(&cfg); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	p.serviceLogger(fmt.Sprintf("管理 API: %s（监听 %d）", action, index), LevelInfo)
+	if cfg.AdminPersist && cfg.Path != "" {
+		if err := saveConfig(&cfg); err != nil {
+			p.serviceLogger(fmt.Sprintf("管理 API: 写回配置文件 %s 失败: %v", cfg.Path, err), LevelError)
+			writeAdminError(w, http.StatusInternalServerError, fmt.Errorf("规则已生效, 但写回配置文件失败: %v", err))
+			return
+		}
+	}
+	l := p.getConfig().listeners[index]
+	writeAdminJSON(w, status, adminListener{Index: index, ListenAddr: l.ListenAddr, Mode: l.Mode, Rules: updated})
+}
+
+// 原始配置中第 index 个监听的规则（与 parseListeners 的顺序相同），修改前复制该监听，不影响旧配置
+func (cfg *Config) listenerRules(index int) *[]string {
+	if len(cfg.ListenAddr) > 0 || len(cfg.Listeners) == 0 { // 顶层配置为第一个监听
+		if index == 0 {
+			return &cfg.ForwardRules
+		}
+		index--
+	}
+	if index < 0 || index >= len(cfg.Listeners) {
+		return nil
+	}
+	cfg.Listeners = append([]*ListenerConfig{}, cfg.Listeners...)
+	l := *cfg.Listeners[index]
+	cfg.Listeners[index] = &l
+	return &l.ForwardRules
+}
+
+// 把配置写回配置文件（先写入临时文件再替换，注意配置文件中的注释会丢失）
+func saveConfig(cfg *Config) error {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(cfg.Path), filepath.Base(cfg.Path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if info, err := os.Stat(cfg.Path); err == nil { // 保持原配置文件的权限（可能包含 socks_pass、admin_token）
+		tmp.Chmod(info.Mode().Perm())
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), cfg.Path)
+}
+
+// 输出 JSON 响应
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(v)
+}
+
+// 输出错误响应
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJSON(w, status, map[string]string{"error": err.Error()})
+}
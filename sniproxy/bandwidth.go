@@ -0,0 +1,107 @@
+package sniproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+
+	"golang.org/x/time/rate"
+)
+
+// 带宽限制（令牌桶，每秒补充 rate 个字节的令牌，最多积累 1 秒的令牌；多个连接共用时按读取的先后排队）
+type bandwidthLimiter struct {
+	limiter *rate.Limiter
+	burst   int
+}
+
+// 创建带宽限制（bytesPerSecond 为每秒字节数，为 0 时返回 nil，即不限制）
+func newBandwidthLimiter(bytesPerSecond int64) *bandwidthLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &bandwidthLimiter{limiter: rate.NewLimiter(rate.Limit(bytesPerSecond), int(bytesPerSecond)), burst: int(bytesPerSecond)}
+}
+
+// 解析 global_rate_limit、rule_rate_limits（需要在 parseListeners、parseGeoIP 之后调用），每次加载配置都会创建新的令牌桶
+func parseRateLimits(cfg *Config) []error {
+	var errs []error
+	if cfg.GlobalRateLimit < 0 {
+		errs = append(errs, fmt.Errorf("配置文件中 global_rate_limit 无效: %d（不能小于 0）!", cfg.GlobalRateLimit))
+	}
+	cfg.globalLimiter = newBandwidthLimiter(cfg.GlobalRateLimit)
+	rules := make([]string, 0, len(cfg.RuleRateLimits))
+	for rule := range cfg.RuleRateLimits {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	for _, rule := range rules {
+		rate := cfg.RuleRateLimits[rule]
+		if rate <= 0 {
+			errs = append(errs, fmt.Errorf("配置文件中 rule_rate_limits 无效: 规则 %s 的带宽限制 %d 需要大于 0!", rule, rate))
+			continue
+		}
+		limiter, found := newBandwidthLimiter(rate), false
+		for _, l := range cfg.listeners { // 多个监听（以及 country_rules）中相同的规则共用同一个限制
+			for _, r := range l.rules {
+				if r.raw == rule {
+					r.limiter, found = limiter, true
+				}
+			}
+		}
+		for _, rules := range cfg.countryRules {
+			for _, r := range rules {
+				if r.raw == rule {
+					r.limiter, found = limiter, true
+				}
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("配置文件中 rule_rate_limits 无效: 规则 %s 不在任何监听的 rules 中!", rule))
+		}
+	}
+	return errs
+}
+
+// 受带宽限制的连接（读取时按所有限制等待，不会丢弃数据，只会变慢；ctx 被取消时立即停止等待）
+// 包装后不再是 *net.TCPConn，因此不会使用 splice 转发
+type throttledConn struct {
+	net.Conn
+	ctx      context.Context
+	limiters []*bandwidthLimiter
+	maxRead  int // 单次最多读取的字节数（不超过最小的令牌桶容量，WaitN 不能超过令牌桶容量，也避免一次等待过久）
+}
+
+// 包装连接（没有任何限制时返回原连接），ctx 在关闭连接时取消（退出、空闲超时、max_conn_lifetime 等）
+func throttle(ctx context.Context, c net.Conn, limiters ...*bandwidthLimiter) net.Conn {
+	t := &throttledConn{Conn: c, ctx: ctx}
+	for _, l := range limiters {
+		if l == nil {
+			continue
+		}
+		t.limiters = append(t.limiters, l)
+		if t.maxRead == 0 || l.burst < t.maxRead {
+			t.maxRead = l.burst
+		}
+	}
+	if len(t.limiters) == 0 {
+		return c
+	}
+	return t
+}
+
+// 读取数据，之后等待到所有限制都有足够的令牌（等待期间连接被关闭时返回 net.ErrClosed，已读到的数据仍会转发）
+func (c *throttledConn) Read(b []byte) (int, error) {
+	if len(b) > c.maxRead {
+		b = b[:c.maxRead]
+	}
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		for _, l := range c.limiters {
+			if l.limiter.WaitN(c.ctx, n) != nil {
+				return n, net.ErrClosed
+			}
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,53 @@
+package sniproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// 按带宽限制读取，等待期间取消 ctx 时立即返回（不用等到令牌补回）
+func TestThrottledConnCancel(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go client.Write(make([]byte, 3000))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := throttle(ctx, server, newBandwidthLimiter(1000))
+	buf := make([]byte, 3000)
+	if n, err := io.ReadFull(c, buf[:1000]); err != nil || n != 1000 { // 初始令牌为 1 秒的量，不需要等待
+		t.Fatalf("读取了 %d 字节, 出错: %v", n, err)
+	}
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	n, err := c.Read(buf)
+	if !errors.Is(err, net.ErrClosed) {
+		t.Errorf("取消后 Read 返回 %d 字节, 错误 %v, 期望 net.ErrClosed", n, err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("取消后 Read 等待了 %v", d)
+	}
+}
+
+// 带宽限制生效：读取 2 秒的量至少需要约 1 秒（初始令牌为 1 秒的量）
+func TestThrottledConnRate(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go client.Write(make([]byte, 20000))
+
+	c := throttle(context.Background(), server, newBandwidthLimiter(10000), nil)
+	start := time.Now()
+	if _, err := io.ReadFull(c, make([]byte, 20000)); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 900*time.Millisecond {
+		t.Errorf("读取 20000 字节（限制 10000 字节/秒）只用了 %v", d)
+	}
+}
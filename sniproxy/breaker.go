@@ -0,0 +1,124 @@
+package sniproxy
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCircuitBreakerWindow   = 60 // 默认统计连续失败次数的时间窗口（秒）
+	defaultCircuitBreakerCooldown = 30 // 默认熔断的时间（秒）
+)
+
+// 熔断器状态（也是指标 sniproxy_circuit_breaker_state 的值）
+const (
+	breakerClosed   = 0 // 正常连接
+	breakerOpen     = 1 // 熔断中，直接拒绝
+	breakerHalfOpen = 2 // 熔断时间已过，允许一个连接试探目标是否恢复
+)
+
+var errCircuitOpen = errors.New("目标连续连接失败, 已熔断") // 不会重试，但多个目标时会尝试下一个目标
+
+// 各转发目标的熔断器（circuit_breaker_failures），连续失败过多的目标在熔断时间内直接拒绝，避免每个连接都等待连接超时
+type circuitBreakers struct {
+	mu        sync.Mutex
+	targets   map[string]*breakerState // 目标地址 => 状态（只记录连接失败过的目标，连接成功后删除）
+	lastSweep time.Time
+}
+
+// 一个目标的熔断器
+type breakerState struct {
+	state        int
+	failures     int       // 连续失败次数
+	firstFailure time.Time // 本轮连续失败中第一次失败的时间（超过时间窗口后重新计数）
+	openUntil    time.Time // 熔断结束的时间
+}
+
+// 是否允许连接该目标（未开启熔断时总是允许）
+func (b *circuitBreakers) allow(target string, cfg *Config) bool {
+	if cfg.CircuitBreakerFailures <= 0 {
+		return true
+	}
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if window := time.Duration(cfg.CircuitBreakerWindow) * time.Second; now.Sub(b.lastSweep) >= window { // 不再失败时也要清理
+		b.sweep(now, window)
+	}
+	s, ok := b.targets[target]
+	if !ok {
+		return true
+	}
+	switch s.state {
+	case breakerOpen:
+		if now.Before(s.openUntil) {
+			return false
+		}
+		s.state = breakerHalfOpen // 熔断时间已过，只放行这一个连接，连接结果决定恢复还是继续熔断
+		s.openUntil = now.Add(time.Duration(cfg.CircuitBreakerCooldown) * time.Second)
+		metricCircuitBreakerState.WithLabelValues(target).Set(breakerHalfOpen)
+		return true
+	case breakerHalfOpen: // 正在试探，其它连接仍然拒绝（试探的连接没有结果时，例如遇到不计入的错误，熔断时间后再放行一个）
+		if now.Before(s.openUntil) {
+			return false
+		}
+		s.openUntil = now.Add(time.Duration(cfg.CircuitBreakerCooldown) * time.Second)
+	}
+	return true
+}
+
+// 记录连接成功（删除该目标的熔断器），返回该目标是否从熔断中恢复
+func (b *circuitBreakers) success(target string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.targets[target]
+	if !ok {
+		return false
+	}
+	delete(b.targets, target)
+	metricCircuitBreakerState.DeleteLabelValues(target)
+	return s.state != breakerClosed
+}
+
+// 记录连接失败，返回该目标是否因此开始熔断
+func (b *circuitBreakers) failure(target string, cfg *Config) bool {
+	if cfg.CircuitBreakerFailures <= 0 {
+		return false
+	}
+	now := time.Now()
+	window := time.Duration(cfg.CircuitBreakerWindow) * time.Second
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now.Sub(b.lastSweep) >= window {
+		b.sweep(now, window)
+	}
+	s, ok := b.targets[target]
+	if !ok {
+		s = &breakerState{}
+		b.targets[target] = s
+	}
+	if s.state == breakerClosed && now.Sub(s.firstFailure) > window { // 上一次失败已经超过时间窗口，重新计数
+		s.failures, s.firstFailure = 0, now
+	}
+	s.failures++
+	if s.state == breakerHalfOpen || s.state == breakerClosed && s.failures >= cfg.CircuitBreakerFailures {
+		s.state, s.openUntil = breakerOpen, now.Add(time.Duration(cfg.CircuitBreakerCooldown)*time.Second)
+		metricCircuitBreakerState.WithLabelValues(target).Set(breakerOpen)
+		metricCircuitBreakerOpens.Inc()
+		return true
+	}
+	return false
+}
+
+// 删除时间窗口内没有失败的目标，以及熔断（或试探）结束已超过一个时间窗口、之后没有再连接的目标，
+// 避免 map 和指标 sniproxy_circuit_breaker_state 无限增长（例如 allow_all_hosts 时大量不同的目标）
+func (b *circuitBreakers) sweep(now time.Time, window time.Duration) {
+	for target, s := range b.targets {
+		if s.state == breakerClosed && now.Sub(s.firstFailure) > window || s.state != breakerClosed && now.Sub(s.openUntil) > window {
+			delete(b.targets, target)
+			metricCircuitBreakerState.DeleteLabelValues(target)
+		}
+	}
+	b.lastSweep = now
+}
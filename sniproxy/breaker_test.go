@@ -0,0 +1,39 @@
+package sniproxy
+
+import (
+	"testing"
+	"time"
+)
+
+// 熔断、试探结束超过一个时间窗口后不再连接的目标被清理（包括其指标），未过期的目标保留
+func TestCircuitBreakersSweep(t *testing.T) {
+	cfg := &Config{CircuitBreakerFailures: 1, CircuitBreakerWindow: 60, CircuitBreakerCooldown: 30}
+	window := time.Duration(cfg.CircuitBreakerWindow) * time.Second
+	cooldown := time.Duration(cfg.CircuitBreakerCooldown) * time.Second
+	b := &circuitBreakers{targets: make(map[string]*breakerState)}
+	for _, target := range []string{"open.test:443", "half-open.test:443", "recent.test:443"} {
+		if !b.failure(target, cfg) {
+			t.Fatalf("%s 连接失败 1 次后没有熔断", target)
+		}
+	}
+	b.targets["half-open.test:443"].openUntil = time.Now() // 熔断时间已过，放行一个连接试探
+	if !b.allow("half-open.test:443", cfg) || b.targets["half-open.test:443"].state != breakerHalfOpen {
+		t.Fatal("熔断时间已过后没有进入试探状态")
+	}
+	b.targets["recent.test:443"].openUntil = time.Now().Add(window + cooldown*2)
+
+	b.mu.Lock()
+	b.sweep(time.Now().Add(window+cooldown*2), window)
+	b.mu.Unlock()
+	if _, ok := b.targets["recent.test:443"]; !ok || len(b.targets) != 1 {
+		t.Errorf("清理后剩余的目标为 %v, 期望只有 recent.test:443", b.targets)
+	}
+	for _, target := range []string{"open.test:443", "half-open.test:443"} {
+		if metricCircuitBreakerState.DeleteLabelValues(target) {
+			t.Errorf("清理 %s 后没有删除其指标", target)
+		}
+	}
+	if !metricCircuitBreakerState.DeleteLabelValues("recent.test:443") {
+		t.Error("未清理的 recent.test:443 没有指标")
+	}
+}
@@ -0,0 +1,67 @@
+package sniproxy
+
+import (
+	"io"
+	"sync"
+)
+
+const defaultCopyBufferSize = 32 * 1024 // 默认转发时复制数据使用的缓冲区大小（与 io.Copy 相同）
+
+// 固定大小的缓冲区池（减少每个连接的内存分配）
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// 创建缓冲区池
+func newBufferPool(size int) *bufferPool {
+	p := &bufferPool{size: size}
+	p.pool.New = func() any {
+		b := make([]byte, size)
+		return &b
+	}
+	return p
+}
+
+var (
+	readBufPools sync.Map // 读取 ClientHello（http 模式下为 HTTP 请求头）的缓冲区（缓冲区大小 => *bufferPool，重载配置修改 read_buffer_size 后会有多个）
+	copyBufPools sync.Map // 转发时复制数据的缓冲区（缓冲区大小 => *bufferPool，重载配置修改 copy_buffer_size 后会有多个）
+)
+
+// 获取指定大小的读取缓冲区池
+func readBufPool(size int) *bufferPool {
+	return loadBufPool(&readBufPools, size)
+}
+
+// 获取指定大小的复制缓冲区池
+func copyBufPool(size int) *bufferPool {
+	return loadBufPool(&copyBufPools, size)
+}
+
+// 获取（必要时创建）pools 中指定大小的缓冲区池
+func loadBufPool(pools *sync.Map, size int) *bufferPool {
+	if p, ok := pools.Load(size); ok {
+		return p.(*bufferPool)
+	}
+	p, _ := pools.LoadOrStore(size, newBufferPool(size))
+	return p.(*bufferPool)
+}
+
+// 取出一个缓冲区
+func (p *bufferPool) get() *[]byte {
+	return p.pool.Get().(*[]byte)
+}
+
+// 放回缓冲区（不再使用其中的数据后才能放回）
+func (p *bufferPool) put(b *[]byte) {
+	if cap(*b) != p.size { // 不是本池中的缓冲区（例如 ClientHello 过大时扩大的缓冲区）
+		return
+	}
+	*b = (*b)[:p.size]
+	p.pool.Put(b)
+}
+
+// 只有 Read 方法的 Reader（隐藏 WriteTo，让 io.CopyBuffer 使用传入的缓冲区）
+type readerOnly struct {
+	io.Reader
+}
@@ -0,0 +1,48 @@
+package sniproxy
+
+import (
+	"bytes"
+	"io"
+	"sync/atomic"
+	"testing"
+)
+
+// 比较每个连接使用的缓冲区（读取 ClientHello、上行和下行复制数据）有无缓冲区池时的内存分配
+func BenchmarkConnBuffers(b *testing.B) {
+	hello := testClientHello(b, "example.com")
+	data := bytes.Repeat([]byte("0123456789abcdef"), 64*1024/16)
+	for _, bc := range []struct {
+		name string
+		get  func(p *bufferPool) *[]byte
+		put  func(p *bufferPool, buf *[]byte)
+	}{
+		{"pool", (*bufferPool).get, (*bufferPool).put},
+		{"no_pool", func(p *bufferPool) *[]byte {
+			buf := make([]byte, p.size)
+			return &buf
+		}, func(*bufferPool, *[]byte) {}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			readPool, copyPool := readBufPool(initialReadSize), copyBufPool(defaultCopyBufferSize)
+			var counter atomic.Int64
+			r := bytes.NewReader(nil)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				readBuf := bc.get(readPool)
+				r.Reset(hello)
+				if _, err := readClientHello(r, *readBuf); err != nil {
+					b.Fatal(err)
+				}
+				for dir := 0; dir < 2; dir++ { // 上行、下行各使用一个缓冲区
+					buf := bc.get(copyPool)
+					r.Reset(data)
+					if _, err := io.CopyBuffer(&countingWriter{w: io.Discard, n: &counter}, readerOnly{r}, *buf); err != nil {
+						b.Fatal(err)
+					}
+					bc.put(copyPool, buf)
+				}
+				bc.put(readPool, readBuf)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package sniproxy
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCaptureBytes = 4096            // 默认每个连接最多保存的字节数（capture_bytes）
+	maxCaptureBytes     = 65535 - 40 - 20 // 一个数据包能容纳的最大字节数（IPv6 头部 40 字节 + TCP 头部 20 字节）
+	pcapLinkTypeRaw     = 101             // pcap 链路类型 LINKTYPE_RAW（数据包直接以 IPv4/IPv6 头部开始）
+)
+
+// ClientHello 抓包文件（capture_file，仅在调试模式 -d 或 min_log_level 为 debug 时写入），用于排查 SNI 解析失败等问题
+// 每个连接读到的第一段数据（ClientHello，http 模式下为 HTTP 请求头）保存为一个伪造的 TCP 数据包（客户端 => 监听地址），
+// 数据包的时间即读到数据的时间，可以直接用 Wireshark 打开（非 443 端口时需要用 "Decode As" 指定为 TLS），也可以用 tcpdump -r -X 查看十六进制
+type captureFile struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+var clientHelloCapture = &captureFile{}
+
+// 保存一个连接读到的数据（未开启时直接返回），写入出错时返回错误
+func (f *captureFile) write(cfg *Config, client, local net.Addr, data []byte) error {
+	if cfg.CaptureFile == "" || logLevel(cfg) != LevelDebug || len(data) == 0 {
+		return nil
+	}
+	if len(data) > cfg.CaptureBytes {
+		data = data[:cfg.CaptureBytes]
+	}
+	packet := capturePacket(client, local, data)
+	now := time.Now()
+	record := make([]byte, 16, 16+len(packet))
+	binary.LittleEndian.PutUint32(record[0:], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(packet)))
+	record = append(record, packet...)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil || f.path != cfg.CaptureFile {
+		if err := f.open(cfg.CaptureFile); err != nil {
+			return err
+		}
+	}
+	_, err := f.file.Write(record)
+	return err
+}
+
+// 打开抓包文件（不存在或为空时写入 pcap 文件头，已有内容时追加）
+func (f *captureFile) open(path string) error {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600) // 其中可能有敏感信息（例如 ClientHello 中的域名），只允许本用户读取
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if info.Size() == 0 {
+		header := make([]byte, 24)
+		binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4) // 魔数（微秒精度）
+		binary.LittleEndian.PutUint16(header[4:], 2)          // 版本 2.4
+		binary.LittleEndian.PutUint16(header[6:], 4)
+		binary.LittleEndian.PutUint32(header[16:], 65535) // snaplen
+		binary.LittleEndian.PutUint32(header[20:], pcapLinkTypeRaw)
+		if _, err := file.Write(header); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	f.path, f.file = path, file
+	return nil
+}
+
+// 构造 IP + TCP 头部和数据组成的数据包（地址不是 TCP 地址时使用 0.0.0.0:0）
+func capturePacket(client, local net.Addr, data []byte) []byte {
+	src, dst := tcpAddrOf(client), tcpAddrOf(local)
+	tcp := make([]byte, 20, 20+len(data))
+	binary.BigEndian.PutUint16(tcp[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(tcp[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint32(tcp[4:], 1) // 序列号（握手之后的第一个字节）
+	binary.BigEndian.PutUint32(tcp[8:], 1)
+	tcp[12] = 5 << 4 // 头部长度 20 字节
+	tcp[13] = 0x18   // PSH + ACK
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	tcp = append(tcp, data...)
+
+	var ip, pseudo []byte
+	if src4, dst4 := src.IP.To4(), dst.IP.To4(); src4 != nil && dst4 != nil {
+		ip = make([]byte, 20)
+		ip[0] = 4<<4 | 5
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+		ip[6] = 0x40 // 不分片
+		ip[8] = 64   // TTL
+		ip[9] = 6    // TCP
+		copy(ip[12:], src4)
+		copy(ip[16:], dst4)
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip, 0))
+		pseudo = append(append([]byte{}, src4...), dst4...)
+	} else {
+		ip = make([]byte, 40)
+		ip[0] = 6 << 4
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+		ip[6] = 6  // TCP
+		ip[7] = 64 // 跳数限制
+		copy(ip[8:], src.IP.To16())
+		copy(ip[24:], dst.IP.To16())
+		pseudo = append(append([]byte{}, ip[8:24]...), ip[24:40]...)
+	}
+	pseudo = append(pseudo, 0, 6, byte(len(tcp)>>8), byte(len(tcp)))
+	binary.BigEndian.PutUint16(tcp[16:], checksum(tcp, sum16(pseudo)))
+	return append(ip, tcp...)
+}
+
+// 获取地址中的 IP 和端口（不是 TCP 地址时返回 0.0.0.0:0）
+func tcpAddrOf(addr net.Addr) *net.TCPAddr {
+	if a, ok := addr.(*net.TCPAddr); ok && a.IP != nil {
+		return a
+	}
+	return &net.TCPAddr{IP: net.IPv4zero}
+}
+
+// 按 16 位累加（未取反），用于计算校验和
+func sum16(b []byte) uint32 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
+
+// 计算 IP/TCP 校验和（initial 为伪头部的累加值）
+func checksum(b []byte, initial uint32) uint16 {
+	sum := initial + sum16(b)
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,326 @@
+package sniproxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	recordHeaderLen    = 5         // TLS 记录头长度
+	initialReadSize    = 2048      // 默认读取 ClientHello 时的初始缓冲区大小（read_buffer_size）
+	minReadBufferSize  = 512       // read_buffer_size 的下限（能容纳常见的 ClientHello，更小时几乎每个连接都要扩大缓冲区）
+	maxClientHelloSize = 64 * 1024 // 读取 ClientHello 时的缓冲区上限
+)
+
+// 带边界检查的字节读取器（所有长度字段都会先与剩余数据长度比较）
+type byteReader []byte
+
+// 读取 1 字节
+func (r *byteReader) readUint8(out *uint8) bool {
+	if len(*r) < 1 {
+		return false
+	}
+	*out = (*r)[0]
+	*r = (*r)[1:]
+	return true
+}
+
+// 读取 2 字节（大端序）
+func (r *byteReader) readUint16(out *uint16) bool {
+	if len(*r) < 2 {
+		return false
+	}
+	*out = uint16((*r)[0])<<8 | uint16((*r)[1])
+	*r = (*r)[2:]
+	return true
+}
+
+// 读取 3 字节（大端序）
+func (r *byteReader) readUint24(out *int) bool {
+	if len(*r) < 3 {
+		return false
+	}
+	*out = int((*r)[0])<<16 | int((*r)[1])<<8 | int((*r)[2])
+	*r = (*r)[3:]
+	return true
+}
+
+// 读取 n 字节
+func (r *byteReader) readBytes(n int, out *[]byte) bool {
+	if n < 0 || len(*r) < n {
+		return false
+	}
+	*out = (*r)[:n]
+	*r = (*r)[n:]
+	return true
+}
+
+// 读取 1 字节长度前缀的数据块
+func (r *byteReader) readVector8(out *byteReader) bool {
+	var n uint8
+	var b []byte
+	if !r.readUint8(&n) || !r.readBytes(int(n), &b) {
+		return false
+	}
+	*out = b
+	return true
+}
+
+// 读取 2 字节长度前缀的数据块
+func (r *byteReader) readVector16(out *byteReader) bool {
+	var n uint16
+	var b []byte
+	if !r.readUint16(&n) || !r.readBytes(int(n), &b) {
+		return false
+	}
+	*out = b
+	return true
+}
+
+// 是否已读完
+func (r byteReader) empty() bool {
+	return len(r) == 0
+}
+
+// 开头的数据不是 TLS 握手记录（例如向 TLS 端口发送明文 HTTP 请求、扫描器发送的随机数据）
+var errNotTLS = errors.New("不是 TLS 流量")
+
+// 开头的数据不是 TLS 握手记录时的错误（包括开头的部分数据，便于判断是什么流量）
+func notTLSError(data []byte) error {
+	if len(data) > 16 {
+		data = data[:16]
+	}
+	hint := ""
+	if looksLikeHTTP(data) {
+		hint = ", 看起来是明文 HTTP 请求"
+	}
+	return fmt.Errorf("%w（开头的数据 %q%s）", errNotTLS, data, hint)
+}
+
+// 是否像明文 HTTP 请求（以大写字母组成的请求方法和空格开头，例如 GET /）
+func looksLikeHTTP(data []byte) bool {
+	for i, c := range data {
+		if c == ' ' {
+			return i > 0
+		}
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return false
+}
+
+// 读取完整的第一个 TLS 记录（即 ClientHello），buf 为初始缓冲区，必要时扩大缓冲区（上限 maxClientHelloSize）
+// 返回的是实际读到的所有数据（可能会多于一个 TLS 记录），需要原封不动的转发给目标
+// 开头的数据不是 TLS 握手记录（类型 0x16，版本 0x0300-0x0304）时立即返回 errNotTLS，不再等待更多数据
+func readClientHello(r io.Reader, buf []byte) ([]byte, error) {
+	n, need := 0, recordHeaderLen
+	for n < need {
+		m, err := r.Read(buf[n:])
+		n += m
+		if n > 0 && recordType(buf[0]) != recordTypeHandshake || n >= 3 && (buf[1] != 3 || buf[2] > 4) {
+			return buf[:n], notTLSError(buf[:n])
+		}
+		if need == recordHeaderLen && n >= recordHeaderLen {
+			need = recordHeaderLen + (int(buf[3])<<8 | int(buf[4]))
+			if need > maxClientHelloSize {
+				return buf[:n], fmt.Errorf("ClientHello 过大 (%d 字节, 上限 %d 字节)", need, maxClientHelloSize)
+			}
+			if need > len(buf) { // ClientHello 大于缓冲区，扩大缓冲区
+				grown := make([]byte, need)
+				copy(grown, buf[:n])
+				buf = grown
+			}
+		}
+		if err != nil {
+			return buf[:n], err
+		}
+	}
+	return buf[:n], nil
+}
+
+// 是否为 GREASE 值（RFC 8701，0x0a0a、0x1a1a ... 0xfafa），用于加密套件、扩展、supported_groups 等
+// 客户端（例如 Chrome）每次连接随机选择 GREASE 值，解析时排除这些值，避免 JA3 指纹等在每次连接时都不同
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// 是否为 GREASE ALPN 协议名称（RFC 8701，两个字节都为同一个 0x?a，例如 "\x0a\x0a"）
+func isGREASEALPN(proto []byte) bool {
+	return len(proto) == 2 && isGREASE(uint16(proto[0])<<8|uint16(proto[1]))
+}
+
+// 解析 TLS ClientHello（TLS 记录头 + 握手消息），返回其中解析出的信息
+// 加密套件、扩展、supported_groups、ALPN 中的 GREASE 值会被跳过（只检查格式，不出现在结果中）
+func parseClientHello(buf []byte) (*clientHelloMsg, error) {
+	r := byteReader(buf)
+
+	// TLS 记录头：类型(1) + 版本(2) + 长度(2)
+	var contentType uint8
+	var recordVersion, recordLength uint16
+	if !r.readUint8(&contentType) || !r.readUint16(&recordVersion) || !r.readUint16(&recordLength) {
+		return nil, errors.New("TLS 记录头不完整")
+	}
+	if recordType(contentType) != recordTypeHandshake {
+		return nil, fmt.Errorf("不是 TLS 握手记录 (类型 0x%02x)", contentType)
+	}
+	var record []byte
+	if !r.readBytes(int(recordLength), &record) {
+		return nil, fmt.Errorf("TLS 记录不完整 (需要 %d 字节, 实际 %d 字节)", recordLength, len(r))
+	}
+
+	// 握手消息头：类型(1) + 长度(3)
+	hs := byteReader(record)
+	var msgType uint8
+	var msgLength int
+	if !hs.readUint8(&msgType) || !hs.readUint24(&msgLength) {
+		return nil, errors.New("握手消息头不完整")
+	}
+	if msgType != typeClientHello {
+		return nil, fmt.Errorf("不是 ClientHello 消息 (类型 0x%02x)", msgType)
+	}
+	var body []byte
+	if !hs.readBytes(msgLength, &body) {
+		return nil, fmt.Errorf("ClientHello 不完整 (需要 %d 字节, 实际 %d 字节)", msgLength, len(hs))
+	}
+
+	m := &clientHelloMsg{raw: record[:4+msgLength]}
+	s := byteReader(body)
+
+	// 版本(2) + 随机数(32)
+	if !s.readUint16(&m.vers) || !s.readBytes(32, &m.random) {
+		return nil, errors.New("ClientHello 版本或随机数不完整")
+	}
+
+	// 会话 ID
+	var sessionID byteReader
+	if !s.readVector8(&sessionID) || len(sessionID) > 32 {
+		return nil, errors.New("ClientHello 会话 ID 无效")
+	}
+	m.sessionID = sessionID
+
+	// 加密套件
+	var cipherSuites byteReader
+	if !s.readVector16(&cipherSuites) || len(cipherSuites)%2 != 0 {
+		return nil, errors.New("ClientHello 加密套件列表无效")
+	}
+	for !cipherSuites.empty() {
+		var suite uint16
+		cipherSuites.readUint16(&suite)
+		if isGREASE(suite) {
+			continue
+		}
+		m.cipherSuites = append(m.cipherSuites, suite)
+		if suite == scsvRenegotiation {
+			m.secureRenegotiationSupported = true
+		}
+	}
+
+	// 压缩方法
+	var compressionMethods byteReader
+	if !s.readVector8(&compressionMethods) {
+		return nil, errors.New("ClientHello 压缩方法列表无效")
+	}
+	m.compressionMethods = compressionMethods
+
+	// 扩展是可选的（没有扩展自然也就没有 SNI）
+	if s.empty() {
+		return m, nil
+	}
+	var extensions byteReader
+	if !s.readVector16(&extensions) || !s.empty() {
+		return nil, errors.New("ClientHello 扩展列表无效")
+	}
+
+	for !extensions.empty() {
+		var extension uint16
+		var extData byteReader
+		if !extensions.readUint16(&extension) || !extensions.readVector16(&extData) {
+			return nil, errors.New("ClientHello 扩展格式无效")
+		}
+
+		if isGREASE(extension) { // GREASE 扩展的内容是任意的，直接跳过
+			continue
+		}
+		m.extensions = append(m.extensions, extension)
+
+		switch extension {
+		case extensionServerName:
+			serverName, err := parseServerNameExtension(extData)
+			if err != nil {
+				return nil, err
+			}
+			m.serverName = serverName
+		case extensionALPN:
+			protocols, err := parseALPNExtension(extData)
+			if err != nil {
+				return nil, err
+			}
+			m.alpnProtocols = protocols
+		case extensionSupportedCurves:
+			var curves byteReader
+			if !extData.readVector16(&curves) || len(curves)%2 != 0 || !extData.empty() {
+				return nil, errors.New("supported_groups 扩展格式无效")
+			}
+			for !curves.empty() {
+				var curve uint16
+				curves.readUint16(&curve)
+				if !isGREASE(curve) {
+					m.supportedCurves = append(m.supportedCurves, CurveID(curve))
+				}
+			}
+		case extensionSupportedPoints:
+			var points byteReader
+			if !extData.readVector8(&points) || !extData.empty() {
+				return nil, errors.New("ec_point_formats 扩展格式无效")
+			}
+			m.supportedPoints = points
+		}
+	}
+
+	return m, nil
+}
+
+// 解析 server_name 扩展，返回其中的 host_name（RFC 6066 第 3 节）
+func parseServerNameExtension(data byteReader) (string, error) {
+	var nameList byteReader
+	if !data.readVector16(&nameList) || nameList.empty() || !data.empty() {
+		return "", errors.New("server_name 扩展格式无效")
+	}
+	for !nameList.empty() {
+		var nameType uint8
+		var name byteReader
+		if !nameList.readUint8(&nameType) || !nameList.readVector16(&name) {
+			return "", errors.New("server_name 条目格式无效")
+		}
+		if nameType != 0 { // 只处理 host_name 类型
+			continue
+		}
+		if len(name) == 0 {
+			return "", errors.New("server_name 中的 host_name 为空")
+		}
+		return string(name), nil
+	}
+	return "", nil
+}
+
+// 解析 ALPN 扩展，返回客户端提供的协议列表（RFC 7301 第 3.1 节，不包括 GREASE）
+func parseALPNExtension(data byteReader) ([]string, error) {
+	var protoList byteReader
+	if !data.readVector16(&protoList) || protoList.empty() || !data.empty() {
+		return nil, errors.New("ALPN 扩展格式无效")
+	}
+	var protocols []string
+	for !protoList.empty() {
+		var proto byteReader
+		if !protoList.readVector8(&proto) || proto.empty() {
+			return nil, errors.New("ALPN 协议名称无效")
+		}
+		if isGREASEALPN(proto) {
+			continue
+		}
+		protocols = append(protocols, string(proto))
+	}
+	return protocols, nil
+}
@@ -1,4 +1,4 @@
-package main
+package sniproxy
 
 import (
     "crypto/x509"
@@ -206,6 +206,7 @@ type clientHelloMsg struct {
     secureRenegotiation          []byte
     secureRenegotiationSupported bool
     alpnProtocols                []string
+    extensions                   []uint16 // 按出现顺序的扩展类型（用于计算 JA3 指纹）
 }
 
 
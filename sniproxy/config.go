@@ -0,0 +1,880 @@
+package sniproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// 配置（与配置文件的结构相同）
+type Config struct {
+	ForwardRules        []string `yaml:"rules,omitempty"`
+	ListenAddr          addrList `yaml:"listen_addr,omitempty"`
+	EnableSocks         bool     `yaml:"enable_socks5,omitempty"`
+	SocksAddr           string   `yaml:"socks_addr,omitempty"`
+	SocksUser           string   `yaml:"socks_user,omitempty"`
+	SocksPass           string   `yaml:"socks_pass,omitempty"`
+	AllowAllHosts       bool     `yaml:"allow_all_hosts,omitempty"`
+	ForwardPort         int      `yaml:"forward_port,omitempty"`
+	Mode                string   `yaml:"mode,omitempty"`
+	LegacyRuleMatch     bool     `yaml:"legacy_rule_match,omitempty"`
+	LogFormat           string   `yaml:"log_format,omitempty"`
+	NoColor             bool     `yaml:"no_color,omitempty"`
+	MinLogLevel         string   `yaml:"min_log_level,omitempty"`
+	MaxLogSizeMB        int      `yaml:"max_log_size_mb,omitempty"`
+	MaxLogBackups       int      `yaml:"max_log_backups,omitempty"`
+	MaxLogAgeDays       int      `yaml:"max_log_age_days,omitempty"`
+	SyslogAddr          string   `yaml:"syslog_addr,omitempty"`
+	SyslogFacility      string   `yaml:"syslog_facility,omitempty"`
+	MetricsAddr         string   `yaml:"metrics_addr,omitempty"`
+	StatsdAddr          string   `yaml:"statsd_addr,omitempty"`
+	StatsdPrefix        string   `yaml:"statsd_prefix,omitempty"`
+	ShutdownTimeout     int      `yaml:"shutdown_timeout,omitempty"`
+	ConfigPollInterval  int      `yaml:"config_poll_interval,omitempty"`
+	HandshakeTimeout    int      `yaml:"handshake_timeout,omitempty"`
+	IdleTimeout         int      `yaml:"idle_timeout,omitempty"`
+	MaxConnLifetime     int      `yaml:"max_conn_lifetime,omitempty"`
+	DNSCacheTTL         int      `yaml:"dns_cache_ttl,omitempty"`
+	DNSNegativeTTL      int      `yaml:"dns_negative_ttl,omitempty"`
+	OutboundAddr        string   `yaml:"outbound_addr,omitempty"`
+	OutboundInterface   string   `yaml:"outbound_interface,omitempty"`
+	BlockPrivateIPs     bool     `yaml:"block_private_ips,omitempty"`
+	AllowedPrivateIPs   []string `yaml:"allowed_private_ips,omitempty"`
+	AllowedClients      []string `yaml:"allowed_clients,omitempty"`
+	MaxConnsPerIP       int      `yaml:"max_conns_per_ip,omitempty"`
+	MaxConnections      int      `yaml:"max_connections,omitempty"`
+	MaxConnectionsWait  int      `yaml:"max_connections_wait,omitempty"`
+	NumWorkers          int      `yaml:"num_workers,omitempty"`
+	WorkerQueueSize     int      `yaml:"worker_queue_size,omitempty"`
+	WorkerQueueFull     string   `yaml:"worker_queue_full,omitempty"`
+	ConnRatePerIP       float64  `yaml:"conn_rate_per_ip,omitempty"`
+	ConnBurstPerIP      int      `yaml:"conn_burst_per_ip,omitempty"`
+	DefaultUpstream     string   `yaml:"default_upstream,omitempty"`
+	BlockedHosts        []string `yaml:"blocked_hosts,omitempty"`
+	Transparent         string   `yaml:"transparent,omitempty"`
+	SendProxyProtocol   string   `yaml:"send_proxy_protocol,omitempty"`
+	AcceptProxyProtocol bool     `yaml:"accept_proxy_protocol,omitempty"`
+	BlockedJA3          []string `yaml:"blocked_ja3,omitempty"`
+	AllowedJA3          []string `yaml:"allowed_ja3,omitempty"`
+	CopyBufferSize      int      `yaml:"copy_buffer_size,omitempty"`
+	ReadBufferSize      int      `yaml:"read_buffer_size,omitempty"`
+	TCPKeepAlive        int      `yaml:"tcp_keepalive,omitempty"`
+	DialRetries         int      `yaml:"dial_retries,omitempty"`
+	LoadBalance         string   `yaml:"load_balance,omitempty"`
+	IPVersion           ipFamily `yaml:"ip_version,omitempty"`
+	DialRetryBackoff    int      `yaml:"dial_retry_backoff,omitempty"`
+	DialTimeout         int      `yaml:"dial_timeout,omitempty"`
+	DNSServers          []string `yaml:"dns_servers,omitempty"`
+	DoHURL              string   `yaml:"doh_url,omitempty"`
+	DoHFallback         bool     `yaml:"doh_fallback,omitempty"`
+	RejectTLSAlert      bool     `yaml:"reject_tls_alert,omitempty"`
+	RejectAction        string   `yaml:"reject_action,omitempty"`
+	RejectTarpitTime    int      `yaml:"reject_tarpit_time,omitempty"`
+	AdminAddr           string   `yaml:"admin_addr,omitempty"`
+	AdminToken          string   `yaml:"admin_token,omitempty"`
+	AdminPersist        bool     `yaml:"admin_persist,omitempty"`
+	PprofAddr           string   `yaml:"pprof_addr,omitempty"`
+	MirrorAddr          string   `yaml:"mirror_addr,omitempty"`
+	CaptureFile         string   `yaml:"capture_file,omitempty"`
+	CaptureBytes        int      `yaml:"capture_bytes,omitempty"`
+	ReusePort           bool     `yaml:"reuse_port,omitempty"`
+	SocketActivation    bool     `yaml:"socket_activation,omitempty"`
+	UnixSocketMode      string   `yaml:"unix_socket_mode,omitempty"`
+	SoRcvbuf            int      `yaml:"so_rcvbuf,omitempty"`
+	SoSndbuf            int      `yaml:"so_sndbuf,omitempty"`
+	SoMark              int      `yaml:"so_mark,omitempty"`
+	DSCP                int      `yaml:"dscp,omitempty"`
+	DSCPClient          bool     `yaml:"dscp_client,omitempty"`
+
+	CircuitBreakerFailures int `yaml:"circuit_breaker_failures,omitempty"` // 目标连续连接失败多少次后熔断（0 为不熔断）
+	CircuitBreakerWindow   int `yaml:"circuit_breaker_window,omitempty"`   // 统计连续失败次数的时间窗口（秒）
+	CircuitBreakerCooldown int `yaml:"circuit_breaker_cooldown,omitempty"` // 熔断的时间（秒），之后放行一个连接试探目标是否恢复
+
+	Listeners []*ListenerConfig `yaml:"listeners,omitempty"` // 多个监听各自的规则
+
+	Include    []string `yaml:"include,omitempty"`     // 合并其他配置文件
+	IncludeDir string   `yaml:"include_dir,omitempty"` // 合并该目录下的所有 .yaml、.yml 配置文件（按文件名顺序）
+
+	Schedule *ScheduleConfig `yaml:"schedule,omitempty"` // 允许转发的时间段（未配置时任何时间都允许）
+
+	Hosts map[string]addrList `yaml:"hosts,omitempty"` // 静态 hosts（域名 => IP 或 IP 列表），优先于 DNS 解析
+
+	MaxBytesPerConn int64            `yaml:"max_bytes_per_conn,omitempty"` // 单个连接最多转发的字节数（两个方向合计）
+	GlobalRateLimit int64            `yaml:"global_rate_limit,omitempty"`  // 所有连接共用的带宽限制（字节/秒）
+	RuleRateLimits  map[string]int64 `yaml:"rule_rate_limits,omitempty"`   // 各规则的带宽限制（规则 => 字节/秒），匹配该规则的连接共用
+
+	GeoIPDB          string              `yaml:"geoip_db,omitempty"`          // MaxMind GeoIP 数据库（.mmdb）路径
+	AllowedCountries []string            `yaml:"allowed_countries,omitempty"` // 只允许这些国家或地区的客户端
+	BlockedCountries []string            `yaml:"blocked_countries,omitempty"` // 拒绝这些国家或地区的客户端
+	CountryRules     map[string][]string `yaml:"country_rules,omitempty"`     // 各国家或地区的客户端优先使用的规则（国家或地区代码 => 规则列表）
+
+	OTLPEndpoint    string            `yaml:"otlp_endpoint,omitempty"`     // OTLP/HTTP 追踪数据接收端（例如 http://127.0.0.1:4318/v1/traces）
+	OTLPHeaders     map[string]string `yaml:"otlp_headers,omitempty"`      // 发送追踪数据时附加的 HTTP 头部（例如认证）
+	OTLPServiceName string            `yaml:"otlp_service_name,omitempty"` // 追踪数据中的 service.name（默认 sniproxy）
+
+	WebhookURL      string   `yaml:"webhook_url,omitempty"`      // 事件通知的 webhook 地址
+	WebhookEvents   []string `yaml:"webhook_events,omitempty"`   // 发送的事件类型（默认全部）
+	WebhookInterval int      `yaml:"webhook_interval,omitempty"` // 两次发送的最短间隔（秒，默认 30）
+
+	Path    string `yaml:"-"` // 配置文件路径（LoadConfig 时设置，admin_persist 时写回该文件）
+	LogFile string `yaml:"-"` // 日志文件（命令行参数 -l，为空时不写入文件）
+	Debug   bool   `yaml:"-"` // 调试模式（命令行参数 -d，输出所有级别的日志）
+
+	listeners    []*ListenerConfig // 所有监听（包括顶层配置的监听）
+	blockedHosts []*forwardRule    // 解析后的 blocked_hosts
+	minLogLevel  Level             // 解析后的 min_log_level
+	outboundIP   net.IP            // 解析后的 outbound_addr
+
+	allowedPrivateNets []*net.IPNet    // 解析后的 allowed_private_ips
+	allowedClientNets  []*net.IPNet    // 解析后的 allowed_clients
+	defaultTarget      string          // 解析后的 default_upstream（IP:端口 或 域名:端口）
+	webhookEvents      map[string]bool // 解析后的 webhook_events（事件类型 => 是否发送）
+	usesEnv            bool            // 配置文件中是否引用了环境变量
+	format             string          // 配置文件格式（yaml、json、toml）
+	unixSocketMode     os.FileMode     // 解析后的 unix_socket_mode
+	blockedJA3         map[string]bool // 解析后的 blocked_ja3
+	allowedJA3         map[string]bool // 解析后的 allowed_ja3
+
+	hosts    map[string][]net.IP // 解析后的 hosts（域名为小写）
+	resolver *net.Resolver       // 使用 dns_servers 或 doh_url 的解析器（都未配置时为空，使用系统 DNS）
+
+	globalLimiter *bandwidthLimiter // global_rate_limit 的带宽限制（未配置时为空）
+
+	geoIP            *geoIPDB                  // 加载的 geoip_db（未配置时为空）
+	allowedCountries map[string]bool           // 解析后的 allowed_countries
+	blockedCountries map[string]bool           // 解析后的 blocked_countries
+	countryRules     map[string][]*forwardRule // 解析后的 country_rules（国家或地区代码为大写）
+
+	schedule *schedule // 解析后的 schedule（未配置时为空）
+}
+
+const (
+	defaultForwardPort      = 443 // 默认转发至的目标端口
+	defaultShutdownTimeout  = 10  // 默认退出时等待已有连接结束的时间（秒）
+	defaultHandshakeTimeout = 10  // 默认读取 ClientHello 的超时时间（秒）
+	defaultIdleTimeout      = 300 // 默认连接空闲超时时间（秒）
+	defaultTCPKeepAlive     = 30  // 默认 TCP keepalive 探测间隔（秒）
+	defaultDialRetryBackoff = 200 // 默认第一次重试连接目标前的等待时间（毫秒）
+	defaultDialTimeout      = 10  // 默认连接目标的超时时间（秒）
+)
+
+const maxSocketBuffer = 64 << 20 // so_rcvbuf、so_sndbuf 的最大值（字节）
+
+// 可以写成单个地址或地址列表的配置项（例如 listen_addr: ":443" 或 listen_addr: [":443", ":8443"]）
+type addrList []string
+
+// 解析单个地址或地址列表
+func (l *addrList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		*l = addrList{s}
+		return nil
+	}
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// 出站 IP 版本（ip_version），0 为都使用，可以写成 4、6 或 auto、ipv4、ipv6
+type ipFamily int
+
+// 解析数字或 auto、ipv4、ipv6
+func (v *ipFamily) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var n int
+	if err := unmarshal(&n); err == nil {
+		*v = ipFamily(n)
+		return nil
+	}
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	switch strings.ToLower(s) {
+	case "auto", "":
+		*v = 0
+	case "ipv4":
+		*v = 4
+	case "ipv6":
+		*v = 6
+	default:
+		return fmt.Errorf("ip_version 无效: %s（可选 auto、ipv4、ipv6）", s)
+	}
+	return nil
+}
+
+// 检查 host:port 格式的地址（端口可以是数字或服务名，host 可以为空）
+func checkAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return err
+	}
+	return nil
+}
+
+// 读取并解析配置文件（配置在 New、Reload 时才检查）
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path) // 读取配置文件
+	if err != nil {
+		return nil, fmt.Errorf("配置文件读取失败: %v", err)
+	}
+	cfg, err := parseConfigData(data, configFormat(path))
+	if err != nil {
+		return nil, fmt.Errorf("配置文件解析失败: %v", err)
+	}
+	if err := loadIncludes(cfg, path); err != nil { // 合并 include、include_dir 中的配置文件（合并后整体检查）
+		return nil, err
+	}
+	cfg.Path = path
+	return cfg, nil
+}
+
+// 根据文件扩展名判断配置文件格式：.json 为 JSON，.toml 为 TOML，其他（.yaml、.yml 等）为 YAML
+// name 可以是远程地址，此时只看其中的路径（忽略 ? 查询参数）
+func configFormat(name string) string {
+	if u, err := url.Parse(name); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		name = u.Path
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return "json"
+	case ".toml":
+		return "toml"
+	}
+	return "yaml"
+}
+
+// 解析配置（format 为 yaml、json、toml）
+// JSON、TOML 先解析为通用的键值再转换为 YAML 解析，选项名称、取值和处理方式与 YAML 格式完全相同
+func parseConfigData(data []byte, format string) (*Config, error) {
+	var err error
+	switch format {
+	case "json":
+		var values map[string]any
+		if err = json.Unmarshal(data, &values); err != nil {
+			return nil, err
+		}
+		data, err = yaml.Marshal(values)
+	case "toml":
+		var values map[string]any
+		if err = toml.Unmarshal(data, &values); err != nil {
+			return nil, err
+		}
+		data, err = yaml.Marshal(values)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{format: format}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.usesEnv, err = expandEnv(cfg); err != nil { // 展开 ${VAR} 形式引用的环境变量
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// 解析配置文件的内容（例如从远程地址获取的配置文件，此时 Path 为空，admin_persist 不会写回）
+// name 为配置文件的名称或地址，根据其扩展名判断格式（同 LoadConfig）
+func ParseConfig(data []byte, name string) (*Config, error) {
+	cfg, err := parseConfigData(data, configFormat(name))
+	if err != nil {
+		return nil, fmt.Errorf("配置文件解析失败: %v", err)
+	}
+	if len(cfg.Include) > 0 || cfg.IncludeDir != "" { // 没有配置文件路径，无法确定相对路径
+		return nil, errors.New("配置文件不是本地文件时不能使用 include、include_dir")
+	}
+	return cfg, nil
+}
+
+// 检查配置，返回填充了默认值和解析结果的副本（不修改传入的配置，同一个配置可以多次使用）
+// 配置有多处错误时返回所有错误（每行一个）
+func prepareConfig(c *Config) (*Config, error) {
+	cfg := new(Config)
+	*cfg = *c
+	cfg.listeners, cfg.blockedHosts = nil, nil // 传入的可能是已检查过的配置
+
+	// 检查完整个配置后一起返回所有错误
+	var errs []error
+	var err error
+	if cfg.ForwardPort == 0 && cfg.Mode == listenModeHTTP { // http 模式下未配置 forward_port 时默认转发至 80 端口
+		cfg.ForwardPort = defaultHTTPForwardPort
+	} else if cfg.ForwardPort == 0 { // 未配置 forward_port 时默认转发至 443 端口
+		cfg.ForwardPort = defaultForwardPort
+	}
+	if cfg.ForwardPort < 1 || cfg.ForwardPort > 65535 {
+		errs = append(errs, fmt.Errorf("配置文件中 forward_port 无效: %d（范围 1-65535）!", cfg.ForwardPort))
+	}
+	if cfg.EnableSocks && cfg.SocksAddr == "" { // 如果启用了前置代理，则必须配置 socks_addr
+		errs = append(errs, errors.New("配置文件中 enable_socks5 等于 true 时 socks_addr 不能为空!"))
+	} else if cfg.EnableSocks {
+		if err := checkAddr(cfg.SocksAddr); err != nil {
+			errs = append(errs, fmt.Errorf("配置文件中 socks_addr 无效: %v!", err))
+		}
+	}
+	switch cfg.LogFormat {
+	case "": // 未配置 log_format 时默认为文本格式
+		cfg.LogFormat = logFormatText
+	case logFormatText, logFormatJSON:
+	default:
+		errs = append(errs, fmt.Errorf("配置文件中 log_format 无效: %s（可选 text、json）!", cfg.LogFormat))
+	}
+	if cfg.StatsdAddr != "" && checkAddr(cfg.StatsdAddr) != nil {
+		errs = append(errs, fmt.Errorf("配置文件中 statsd_addr 无效: %s（格式为 IP:端口 或 域名:端口）!", cfg.StatsdAddr))
+	}
+	if cfg.StatsdPrefix = strings.Trim(cfg.StatsdPrefix, "."); cfg.StatsdPrefix == "" { // 未配置 statsd_prefix 时默认为 sniproxy
+		cfg.StatsdPrefix = defaultStatsdPrefix
+	}
+	if cfg.OTLPEndpoint != "" {
+		if u, err := url.Parse(cfg.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("配置文件中 otlp_endpoint 无效: %s（例如 http://127.0.0.1:4318/v1/traces）!", cfg.OTLPEndpoint))
+		}
+	}
+	if cfg.OTLPServiceName == "" { // 未配置 otlp_service_name 时默认为 sniproxy
+		cfg.OTLPServiceName = defaultOTLPServiceName
+	}
+	if cfg.WebhookURL != "" {
+		if u, err := url.Parse(cfg.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("配置文件中 webhook_url 无效: %s（需要是 http:// 或 https:// 开头的地址）!", cfg.WebhookURL))
+		}
+	}
+	if cfg.WebhookInterval == 0 { // 未配置 webhook_interval 时默认 30 秒
+		cfg.WebhookInterval = defaultWebhookInterval
+	}
+	if cfg.WebhookInterval < 0 {
+		errs = append(errs, fmt.Errorf("配置文件中 webhook_interval 无效: %d（不能小于 0）!", cfg.WebhookInterval))
+	}
+	cfg.webhookEvents = make(map[string]bool)
+	events := cfg.WebhookEvents
+	if len(events) == 0 { // 未配置 webhook_events 时发送所有类型的事件
+		events = webhookEventTypes
+	}
+	for _, event := range webhookEventTypes {
+		cfg.webhookEvents[event] = false
+	}
+	for _, event := range events {
+		if _, ok := cfg.webhookEvents[event]; !ok {
+			errs = append(errs, fmt.Errorf("配置文件中 webhook_events 无效: %s（可选 %s）!", event, strings.Join(webhookEventTypes, "、")))
+		}
+		cfg.webhookEvents[event] = true
+	}
+	if cfg.SyslogAddr != "" {
+		if _, _, err := parseSyslogAddr(cfg.SyslogAddr); err != nil {
+			errs = append(errs, fmt.Errorf("配置文件中 syslog_addr 无效: %s（格式为 udp://IP:端口 或 tcp://IP:端口）: %v!", cfg.SyslogAddr, err))
+		}
+	}
+	if cfg.SyslogFacility == "" { // 未配置 syslog_facility 时默认为 daemon
+		cfg.SyslogFacility = defaultSyslogFacility
+	}
+	cfg.SyslogFacility = strings.ToLower(cfg.SyslogFacility)
+	if _, ok := syslogFacilities[cfg.SyslogFacility]; !ok {
+		errs = append(errs, fmt.Errorf("配置文件中 syslog_facility 无效: %s（可选 user、daemon、local0-local7 等）!", cfg.SyslogFacility))
+	}
+	if cfg.ShutdownTimeout == 0 { // 未配置 shutdown_timeout 时默认等待 10 秒
+		cfg.ShutdownTimeout = defaultShutdownTimeout
+	}
+	if cfg.AdminPersist && (len(cfg.Include) > 0 || cfg.IncludeDir != "") { // 写回时会把合并后的规则全部写入主配置文件
+		errs = append(errs, errors.New("配置文件中 admin_persist 不能与 include、include_dir 同时使用!"))
+	}
+	if cfg.AdminPersist && cfg.format != "yaml" { // 写回时使用 YAML 格式
+		errs = append(errs, errors.New("配置文件中 admin_persist 只能用于 YAML 格式的配置文件!"))
+	}
+	if cfg.AdminPersist && cfg.usesEnv { // 写回时会把环境变量的值（例如密码）写入配置文件
+		errs = append(errs, errors.New("配置文件中 admin_persist 不能与环境变量引用 ${VAR} 同时使用!"))
+	}
+	if cfg.ConfigPollInterval < 0 {
+		errs = append(errs, fmt.Errorf("配置文件中 config_poll_interval 无效: %d（不能小于 0）!", cfg.ConfigPollInterval))
+	}
+	if cfg.ShutdownTimeout < 0 {
+		errs = append(errs, fmt.Errorf("配置文件中 shutdown_timeout 无效: %d（不能小于 0）!", cfg.ShutdownTimeout))
+	}
+	if cfg.HandshakeTimeout == 0 { // 未配置 handshake_timeout 时默认 10 秒
+		cfg.HandshakeTimeout = defaultHandshakeTimeout
+	}
+	if cfg.IdleTimeout == 0 { // 未配置 idle_timeout 时默认 300 秒
+		cfg.IdleTimeout = defaultIdleTimeout
+	}
+	if cfg.HandshakeTimeout < 0 || cfg.IdleTimeout < 0 || cfg.MaxConnLifetime < 0 {
+		errs = append(errs, errors.New("配置文件中 handshake_timeout、idle_timeout、max_conn_lifetime 不能小于 0!"))
+	}
+	if cfg.TCPKeepAlive == 0 { // 未配置 tcp_keepalive 时默认 30 秒，小于 0 时关闭 keepalive
+		cfg.TCPKeepAlive = defaultTCPKeepAlive
+	}
+	if cfg.DialRetryBackoff == 0 { // 未配置 dial_retry_backoff 时默认 200 毫秒
+		cfg.DialRetryBackoff = defaultDialRetryBackoff
+	}
+	if cfg.DialTimeout == 0 { // 未配置 dial_timeout 时默认 10 秒
+		cfg.DialTimeout = defaultDialTimeout
+	}
+	if cfg.DialRetries < 0 || cfg.DialRetryBackoff < 0 || cfg.DialTimeout < 0 {
+		errs = append(errs, errors.New("配置文件中 dial_retries、dial_retry_backoff、dial_timeout 不能小于 0!"))
+	}
+	if cfg.CaptureBytes == 0 { // 未配置 capture_bytes 时每个连接最多保存 4096 字节
+		cfg.CaptureBytes = defaultCaptureBytes
+	}
+	if cfg.CaptureBytes < 0 || cfg.CaptureBytes > maxCaptureBytes {
+		errs = append(errs, fmt.Errorf("配置文件中 capture_bytes 无效: %d（范围 1-%d）!", cfg.CaptureBytes, maxCaptureBytes))
+	}
+	if cfg.CircuitBreakerWindow == 0 { // 未配置时默认 60 秒内连续失败才熔断、熔断 30 秒
+		cfg.CircuitBreakerWindow = defaultCircuitBreakerWindow
+	}
+	if cfg.CircuitBreakerCooldown == 0 {
+		cfg.CircuitBreakerCooldown = defaultCircuitBreakerCooldown
+	}
+	if cfg.CircuitBreakerFailures < 0 || cfg.CircuitBreakerWindow < 0 || cfg.CircuitBreakerCooldown < 0 {
+		errs = append(errs, errors.New("配置文件中 circuit_breaker_failures、circuit_breaker_window、circuit_breaker_cooldown 不能小于 0!"))
+	}
+	if cfg.CopyBufferSize == 0 { // 未配置 copy_buffer_size 时默认 32KB
+		cfg.CopyBufferSize = defaultCopyBufferSize
+	}
+	if cfg.SoRcvbuf < 0 || cfg.SoRcvbuf > maxSocketBuffer || cfg.SoSndbuf < 0 || cfg.SoSndbuf > maxSocketBuffer {
+		errs = append(errs, fmt.Errorf("配置文件中 so_rcvbuf、so_sndbuf 无效: %d、%d（范围 0-%d，0 为使用系统默认值）!", cfg.SoRcvbuf, cfg.SoSndbuf, maxSocketBuffer))
+	}
+	if cfg.CopyBufferSize < 0 {
+		errs = append(errs, fmt.Errorf("配置文件中 copy_buffer_size 无效: %d（不能小于 0）!", cfg.CopyBufferSize))
+	}
+	if cfg.ReadBufferSize == 0 { // 未配置 read_buffer_size 时默认 2048 字节
+		cfg.ReadBufferSize = initialReadSize
+	}
+	if cfg.ReadBufferSize < minReadBufferSize || cfg.ReadBufferSize > maxClientHelloSize {
+		errs = append(errs, fmt.Errorf("配置文件中 read_buffer_size 无效: %d（范围 %d-%d）!", cfg.ReadBufferSize, minReadBufferSize, maxClientHelloSize))
+	}
+	if cfg.DNSNegativeTTL == 0 { // 未配置 dns_negative_ttl 时默认 10 秒
+		cfg.DNSNegativeTTL = defaultDNSNegativeTTL
+	}
+	if len(cfg.DNSServers) > 0 {
+		servers := make([]string, 0, len(cfg.DNSServers))
+		for _, server := range cfg.DNSServers {
+			addr, err := parseDNSServer(server)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("配置文件中 dns_servers 无效: %v!", err))
+				continue
+			}
+			servers = append(servers, addr)
+		}
+		if len(servers) > 0 {
+			cfg.resolver = newResolver(servers, outboundControl(cfg))
+		}
+	}
+	if cfg.DoHURL != "" {
+		if len(cfg.DNSServers) > 0 {
+			errs = append(errs, errors.New("配置文件中 dns_servers 和 doh_url 不能同时配置!"))
+		} else if u, err := parseDoHURL(cfg.DoHURL); err != nil {
+			errs = append(errs, fmt.Errorf("配置文件中 doh_url 无效: %s（%v）!", cfg.DoHURL, err))
+		} else {
+			cfg.resolver = newDoHResolver(u, outboundControl(cfg))
+		}
+	}
+	if cfg.DNSCacheTTL < 0 || cfg.DNSNegativeTTL < 0 {
+		errs = append(errs, errors.New("配置文件中 dns_cache_ttl、dns_negative_ttl 不能小于 0!"))
+	}
+	if cfg.OutboundAddr != "" {
+		if cfg.outboundIP = net.ParseIP(cfg.OutboundAddr); cfg.outboundIP == nil {
+			errs = append(errs, fmt.Errorf("配置文件中 outbound_addr 无效: %s（需要是 IP 地址）!", cfg.OutboundAddr))
+		} else if err := checkLocalAddr(cfg.outboundIP); err != nil {
+			errs = append(errs, fmt.Errorf("配置文件中 outbound_addr 无效: %v!", err))
+		}
+	}
+	switch cfg.SendProxyProtocol {
+	case "", proxyProtocolV1, proxyProtocolV2:
+	default:
+		errs = append(errs, fmt.Errorf("配置文件中 send_proxy_protocol 无效: %s（可选 v1、v2）!", cfg.SendProxyProtocol))
+	}
+	if cfg.IPVersion != 0 && cfg.IPVersion != 4 && cfg.IPVersion != 6 {
+		errs = append(errs, fmt.Errorf("配置文件中 ip_version 无效: %d（可选 4、6，或 auto、ipv4、ipv6）!", cfg.IPVersion))
+	}
+	switch cfg.LoadBalance {
+	case "", loadBalanceFailover, loadBalanceRoundRobin:
+	default:
+		errs = append(errs, fmt.Errorf("配置文件中 load_balance 无效: %s（可选 failover、round_robin）!", cfg.LoadBalance))
+	}
+	if cfg.AdminAddr != "" {
+		if err := checkAddr(cfg.AdminAddr); err != nil {
+			errs = append(errs, fmt.Errorf("配置文件中 admin_addr 无效: %v!", err))
+		}
+		if cfg.AdminToken == "" { // 管理 API 可以修改规则，不允许无认证访问
+			errs = append(errs, errors.New("配置文件中 admin_addr 需要与 admin_token 一起配置!"))
+		}
+	}
+	if cfg.MirrorAddr != "" {
+		if _, err := parseHostPort(cfg.MirrorAddr, 0); err != nil || checkAddr(cfg.MirrorAddr) != nil {
+			errs = append(errs, fmt.Errorf("配置文件中 mirror_addr 无效: %s（格式为 IP:端口 或 域名:端口）!", cfg.MirrorAddr))
+		}
+	}
+	if cfg.PprofAddr != "" {
+		if err := checkAddr(cfg.PprofAddr); err != nil {
+			errs = append(errs, fmt.Errorf("配置文件中 pprof_addr 无效: %v!", err))
+		}
+	}
+	switch cfg.RejectAction {
+	case "": // 未配置 reject_action 时默认正常关闭连接
+		cfg.RejectAction = rejectActionClose
+	case rejectActionClose, rejectActionRST, rejectActionTarpit:
+	default:
+		errs = append(errs, fmt.Errorf("配置文件中 reject_action 无效: %s（可选 close、rst、tarpit）!", cfg.RejectAction))
+	}
+	if cfg.RejectTarpitTime == 0 { // 未配置 reject_tarpit_time 时默认 30 秒
+		cfg.RejectTarpitTime = defaultRejectTarpitTime
+	}
+	if cfg.RejectTarpitTime < 0 {
+		errs = append(errs, fmt.Errorf("配置文件中 reject_tarpit_time 无效: %d（不能小于 0）!", cfg.RejectTarpitTime))
+	}
+	switch cfg.Transparent {
+	case "", transparentRedirect, transparentTProxy:
+	default:
+		errs = append(errs, fmt.Errorf("配置文件中 transparent 无效: %s（可选 redirect、tproxy）!", cfg.Transparent))
+	}
+	if cfg.Transparent != "" && runtime.GOOS != "linux" {
+		errs = append(errs, errors.New("配置文件中 transparent 仅支持 Linux 系统!"))
+	}
+	if cfg.ReusePort && runtime.GOOS != "linux" {
+		errs = append(errs, errors.New("配置文件中 reuse_port 仅支持 Linux 系统!"))
+	}
+	if cfg.UnixSocketMode == "" { // 未配置 unix_socket_mode 时默认为 0660
+		cfg.UnixSocketMode = defaultUnixSocketMode
+	}
+	if cfg.unixSocketMode, err = parseUnixSocketMode(cfg.UnixSocketMode); err != nil {
+		errs = append(errs, fmt.Errorf("配置文件中 unix_socket_mode 无效: %v!", err))
+	}
+	if cfg.OutboundInterface != "" && runtime.GOOS != "linux" {
+		errs = append(errs, errors.New("配置文件中 outbound_interface 仅支持 Linux 系统!"))
+	}
+	if cfg.SoMark < 0 || int64(cfg.SoMark) > math.MaxUint32 {
+		errs = append(errs, fmt.Errorf("配置文件中 so_mark 无效: %d（范围 0-%d）!", cfg.SoMark, uint32(math.MaxUint32)))
+	} else if cfg.SoMark != 0 && runtime.GOOS != "linux" {
+		errs = append(errs, errors.New("配置文件中 so_mark 仅支持 Linux 系统!"))
+	}
+	if cfg.DSCP < 0 || cfg.DSCP > 63 { // DSCP 只有 6 位
+		errs = append(errs, fmt.Errorf("配置文件中 dscp 无效: %d（范围 0-63）!", cfg.DSCP))
+	} else if cfg.DSCP != 0 && runtime.GOOS != "linux" {
+		errs = append(errs, errors.New("配置文件中 dscp 仅支持 Linux 系统!"))
+	}
+	if cfg.DSCPClient && cfg.DSCP == 0 {
+		errs = append(errs, errors.New("配置文件中 dscp_client 需要与 dscp 一起配置!"))
+	}
+	if cfg.allowedPrivateNets, err = parseCIDRs(cfg.AllowedPrivateIPs); err != nil {
+		errs = append(errs, fmt.Errorf("配置文件中 allowed_private_ips 无效: %v!", err))
+	}
+	if cfg.MaxConnsPerIP < 0 || cfg.MaxConnections < 0 || cfg.MaxConnectionsWait < 0 {
+		errs = append(errs, errors.New("配置文件中 max_conns_per_ip、max_connections、max_connections_wait 不能小于 0!"))
+	}
+	if cfg.NumWorkers < 0 || cfg.WorkerQueueSize < 0 {
+		errs = append(errs, errors.New("配置文件中 num_workers、worker_queue_size 不能小于 0!"))
+	}
+	if cfg.WorkerQueueSize == 0 { // 未配置 worker_queue_size 时默认等于工作线程数
+		cfg.WorkerQueueSize = cfg.NumWorkers
+	}
+	switch cfg.WorkerQueueFull {
+	case "": // 未配置 worker_queue_full 时默认等待
+		cfg.WorkerQueueFull = workerQueueBlock
+	case workerQueueBlock, workerQueueDrop:
+	default:
+		errs = append(errs, fmt.Errorf("配置文件中 worker_queue_full 无效: %s（可选 block、drop）!", cfg.WorkerQueueFull))
+	}
+	if cfg.ConnRatePerIP < 0 || cfg.ConnBurstPerIP < 0 {
+		errs = append(errs, errors.New("配置文件中 conn_rate_per_ip、conn_burst_per_ip 不能小于 0!"))
+	}
+	if cfg.ConnRatePerIP > 0 && cfg.ConnBurstPerIP == 0 { // 未配置 conn_burst_per_ip 时默认为每秒速率（至少 1）
+		cfg.ConnBurstPerIP = int(math.Max(1, math.Ceil(cfg.ConnRatePerIP)))
+	}
+	if cfg.blockedJA3, err = parseJA3List(cfg.BlockedJA3); err != nil {
+		errs = append(errs, fmt.Errorf("配置文件中 blocked_ja3 无效: %v!", err))
+	}
+	if cfg.allowedJA3, err = parseJA3List(cfg.AllowedJA3); err != nil {
+		errs = append(errs, fmt.Errorf("配置文件中 allowed_ja3 无效: %v!", err))
+	}
+	if cfg.allowedClientNets, err = parseCIDRs(cfg.AllowedClients); err != nil {
+		errs = append(errs, fmt.Errorf("配置文件中 allowed_clients 无效: %v!", err))
+	}
+	if cfg.minLogLevel, err = parseLevel(cfg.MinLogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("配置文件中 min_log_level 无效: %v!", err))
+	}
+	if cfg.MaxLogSizeMB < 0 || cfg.MaxLogBackups < 0 || cfg.MaxLogAgeDays < 0 {
+		errs = append(errs, errors.New("配置文件中 max_log_size_mb、max_log_backups、max_log_age_days 不能小于 0!"))
+	}
+	if cfg.DefaultUpstream != "" {
+		if cfg.defaultTarget, err = parseHostPort(cfg.DefaultUpstream, cfg.ForwardPort); err != nil {
+			errs = append(errs, fmt.Errorf("配置文件中 default_upstream 无效: %v!", err))
+		}
+	}
+	errs = append(errs, parseListeners(cfg)...)
+	if cfg.schedule, err = parseSchedule(cfg.Schedule); err != nil {
+		errs = append(errs, fmt.Errorf("配置文件中 schedule 无效: %v!", err))
+	}
+	errs = append(errs, parseGeoIP(cfg)...)
+	errs = append(errs, parseRateLimits(cfg)...)
+	if cfg.MaxBytesPerConn < 0 {
+		errs = append(errs, fmt.Errorf("配置文件中 max_bytes_per_conn 无效: %d（不能小于 0）!", cfg.MaxBytesPerConn))
+	}
+	cfg.hosts = make(map[string][]net.IP, len(cfg.Hosts))
+	for host, addrs := range cfg.Hosts {
+		var ips []net.IP
+		for _, addr := range addrs {
+			ip := net.ParseIP(strings.TrimSpace(addr))
+			if ip == nil {
+				errs = append(errs, fmt.Errorf("配置文件中 hosts 无效: %s 的 IP 地址 %q 无效!", host, addr))
+				continue
+			}
+			ips = append(ips, ip)
+		}
+		if len(addrs) == 0 {
+			errs = append(errs, fmt.Errorf("配置文件中 hosts 无效: %s 没有 IP 地址!", host))
+		}
+		name, err := normalizeServerName(host)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("配置文件中 hosts 无效: 域名 %s 无效: %v!", host, err))
+			continue
+		}
+		cfg.hosts[name] = ips
+	}
+	for _, host := range cfg.BlockedHosts {
+		r, err := parseBlockedHost(host)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("配置文件中 blocked_hosts 无效: %v", err))
+			continue
+		}
+		cfg.blockedHosts = append(cfg.blockedHosts, r)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+// 输出配置信息
+func (p *Proxy) printConfig(cfg *Config) {
+	p.printListeners(cfg)
+	for _, host := range cfg.BlockedHosts {
+		p.serviceLogger(fmt.Sprintf("屏蔽域名: %v", host), LevelInfo)
+	}
+	if cfg.ReusePort {
+		p.serviceLogger("SO_REUSEPORT: 开启", LevelInfo)
+	}
+	if cfg.CaptureFile != "" {
+		if logLevel(cfg) == LevelDebug {
+			p.serviceLogger(fmt.Sprintf("保存 ClientHello 至: %v（每个连接最多 %d 字节）", cfg.CaptureFile, cfg.CaptureBytes), LevelInfo)
+		} else {
+			p.serviceLogger("capture_file 仅在调试模式（-d 或 min_log_level 为 debug）下生效, 当前不会保存 ClientHello", LevelWarn)
+		}
+	}
+	if cfg.MirrorAddr != "" {
+		p.serviceLogger(fmt.Sprintf("镜像上行流量至: %v", cfg.MirrorAddr), LevelInfo)
+	}
+	if cfg.MaxConnLifetime > 0 {
+		p.serviceLogger(fmt.Sprintf("单连接时长上限: %v 秒", cfg.MaxConnLifetime), LevelInfo)
+	}
+	if cfg.MaxBytesPerConn > 0 {
+		p.serviceLogger(fmt.Sprintf("单连接流量上限: %v 字节", cfg.MaxBytesPerConn), LevelInfo)
+	}
+	if cfg.GlobalRateLimit > 0 {
+		p.serviceLogger(fmt.Sprintf("总带宽限制: %v 字节/秒", cfg.GlobalRateLimit), LevelInfo)
+	}
+	rules := make([]string, 0, len(cfg.RuleRateLimits))
+	for rule := range cfg.RuleRateLimits {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	for _, rule := range rules {
+		p.serviceLogger(fmt.Sprintf("规则带宽限制: %v => %v 字节/秒", rule, cfg.RuleRateLimits[rule]), LevelInfo)
+	}
+	if cfg.geoIP != nil {
+		p.serviceLogger(fmt.Sprintf("GeoIP 数据库: %v（%v）", cfg.GeoIPDB, cfg.geoIP.dbType), LevelInfo)
+	}
+	if len(cfg.AllowedCountries) > 0 {
+		p.serviceLogger(fmt.Sprintf("允许的国家或地区: %v", strings.Join(cfg.AllowedCountries, ", ")), LevelInfo)
+	}
+	if len(cfg.BlockedCountries) > 0 {
+		p.serviceLogger(fmt.Sprintf("拒绝的国家或地区: %v", strings.Join(cfg.BlockedCountries, ", ")), LevelInfo)
+	}
+	countries := make([]string, 0, len(cfg.CountryRules))
+	for country := range cfg.CountryRules {
+		countries = append(countries, country)
+	}
+	sort.Strings(countries)
+	for _, country := range countries {
+		for _, rule := range cfg.CountryRules[country] {
+			p.serviceLogger(fmt.Sprintf("国家或地区规则: %v => %v", country, rule), LevelInfo)
+		}
+	}
+	if cfg.schedule != nil {
+		for _, w := range cfg.Schedule.Windows {
+			days := "每天"
+			if len(w.Days) > 0 {
+				days = strings.Join(w.Days, ",")
+			}
+			p.serviceLogger(fmt.Sprintf("允许转发的时间段: %v %v-%v（%v）", days, w.Start, w.End, cfg.schedule.location), LevelInfo)
+		}
+	}
+	if cfg.CircuitBreakerFailures > 0 {
+		p.serviceLogger(fmt.Sprintf("熔断: %v 秒内连续失败 %v 次后熔断 %v 秒", cfg.CircuitBreakerWindow, cfg.CircuitBreakerFailures, cfg.CircuitBreakerCooldown), LevelInfo)
+	}
+	if cfg.RejectTLSAlert {
+		p.serviceLogger("拒绝连接时发送 TLS 警报: 开启", LevelInfo)
+	}
+	if cfg.RejectAction == rejectActionTarpit {
+		p.serviceLogger(fmt.Sprintf("拒绝连接方式: tarpit（保持 %v 秒后关闭）", cfg.RejectTarpitTime), LevelInfo)
+	} else if cfg.RejectAction != rejectActionClose {
+		p.serviceLogger(fmt.Sprintf("拒绝连接方式: %v", cfg.RejectAction), LevelInfo)
+	}
+	hosts := make([]string, 0, len(cfg.Hosts))
+	for host := range cfg.Hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		p.serviceLogger(fmt.Sprintf("静态 hosts: %v => %v", host, strings.Join(cfg.Hosts[host], ", ")), LevelInfo)
+	}
+	if cfg.Transparent != "" {
+		p.serviceLogger(fmt.Sprintf("透明代理: %v（转发至原始目标端口）", cfg.Transparent), LevelInfo)
+	}
+	p.serviceLogger(fmt.Sprintf("调试模式: %v", cfg.Debug), LevelInfo)
+	p.serviceLogger(fmt.Sprintf("日志级别: %v", logLevel(cfg)), LevelInfo)
+	if cfg.SyslogAddr != "" {
+		p.serviceLogger(fmt.Sprintf("syslog 服务器: %v（facility %s）", cfg.SyslogAddr, cfg.SyslogFacility), LevelInfo)
+	}
+	if cfg.OTLPEndpoint != "" {
+		p.serviceLogger(fmt.Sprintf("发送追踪数据至: %v（service.name %s）", cfg.OTLPEndpoint, cfg.OTLPServiceName), LevelInfo)
+	}
+	if cfg.WebhookURL != "" {
+		p.serviceLogger(fmt.Sprintf("webhook 通知: %v（事件 %s, 最短间隔 %d 秒）", cfg.WebhookURL, strings.Join(cfg.enabledWebhookEvents(), "、"), cfg.WebhookInterval), LevelInfo)
+	}
+	if cfg.StatsdAddr != "" {
+		p.serviceLogger(fmt.Sprintf("StatsD 服务器: %v（前缀 %s）", cfg.StatsdAddr, cfg.StatsdPrefix), LevelInfo)
+	}
+	p.serviceLogger(fmt.Sprintf("前置代理: %v", cfg.EnableSocks), LevelInfo)
+	if cfg.EnableSocks {
+		p.serviceLogger(fmt.Sprintf("代理地址: %v", cfg.SocksAddr), LevelInfo)
+	}
+	if cfg.defaultTarget != "" {
+		p.serviceLogger(fmt.Sprintf("默认目标: %v", cfg.defaultTarget), LevelInfo)
+	}
+	if cfg.AcceptProxyProtocol {
+		p.serviceLogger("PROXY protocol: 接受（从客户端连接开头的 PROXY protocol 头部获得客户端地址）", LevelInfo)
+	}
+	if cfg.SendProxyProtocol != "" {
+		p.serviceLogger(fmt.Sprintf("PROXY protocol: %v（向目标发送）", cfg.SendProxyProtocol), LevelInfo)
+	}
+	if cfg.OutboundAddr != "" {
+		p.serviceLogger(fmt.Sprintf("出站地址: %v", cfg.OutboundAddr), LevelInfo)
+	}
+	if cfg.OutboundInterface != "" {
+		p.serviceLogger(fmt.Sprintf("出站网卡: %v", cfg.OutboundInterface), LevelInfo)
+	}
+	if cfg.SoMark != 0 {
+		p.serviceLogger(fmt.Sprintf("出站 fwmark: %d (0x%x)", cfg.SoMark, cfg.SoMark), LevelInfo)
+	}
+	if cfg.DSCP != 0 {
+		p.serviceLogger(fmt.Sprintf("DSCP: %d（客户端连接: %v）", cfg.DSCP, cfg.DSCPClient), LevelInfo)
+	}
+	if len(cfg.AllowedClients) > 0 {
+		p.serviceLogger(fmt.Sprintf("允许的客户端: %v", strings.Join(cfg.AllowedClients, ", ")), LevelInfo)
+	}
+	if cfg.MaxConnections > 0 {
+		p.serviceLogger(fmt.Sprintf("总连接数上限: %v（已满时等待 %v 秒）", cfg.MaxConnections, cfg.MaxConnectionsWait), LevelInfo)
+	}
+	if cfg.NumWorkers > 0 {
+		p.serviceLogger(fmt.Sprintf("工作线程: %d（队列 %d, 已满时 %s）", cfg.NumWorkers, cfg.WorkerQueueSize, cfg.WorkerQueueFull), LevelInfo)
+	}
+	if cfg.ConnRatePerIP > 0 {
+		p.serviceLogger(fmt.Sprintf("单 IP 新建连接速率: %v 个/秒（突发 %v 个）", cfg.ConnRatePerIP, cfg.ConnBurstPerIP), LevelInfo)
+	}
+	if cfg.MaxConnsPerIP > 0 {
+		p.serviceLogger(fmt.Sprintf("单 IP 连接数上限: %v", cfg.MaxConnsPerIP), LevelInfo)
+	}
+	if cfg.SoRcvbuf > 0 || cfg.SoSndbuf > 0 {
+		p.serviceLogger(fmt.Sprintf("TCP 缓冲区大小: 接收 %v 字节, 发送 %v 字节（0 为系统默认）", cfg.SoRcvbuf, cfg.SoSndbuf), LevelInfo)
+	}
+	if cfg.TCPKeepAlive < 0 {
+		p.serviceLogger("TCP keepalive: 关闭", LevelInfo)
+	} else {
+		p.serviceLogger(fmt.Sprintf("TCP keepalive: %v 秒", cfg.TCPKeepAlive), LevelInfo)
+	}
+	p.serviceLogger(fmt.Sprintf("连接目标超时: %v 秒", cfg.DialTimeout), LevelInfo)
+	if len(cfg.DNSServers) > 0 {
+		p.serviceLogger(fmt.Sprintf("DNS 服务器: %v", strings.Join(cfg.DNSServers, ", ")), LevelInfo)
+	}
+	if cfg.DoHURL != "" {
+		if cfg.DoHFallback {
+			p.serviceLogger(fmt.Sprintf("DoH 服务器: %v（查询失败时改用系统 DNS）", cfg.DoHURL), LevelInfo)
+		} else {
+			p.serviceLogger(fmt.Sprintf("DoH 服务器: %v", cfg.DoHURL), LevelInfo)
+		}
+	}
+	if cfg.IPVersion != 0 {
+		p.serviceLogger(fmt.Sprintf("出站 IP 版本: 仅 IPv%d", cfg.IPVersion), LevelInfo)
+	}
+	if cfg.LoadBalance == loadBalanceRoundRobin {
+		p.serviceLogger("负载均衡: round_robin（轮询规则中的多个目标）", LevelInfo)
+	}
+	if cfg.DialRetries > 0 {
+		p.serviceLogger(fmt.Sprintf("连接目标重试: %v 次（首次等待 %v 毫秒, 之后每次翻倍）", cfg.DialRetries, cfg.DialRetryBackoff), LevelInfo)
+	}
+	if cfg.CopyBufferSize != defaultCopyBufferSize {
+		p.serviceLogger(fmt.Sprintf("转发缓冲区大小: %v 字节", cfg.CopyBufferSize), LevelInfo)
+	}
+	if cfg.ReadBufferSize != initialReadSize {
+		p.serviceLogger(fmt.Sprintf("读取 ClientHello 缓冲区大小: %v 字节", cfg.ReadBufferSize), LevelInfo)
+	}
+	if len(cfg.BlockedJA3) > 0 || len(cfg.AllowedJA3) > 0 {
+		p.serviceLogger(fmt.Sprintf("JA3 指纹过滤: 屏蔽 %d 个, 允许 %d 个", len(cfg.blockedJA3), len(cfg.allowedJA3)), LevelInfo)
+	}
+	p.serviceLogger(fmt.Sprintf("禁止内网目标: %v", cfg.BlockPrivateIPs), LevelInfo)
+	if cfg.BlockPrivateIPs && len(cfg.AllowedPrivateIPs) > 0 {
+		p.serviceLogger(fmt.Sprintf("允许的内网地址: %v", strings.Join(cfg.AllowedPrivateIPs, ", ")), LevelInfo)
+	}
+	if cfg.LegacyRuleMatch {
+		p.serviceLogger("旧版规则匹配: true（SNI 域名中包含规则域名即允许，存在被绕过的风险）", LevelWarn)
+	}
+	if logLevel(cfg) == LevelDebug { // 调试模式下输出合并 include、展开环境变量、填充默认值之后实际生效的配置
+		if data, err := yaml.Marshal(redactConfig(cfg)); err == nil {
+			p.serviceLogger("生效的配置（已隐藏密码等敏感信息）:\n"+strings.TrimRight(string(data), "\n"), LevelDebug)
+		}
+	}
+}
+
+// 返回隐藏了敏感信息（密码、令牌、认证头部、地址中的密码）的配置副本，用于输出
+func redactConfig(cfg *Config) *Config {
+	const redacted = "******"
+	c := *cfg
+	if c.SocksPass != "" {
+		c.SocksPass = redacted
+	}
+	if c.AdminToken != "" {
+		c.AdminToken = redacted
+	}
+	if len(c.OTLPHeaders) > 0 {
+		c.OTLPHeaders = make(map[string]string, len(cfg.OTLPHeaders))
+		for k := range cfg.OTLPHeaders {
+			c.OTLPHeaders[k] = redacted
+		}
+	}
+	redactURL := func(s string) string {
+		u, err := url.Parse(s)
+		if err != nil || u.User == nil {
+			return s
+		}
+		return u.Redacted()
+	}
+	c.DoHURL, c.OTLPEndpoint = redactURL(c.DoHURL), redactURL(c.OTLPEndpoint)
+	if u, err := url.Parse(c.WebhookURL); err == nil && c.WebhookURL != "" { // webhook 地址的路径中通常包含密钥，只保留协议和主机
+		c.WebhookURL = u.Scheme + "://" + u.Host + "/" + redacted
+	}
+	return &c
+}
@@ -0,0 +1,114 @@
+package sniproxy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TOML 格式的配置与相同内容的 YAML 配置解析结果相同
+func TestParseConfigTOML(t *testing.T) {
+	const tomlConfig = `
+# 注释
+forward_port = 8443
+allow_all_hosts = false
+socks_pass = "a\"b\\c\tdé\u00e9"
+socks_user = 'C:\raw'
+socks_addr = """
+127.0.0.1:\
+    40000"""
+rules = [
+  "example.com",
+  '~^cdn\d+\.example\.com$', # 行尾注释
+  """
+example.org""",
+  '''=exact.example.com=127.0.0.1:8443''',
+]
+read_buffer_size = 0x800
+max_connections = 1_000
+hosts = { "a.example.com" = "10.0.0.1", "b.example.com" = ["10.0.0.2", "10.0.0.3"] }
+schedule.timezone = "UTC"
+"rule_rate_limits"."example.com" = 1024
+
+[[schedule.windows]]
+days = ["mon", "tue"]
+start = "09:00"
+end = "18:00"
+
+[[listeners]]
+listen_addr = ":443"
+rules = ["a.example.com"]
+
+[[listeners]]
+listen_addr = [":8443", ":9443"]
+allow_all_hosts = true
+`
+	const yamlConfig = `
+forward_port: 8443
+socks_pass: "a\"b\\c\tdéé"
+socks_user: 'C:\raw'
+socks_addr: "127.0.0.1:40000"
+rules:
+  - example.com
+  - '~^cdn\d+\.example\.com$'
+  - example.org
+  - "=exact.example.com=127.0.0.1:8443"
+read_buffer_size: 2048
+max_connections: 1000
+hosts:
+  a.example.com: 10.0.0.1
+  b.example.com: [10.0.0.2, 10.0.0.3]
+schedule:
+  timezone: UTC
+  windows:
+    - days: [mon, tue]
+      start: "09:00"
+      end: "18:00"
+rule_rate_limits:
+  example.com: 1024
+listeners:
+  - listen_addr: ":443"
+    rules: [a.example.com]
+  - listen_addr: [":8443", ":9443"]
+    allow_all_hosts: true
+`
+	fromTOML, err := parseConfigData([]byte(tomlConfig), "toml")
+	if err != nil {
+		t.Fatalf("解析 TOML 出错: %v", err)
+	}
+	fromYAML, err := parseConfigData([]byte(yamlConfig), "yaml")
+	if err != nil {
+		t.Fatalf("解析 YAML 出错: %v", err)
+	}
+	if fromTOML.SocksAddr != "127.0.0.1:40000" || len(fromTOML.ForwardRules) != 4 || fromTOML.ForwardRules[2] != "example.org" { // 多行字符串（去掉开头的换行、行尾的 \ 连接下一行）
+		t.Errorf("TOML 多行字符串解析错误: socks_addr %q, rules %q", fromTOML.SocksAddr, fromTOML.ForwardRules)
+	}
+	fromTOML.format, fromYAML.format = "", ""
+	if !reflect.DeepEqual(fromTOML, fromYAML) {
+		t.Errorf("TOML 与 YAML 的解析结果不同:\nTOML: %+v\nYAML: %+v", fromTOML, fromYAML)
+	}
+}
+
+// TOML 语法错误时返回的错误中包含出错的行号
+func TestParseConfigTOMLError(t *testing.T) {
+	tests := []struct {
+		config string
+		line   string
+	}{
+		{"forward_port = 443\nrules = [\"a\",\nlisten_addr = \":443\"\n", "line 3"},
+		{"forward_port = 443\n\nsocks_pass = \"unterminated\n", "line 3"},
+		{"forward_port = 443\nforward_port = 8443\n", "line 2"},
+		{"forward_port = 443\nmode = tcp\n", "line 2"},
+		{"hosts = { a = 1, }\n", "line 1"},
+	}
+	for _, tt := range tests {
+		_, err := ParseConfig([]byte(tt.config), "config.toml")
+		if err == nil {
+			t.Errorf("解析 %q 没有出错", tt.config)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.line) {
+			t.Errorf("解析 %q 的错误 %q 中没有行号 %q", tt.config, err, tt.line)
+		}
+	}
+}
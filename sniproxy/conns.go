@@ -0,0 +1,420 @@
+package sniproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 活动连接登记表（用于退出时等待已有连接结束、管理 API 列出活动连接）
+type connRegistry struct {
+	mu    sync.Mutex
+	conns map[string]*connInfo // 连接 ID => 连接信息
+	wg    sync.WaitGroup
+}
+
+// 活动连接的信息
+type connInfo struct {
+	id     string
+	start  time.Time
+	client string // 客户端地址（启用 accept_proxy_protocol 时更新为真实地址）
+	sni    string // 获得 SNI 域名后更新
+	target string // 连接目标成功后更新
+
+	bytesUp   atomic.Int64                     // 已转发的上行字节数（转发时实时更新）
+	bytesDown atomic.Int64                     // 已转发的下行字节数
+	probe     func() (up, down int64, ok bool) // splice 转发时从内核获取已转发的字节数（数据不经过用户态，无法实时计数）
+}
+
+var connIDCounter atomic.Uint64 // 连接 ID 计数器
+
+// 生成新的连接 ID（本次运行中唯一的递增序号，36 进制以缩短长度）
+func newConnID() string {
+	return strconv.FormatUint(connIDCounter.Add(1), 36)
+}
+
+// 登记新连接（在启动处理该连接的线程前调用）
+func (r *connRegistry) add(fields LogFields) {
+	r.mu.Lock()
+	r.conns[fields.ID] = &connInfo{id: fields.ID, start: time.Now(), client: fields.Client}
+	r.mu.Unlock()
+	r.wg.Add(1)
+}
+
+// 注销连接（连接处理结束后调用）
+func (r *connRegistry) remove(id string) {
+	r.mu.Lock()
+	delete(r.conns, id)
+	r.mu.Unlock()
+	r.wg.Done()
+}
+
+// 更新连接的客户端地址、SNI 域名、转发目标，返回该连接的信息（连接不存在时返回 nil）
+func (r *connRegistry) update(fields LogFields) *connInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info := r.conns[fields.ID]
+	if info != nil {
+		info.client, info.sni, info.target = fields.Client, fields.SNI, fields.Target
+	}
+	return info
+}
+
+// 设置从内核获取已转发字节数的函数（splice 转发时）
+func (r *connRegistry) setProbe(info *connInfo, probe func() (up, down int64, ok bool)) {
+	r.mu.Lock()
+	info.probe = probe
+	r.mu.Unlock()
+}
+
+// 活动连接的快照
+type connSnapshot struct {
+	ID        string    `json:"id"`
+	Client    string    `json:"client"`
+	SNI       string    `json:"sni,omitempty"`
+	Target    string    `json:"target,omitempty"`
+	Start     time.Time `json:"start"`
+	BytesUp   int64     `json:"bytes_up"`
+	BytesDown int64     `json:"bytes_down"`
+}
+
+// 所有活动连接的快照（按开始时间排序）
+func (r *connRegistry) snapshot() []connSnapshot {
+	r.mu.Lock()
+	list := make([]connSnapshot, 0, len(r.conns))
+	probes := make([]func() (int64, int64, bool), 0, len(r.conns))
+	for _, info := range r.conns {
+		list = append(list, connSnapshot{ID: info.id, Client: info.client, SNI: info.sni, Target: info.target, Start: info.start,
+			BytesUp: info.bytesUp.Load(), BytesDown: info.bytesDown.Load()})
+		probes = append(probes, info.probe)
+	}
+	r.mu.Unlock()
+	for i, probe := range probes { // 在锁外获取内核中的统计
+		if probe == nil {
+			continue
+		}
+		if up, down, ok := probe(); ok {
+			list[i].BytesUp, list[i].BytesDown = max64(list[i].BytesUp, up), max64(list[i].BytesDown, down)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Start.Before(list[j].Start) })
+	return list
+}
+
+// 两个数中较大的一个
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// 当前活动连接数
+func (r *connRegistry) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
+
+// 等待所有连接结束，超时后调用 forceClose 强制关闭剩余连接，返回 自然结束 和 强制关闭 的连接数
+func (r *connRegistry) drain(timeout time.Duration, forceClose func()) (drained, forced int) {
+	total := r.count()
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return total, 0
+	case <-time.After(timeout):
+	}
+
+	forced = r.count()
+	forceClose()
+	select { // 给转发线程一点时间退出
+	case <-done:
+	case <-time.After(time.Second):
+	}
+	return total - forced, forced
+}
+
+// 全局连接数限制（信号量，max_connections）
+type connLimiter struct {
+	slots chan struct{}
+}
+
+// 创建全局连接数限制（max 为 0 时返回 nil，即不限制）
+func newConnLimiter(max int) *connLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &connLimiter{slots: make(chan struct{}, max)}
+}
+
+// 占用一个连接名额，已满时最多等待 wait（为 0 时不等待），等待超时返回 false
+func (l *connLimiter) acquire(wait time.Duration) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if wait <= 0 {
+		return false
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// 释放一个连接名额
+func (l *connLimiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// 已占用的连接名额
+func (l *connLimiter) count() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.slots)
+}
+
+// 连接数上限
+func (l *connLimiter) limit() int {
+	if l == nil {
+		return 0
+	}
+	return cap(l.slots)
+}
+
+// 每个客户端 IP 的连接数
+type ipConnCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// 客户端新建连接（limit 大于 0 且该 IP 的连接数已达到 limit 时返回 false，不计数）
+func (c *ipConnCounter) acquire(ip string, limit int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if limit > 0 && c.counts[ip] >= limit {
+		return false
+	}
+	c.counts[ip]++
+	return true
+}
+
+// 客户端连接结束（连接数归零时删除该 IP，避免 map 无限增长）
+func (c *ipConnCounter) release(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts[ip] <= 1 {
+		delete(c.counts, ip)
+		return
+	}
+	c.counts[ip]--
+}
+
+// context 取消时关闭连接（用于中断阻塞中的读写），返回的函数用于停止监视
+func closeOnDone(ctx context.Context, c io.Closer) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// 连接空闲检测（两个方向任意一方读到数据即视为活动，超过 timeout 没有任何数据传输时调用 onIdle）
+type idleTracker struct {
+	timeout    time.Duration
+	lastActive atomic.Int64 // 最后一次读到数据的时间（UnixNano）
+	timer      *time.Timer
+	probe      func() (time.Duration, bool) // 从内核获取距离上次收到数据的时间（splice 转发时数据不经过用户态，无法通过 wrap 记录活动）
+	onIdle     func()
+}
+
+// 创建空闲检测（timeout 为 0 时返回 nil，即不检测），probe 可以为 nil
+func newIdleTracker(timeout time.Duration, probe func() (time.Duration, bool), onIdle func()) *idleTracker {
+	if timeout <= 0 {
+		return nil
+	}
+	t := &idleTracker{timeout: timeout, probe: probe, onIdle: onIdle}
+	t.touch()
+	t.timer = time.AfterFunc(timeout, t.check)
+	return t
+}
+
+// 记录一次活动
+func (t *idleTracker) touch() {
+	t.lastActive.Store(time.Now().UnixNano())
+}
+
+// 检查是否空闲超时，未超时则在剩余时间后再次检查
+func (t *idleTracker) check() {
+	idle := time.Since(time.Unix(0, t.lastActive.Load()))
+	if t.probe != nil {
+		if d, ok := t.probe(); ok && d < idle {
+			idle = d
+		}
+	}
+	if idle >= t.timeout {
+		t.onIdle()
+		return
+	}
+	t.timer.Reset(t.timeout - idle)
+}
+
+// 停止检测
+func (t *idleTracker) stop() {
+	if t != nil {
+		t.timer.Stop()
+	}
+}
+
+// 包装连接，读到数据时记录活动
+func (t *idleTracker) wrap(c net.Conn) net.Conn {
+	if t == nil {
+		return c
+	}
+	return &activityConn{Conn: c, tracker: t}
+}
+
+// 读到数据时记录活动的连接
+type activityConn struct {
+	net.Conn
+	tracker *idleTracker
+}
+
+// 读取数据
+func (c *activityConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.tracker.touch()
+	}
+	return n, err
+}
+
+// 单向复制数据（Linux 下两端都是 TCP 连接时使用 splice 零拷贝，数据不经过用户态；其他情况通过 bufSize 大小的缓冲区复制并记录活动）
+// 已复制的字节数实时累加到 counter（splice 时在结束后累加）
+func copyData(dst, src net.Conn, idle *idleTracker, bufSize int, counter *atomic.Int64) (int64, error) {
+	if d, s, ok := spliceConns(dst, src); ok {
+		n, err := d.ReadFrom(s)
+		counter.Add(n)
+		return n, err
+	}
+	pool := copyBufPool(bufSize)
+	buf := pool.get()
+	defer pool.put(buf)
+	return io.CopyBuffer(&countingWriter{w: dst, n: counter}, readerOnly{idle.wrap(src)}, *buf)
+}
+
+// 累加写入字节数的 Writer（只有 Write 方法，让 io.CopyBuffer 使用传入的缓冲区）
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+// 写入数据
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.n.Add(int64(n))
+	return n, err
+}
+
+// 是否为连接正常结束的错误（对端关闭 EOF、本端已关闭连接），这类错误不需要记录
+func isClosedConnError(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed)
+}
+
+// 是否为超时错误
+func isTimeoutError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// 获取底层的 TCP 连接（PROXY protocol 包装的连接只是替换了地址）
+func unwrapTCPConn(c net.Conn) (*net.TCPConn, bool) {
+	if pc, ok := c.(*proxiedConn); ok {
+		c = pc.Conn
+	}
+	tc, ok := c.(*net.TCPConn)
+	return tc, ok
+}
+
+// 设置 TCP 连接选项：关闭 Nagle 算法（TCP_NODELAY）降低延迟，并按 tcp_keepalive 启用 keepalive 以发现已断开的对端
+func setTCPOptions(c net.Conn, cfg *Config) {
+	tc, ok := unwrapTCPConn(c)
+	if !ok { // 例如通过 Socks5 代理的连接（由 Dialer 设置 keepalive）
+		return
+	}
+	tc.SetNoDelay(true)
+	if cfg.SoRcvbuf > 0 { // 指定缓冲区大小后内核不再自动调整该连接的缓冲区
+		tc.SetReadBuffer(cfg.SoRcvbuf)
+	}
+	if cfg.SoSndbuf > 0 {
+		tc.SetWriteBuffer(cfg.SoSndbuf)
+	}
+	if cfg.TCPKeepAlive < 0 {
+		tc.SetKeepAlive(false)
+		return
+	}
+	tc.SetKeepAlive(true)
+	tc.SetKeepAlivePeriod(time.Duration(cfg.TCPKeepAlive) * time.Second)
+}
+
+// 配置了 so_rcvbuf、so_sndbuf 时在调试日志中输出连接实际的缓冲区大小（Linux 下内核会将设置的值翻倍，并受 rmem_max、wmem_max 限制）
+func (p *Proxy) logSocketBuffers(c net.Conn, cfg *Config, side string, fields LogFields) {
+	if cfg.SoRcvbuf <= 0 && cfg.SoSndbuf <= 0 {
+		return
+	}
+	tc, ok := unwrapTCPConn(c)
+	if !ok {
+		return
+	}
+	if rcv, snd, ok := socketBufferSizes(tc); ok {
+		p.serviceLoggerFields(fmt.Sprintf("%s连接的缓冲区大小: 接收 %d 字节, 发送 %d 字节", side, rcv, snd), LevelDebug, fields)
+	}
+}
+
+// 一个方向的复制结束后的处理：正常结束（读到 EOF）时关闭 dst 的写入，让对端知道数据已发送完毕；
+// 出错时（例如连接被重置）完全关闭两个连接，同时结束另一个方向
+func finishCopy(dst, src net.Conn, err error) {
+	if err == nil && closeWrite(dst) == nil {
+		return
+	}
+	dst.Close()
+	src.Close()
+}
+
+// 关闭连接的写入（发送 FIN），不支持半关闭的连接（例如通过 Socks5 代理的连接）返回错误
+func closeWrite(c net.Conn) error {
+	if tc, ok := unwrapTCPConn(c); ok {
+		return tc.CloseWrite()
+	}
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.New("连接不支持半关闭")
+}
@@ -0,0 +1,125 @@
+package sniproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+const (
+	dohContentType  = "application/dns-message" // RFC 8484 规定的 DNS 报文类型
+	dohTimeout      = 5 * time.Second           // 单次 DoH 查询的超时时间
+	maxDoHMsgLength = 65535                     // DNS 报文的最大长度
+)
+
+// 检查 doh_url（必须是 https 地址）
+func parseDoHURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, errors.New("需要是 https:// 开头的地址")
+	}
+	return u, nil
+}
+
+// 创建通过 DoH（DNS over HTTPS，RFC 8484）查询的解析器
+// 借用 Go 解析器构造、解析 DNS 报文（A、AAAA 查询等），Dial 返回的连接把每个查询通过 HTTPS POST 发送给 DoH 服务器
+func newDoHResolver(u *url.URL, control func(network, address string, c syscall.RawConn) error) *net.Resolver {
+	dialer := &net.Dialer{Timeout: dnsDialTimeout, Control: control} // 指定了 outbound_interface 时 DoH 查询也通过该网卡
+	client := &http.Client{
+		Timeout: dohTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext, // DoH 服务器为域名时使用系统 DNS 解析
+			ForceAttemptHTTP2:   true,
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+	return &net.Resolver{
+		PreferGo: true, // 只有 Go 解析器支持自定义 Dial
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return &dohConn{ctx: ctx, client: client, url: u.String()}, nil
+		},
+	}
+}
+
+// DoH 查询连接：Go 解析器把它当作 TCP 连接，写入、读取的都是带 2 字节长度前缀的 DNS 报文
+type dohConn struct {
+	ctx      context.Context
+	client   *http.Client
+	url      string
+	deadline time.Time
+	resp     bytes.Reader // DoH 服务器的响应（已加上长度前缀）
+}
+
+// 发送一个 DNS 查询（去掉长度前缀后 POST 给 DoH 服务器），响应保存起来供 Read 读取
+func (c *dohConn) Write(b []byte) (int, error) {
+	if len(b) < 2 || int(b[0])<<8|int(b[1]) != len(b)-2 {
+		return 0, errors.New("无效的 DNS 查询报文")
+	}
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(b[2:]))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("DoH 查询失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("DoH 查询失败: HTTP %s", resp.Status)
+	}
+	msg, err := io.ReadAll(io.LimitReader(resp.Body, maxDoHMsgLength+1))
+	if err != nil {
+		return 0, fmt.Errorf("DoH 查询失败: %w", err)
+	}
+	if len(msg) > maxDoHMsgLength {
+		return 0, errors.New("DoH 查询失败: 响应过长")
+	}
+	c.resp.Reset(append([]byte{byte(len(msg) >> 8), byte(len(msg))}, msg...))
+	return len(b), nil
+}
+
+func (c *dohConn) Read(b []byte) (int, error) { return c.resp.Read(b) }
+
+func (c *dohConn) Close() error { return nil }
+
+func (c *dohConn) LocalAddr() net.Addr { return dohAddr{} }
+
+func (c *dohConn) RemoteAddr() net.Addr { return dohAddr{} }
+
+func (c *dohConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func (c *dohConn) SetReadDeadline(time.Time) error { return nil }
+
+func (c *dohConn) SetWriteDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+// dohConn 的地址（没有实际的网络地址）
+type dohAddr struct{}
+
+func (dohAddr) Network() string { return "doh" }
+
+func (dohAddr) String() string { return "doh" }
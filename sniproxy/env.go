@@ -0,0 +1,96 @@
+package sniproxy
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// 展开配置中所有字符串选项（包括列表、映射的值以及 listeners、schedule 中的选项）里引用的环境变量，返回是否引用了环境变量
+// ${VAR} 为环境变量 VAR 的值（未设置时报错），${VAR:-默认值} 在 VAR 未设置或为空时使用默认值，$${ 表示 ${ 本身
+// 例如 socks_pass: "${SOCKS_PASS}"，方便在容器中通过环境变量传入密码等，而不是写在配置文件中
+func expandEnv(cfg *Config) (bool, error) {
+	var missing []string
+	used := false
+	expand := func(s string) string {
+		out, refs, unset := expandEnvString(s)
+		used = used || refs
+		missing = append(missing, unset...)
+		return out
+	}
+	expandValue(reflect.ValueOf(cfg).Elem(), expand)
+	if len(missing) > 0 {
+		return used, fmt.Errorf("配置文件中引用的环境变量 %s 未设置（可以用 ${变量名:-默认值} 指定默认值）", strings.Join(missing, "、"))
+	}
+	return used, nil
+}
+
+// 递归展开字符串（结构体只处理有 yaml 标签的字段，映射只展开值）
+func expandValue(v reflect.Value, expand func(string) string) {
+	switch v.Kind() {
+	case reflect.String:
+		if s := v.String(); strings.Contains(s, "${") {
+			v.SetString(expand(s))
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			expandValue(v.Elem(), expand)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if tag := t.Field(i).Tag.Get("yaml"); tag != "" && tag != "-" {
+				expandValue(v.Field(i), expand)
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			expandValue(v.Index(i), expand)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			value := reflect.New(iter.Value().Type()).Elem() // 映射的值不能直接修改，展开副本后写回
+			value.Set(iter.Value())
+			expandValue(value, expand)
+			v.SetMapIndex(iter.Key(), value)
+		}
+	}
+}
+
+// 展开一个字符串，返回展开后的字符串、是否引用了环境变量、未设置的环境变量
+func expandEnvString(s string) (string, bool, []string) {
+	var b strings.Builder
+	var missing []string
+	used := false
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			break
+		}
+		if i > 0 && s[i-1] == '$' { // $${ 表示 ${ 本身
+			b.WriteString(s[:i-1] + "${")
+			s = s[i+2:]
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 { // 没有闭合的 }，原样保留
+			break
+		}
+		b.WriteString(s[:i])
+		name, def, hasDefault := strings.Cut(s[i+2:i+end], ":-")
+		value, ok := os.LookupEnv(name)
+		switch {
+		case hasDefault && value == "":
+			value = def
+		case !ok:
+			missing = append(missing, name)
+		}
+		b.WriteString(value)
+		used = true
+		s = s[i+end+1:]
+	}
+	b.WriteString(s)
+	return b.String(), used, missing
+}
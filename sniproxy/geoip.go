@@ -0,0 +1,167 @@
+package sniproxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoIP 数据库（MaxMind DB 格式，例如 GeoLite2-Country、GeoLite2-City；加载配置时整个读入内存，重载配置时重新读取）
+type geoIPDB struct {
+	reader *maxminddb.Reader
+	dbType string // 数据库类型（例如 GeoLite2-Country）
+}
+
+// 查询时只解码需要的字段
+type geoIPRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// 最近一次读取的 GeoIP 数据库：重载配置（包括管理 API 修改规则）时路径、文件大小和修改时间都没有变化则直接使用，
+// 避免每次都重新读取整个文件（例如 GeoLite2-City 约 70MB）
+var geoIPCache struct {
+	mu      sync.Mutex
+	path    string
+	size    int64
+	modTime time.Time
+	db      *geoIPDB
+}
+
+// 读取 GeoIP 数据库（文件没有变化时使用上次读取的结果）
+func loadGeoIPDB(path string) (*geoIPDB, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &geoIPCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.db != nil && c.path == path && c.size == info.Size() && c.modTime.Equal(info.ModTime()) {
+		return c.db, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	db := &geoIPDB{reader: reader, dbType: reader.Metadata.DatabaseType}
+	c.path, c.size, c.modTime, c.db = path, info.Size(), info.ModTime(), db
+	return db, nil
+}
+
+// 查询 IP 所在的国家或地区（ISO 3166-1 二位代码，例如 CN、US），没有记录或数据无效时返回空
+func (db *geoIPDB) country(ip net.IP) string {
+	if db == nil {
+		return ""
+	}
+	var record geoIPRecord
+	if err := db.reader.Lookup(ip, &record); err != nil { // IPv4 数据库中查询 IPv6 地址时也会出错
+		return ""
+	}
+	if record.Country.ISOCode != "" {
+		return record.Country.ISOCode
+	}
+	return record.RegisteredCountry.ISOCode // 没有 country 时（例如卫星网络、匿名代理）使用注册地
+}
+
+// 解析国家或地区代码列表（allowed_countries、blocked_countries），代码不区分大小写
+func parseCountries(list []string) (map[string]bool, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	countries := make(map[string]bool, len(list))
+	for _, c := range list {
+		code, err := parseCountry(c)
+		if err != nil {
+			return nil, err
+		}
+		countries[code] = true
+	}
+	return countries, nil
+}
+
+// 解析国家或地区代码（转为大写）
+func parseCountry(c string) (string, error) {
+	code := strings.ToUpper(strings.TrimSpace(c))
+	if len(code) != 2 || strings.Trim(code, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return "", fmt.Errorf("%q 不是 ISO 3166-1 二位国家或地区代码（例如 CN、US）", c)
+	}
+	return code, nil
+}
+
+// 解析 geoip_db、allowed_countries、blocked_countries、country_rules（需要在 parseRateLimits 之前调用）
+func parseGeoIP(cfg *Config) []error {
+	var errs []error
+	var err error
+	if cfg.allowedCountries, err = parseCountries(cfg.AllowedCountries); err != nil {
+		errs = append(errs, fmt.Errorf("配置文件中 allowed_countries 无效: %v!", err))
+	}
+	if cfg.blockedCountries, err = parseCountries(cfg.BlockedCountries); err != nil {
+		errs = append(errs, fmt.Errorf("配置文件中 blocked_countries 无效: %v!", err))
+	}
+	cfg.countryRules = make(map[string][]*forwardRule, len(cfg.CountryRules))
+	countries := make([]string, 0, len(cfg.CountryRules))
+	for country := range cfg.CountryRules {
+		countries = append(countries, country)
+	}
+	sort.Strings(countries)
+	for _, country := range countries {
+		code, err := parseCountry(country)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("配置文件中 country_rules 无效: %v!", err))
+			continue
+		}
+		for _, rule := range cfg.CountryRules[country] {
+			r, err := parseRule(rule, cfg.ForwardPort, cfg.LegacyRuleMatch)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("配置文件中 country_rules 无效: %v", err))
+				continue
+			}
+			cfg.countryRules[code] = append(cfg.countryRules[code], r)
+		}
+	}
+	if cfg.GeoIPDB == "" {
+		if len(cfg.AllowedCountries) > 0 || len(cfg.BlockedCountries) > 0 || len(cfg.CountryRules) > 0 {
+			errs = append(errs, errors.New("配置文件中 allowed_countries、blocked_countries、country_rules 需要与 geoip_db 一起配置!"))
+		}
+		return errs
+	}
+	if cfg.geoIP, err = loadGeoIPDB(cfg.GeoIPDB); err != nil {
+		errs = append(errs, fmt.Errorf("配置文件中 geoip_db 无效: %s（%v）!", cfg.GeoIPDB, err))
+	}
+	return errs
+}
+
+// 检查客户端所在的国家或地区（allowed_countries、blocked_countries），返回国家或地区代码和拒绝的原因（允许时为空）
+// 数据库中没有记录的 IP（例如内网 IP）不受 blocked_countries 限制，但配置了 allowed_countries 时会被拒绝
+func checkCountry(ip net.IP, cfg *Config) (string, string) {
+	if cfg.geoIP == nil {
+		return "", ""
+	}
+	country := cfg.geoIP.country(ip)
+	switch {
+	case cfg.blockedCountries[country]:
+		return country, fmt.Sprintf("所在国家或地区 %s 在 blocked_countries 中", country)
+	case len(cfg.allowedCountries) > 0 && !cfg.allowedCountries[country]:
+		if country == "" {
+			return country, "所在国家或地区未知, 不在 allowed_countries 中"
+		}
+		return country, fmt.Sprintf("所在国家或地区 %s 不在 allowed_countries 中", country)
+	}
+	return country, ""
+}
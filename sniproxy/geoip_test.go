@@ -0,0 +1,241 @@
+package sniproxy
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// MaxMind DB 数据段中的字符串
+func mmdbString(s string) []byte {
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+// MaxMind DB 数据段中的 map（参数依次为键、值）
+func mmdbMap(pairs ...[]byte) []byte {
+	b := []byte{7<<5 | byte(len(pairs)/2)}
+	for _, p := range pairs {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// MaxMind DB 数据段中的指针（偏移量小于 2048）
+func mmdbPointer(offset int) []byte {
+	return []byte{1<<5 | byte(offset>>8&7), byte(offset)}
+}
+
+// MaxMind DB 数据段中的无符号整数（typ 为 5 uint16、6 uint32、9 uint64）
+func mmdbUint(typ byte, v uint64) []byte {
+	var n []byte
+	for ; v > 0; v >>= 8 {
+		n = append([]byte{byte(v)}, n...)
+	}
+	if typ > 7 { // 扩展类型
+		return append([]byte{byte(len(n)), typ - 7}, n...)
+	}
+	return append([]byte{typ<<5 | byte(len(n))}, n...)
+}
+
+// 写入一个只包含 IPv4 网段的测试数据库（record_size 24），networks 为网段到数据在数据段中的位置
+func writeTestMMDB(t *testing.T, data []byte, networks map[string]int) string {
+	t.Helper()
+	nodes := [][2]int{{}} // 0 为没有记录（根节点不会是其它节点的子节点），负数为 -(数据的位置 + 1)
+	for cidr, offset := range networks {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, _ := n.Mask.Size()
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := n.IP.To4()[i/8] >> (7 - i%8) & 1
+			if i == ones-1 {
+				nodes[node][bit] = -(offset + 1)
+				break
+			}
+			if nodes[node][bit] <= 0 {
+				nodes = append(nodes, [2]int{})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+	var buf bytes.Buffer
+	for _, node := range nodes {
+		for _, r := range node {
+			v := r
+			switch {
+			case r == 0:
+				v = len(nodes)
+			case r < 0:
+				v = len(nodes) + 16 - r - 1
+			}
+			buf.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+		}
+	}
+	buf.Write(make([]byte, 16))
+	buf.Write(data)
+	buf.WriteString("\xAB\xCD\xEFMaxMind.com")
+	buf.Write(mmdbMap(
+		mmdbString("node_count"), mmdbUint(6, uint64(len(nodes))),
+		mmdbString("record_size"), mmdbUint(5, 24),
+		mmdbString("ip_version"), mmdbUint(5, 4),
+		mmdbString("database_type"), mmdbString("Test-Country"),
+		mmdbString("binary_format_major_version"), mmdbUint(5, 2),
+		mmdbString("binary_format_minor_version"), mmdbUint(5, 0),
+		mmdbString("build_epoch"), mmdbUint(9, 1700000000),
+	))
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 测试数据库：各网段的记录分别为有 country、country 和 registered_country 通过指针共用数据、只有 registered_country，
+// 以及指向自身的指针、越界的指针（数据无效时查询结果为空，不能无限递归）
+func testGeoIPDB(t *testing.T) string {
+	var data []byte
+	add := func(b []byte) int {
+		data = append(data, b...)
+		return len(data) - len(b)
+	}
+	cn := add(mmdbMap(mmdbString("country"), mmdbMap(mmdbString("iso_code"), mmdbString("CN"))))
+	us := add(mmdbMap(mmdbString("iso_code"), mmdbString("US")))
+	usRecord := add(mmdbMap(mmdbString("country"), mmdbPointer(us), mmdbString("registered_country"), mmdbPointer(us)))
+	jp := add(mmdbMap(mmdbString("registered_country"), mmdbMap(mmdbString("iso_code"), mmdbString("JP"))))
+	loop := add(mmdbPointer(len(data)))
+	outOfRange := add(mmdbPointer(2000))
+	return writeTestMMDB(t, data, map[string]int{
+		"1.2.3.0/24":  cn,
+		"8.8.8.0/24":  usRecord,
+		"10.0.0.0/8":  jp,
+		"66.0.0.0/8":  loop,
+		"77.0.0.0/16": outOfRange,
+	})
+}
+
+func TestGeoIPCountry(t *testing.T) {
+	db, err := loadGeoIPDB(testGeoIPDB(t))
+	if err != nil {
+		t.Fatalf("读取测试数据库出错: %v", err)
+	}
+	if db.dbType != "Test-Country" {
+		t.Errorf("数据库类型为 %q, 期望 Test-Country", db.dbType)
+	}
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"1.2.3.4", "CN"},
+		{"1.2.3.255", "CN"},
+		{"1.2.4.1", ""},
+		{"8.8.8.8", "US"},
+		{"::ffff:8.8.8.8", "US"},
+		{"10.20.30.40", "JP"},
+		{"11.0.0.1", ""},
+		{"127.0.0.1", ""},
+		{"66.1.2.3", ""},
+		{"77.0.0.1", ""},
+		{"2001:db8::1", ""}, // IPv4 数据库中没有 IPv6 地址
+	}
+	for _, tt := range tests {
+		if got := db.country(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("country(%s) = %q, 期望 %q", tt.ip, got, tt.want)
+		}
+	}
+	var none *geoIPDB
+	if got := none.country(net.ParseIP("1.2.3.4")); got != "" {
+		t.Errorf("未加载数据库时 country = %q, 期望为空", got)
+	}
+}
+
+func TestLoadGeoIPDBInvalid(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"empty.mmdb":    "",
+		"text.mmdb":     "not a maxmind database",
+		"metadata.mmdb": "\xAB\xCD\xEFMaxMind.com\xff",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadGeoIPDB(path); err == nil {
+			t.Errorf("读取 %s 没有出错", name)
+		}
+	}
+	if _, err := loadGeoIPDB(filepath.Join(dir, "missing.mmdb")); err == nil {
+		t.Error("读取不存在的文件没有出错")
+	}
+}
+
+func TestCheckCountry(t *testing.T) {
+	path := testGeoIPDB(t)
+	tests := []struct {
+		countries string
+		ip        string
+		country   string
+		rejected  bool
+	}{
+		{"blocked_countries: [cn]", "1.2.3.4", "CN", true},
+		{"blocked_countries: [cn]", "8.8.8.8", "US", false},
+		{"blocked_countries: [cn]", "127.0.0.1", "", false},
+		{"allowed_countries: [US, jp]", "8.8.8.8", "US", false},
+		{"allowed_countries: [US, jp]", "10.0.0.1", "JP", false},
+		{"allowed_countries: [US, jp]", "1.2.3.4", "CN", true},
+		{"allowed_countries: [US, jp]", "127.0.0.1", "", true},
+	}
+	for _, tt := range tests {
+		cfg, err := ParseConfig([]byte(fmt.Sprintf("rules: [example.com]\ngeoip_db: %s\n%s\n", path, tt.countries)), "test.yaml")
+		if err == nil {
+			cfg, err = prepareConfig(cfg)
+		}
+		if err != nil {
+			t.Fatalf("解析配置出错: %v", err)
+		}
+		country, reason := checkCountry(net.ParseIP(tt.ip), cfg)
+		if country != tt.country || (reason != "") != tt.rejected {
+			t.Errorf("%s: checkCountry(%s) = %q, %q; 期望 %q, 拒绝 %v", tt.countries, tt.ip, country, reason, tt.country, tt.rejected)
+		}
+	}
+}
+
+// 文件没有变化时重新加载使用上次读取的数据库，文件修改后重新读取
+func TestLoadGeoIPDBReuse(t *testing.T) {
+	path := testGeoIPDB(t)
+	first, err := loadGeoIPDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := loadGeoIPDB(path); err != nil || again != first {
+		t.Errorf("文件没有变化时重新读取了数据库（%p => %p, %v）", first, again, err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := loadGeoIPDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded == first {
+		t.Error("文件修改后没有重新读取数据库")
+	}
+	if got := reloaded.country(net.ParseIP("1.2.3.4")); got != "CN" {
+		t.Errorf("重新读取后 country(1.2.3.4) = %q, 期望 CN", got)
+	}
+}
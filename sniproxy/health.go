@@ -0,0 +1,51 @@
+package sniproxy
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// 一个监听的接受连接线程（TCP 的 acceptConns、QUIC 的 serve）的运行状态，用于检查是否卡住（Healthy）
+type acceptLoop struct {
+	addr      string
+	busySince atomic.Int64 // 开始处理一个新连接（或数据报）的时间（UnixNano），等待新连接时为 0
+	stopped   atomic.Bool  // 线程已退出
+}
+
+func newAcceptLoop(addr string) *acceptLoop {
+	return &acceptLoop{addr: addr}
+}
+
+// 开始处理一个新连接
+func (l *acceptLoop) busy() {
+	l.busySince.Store(time.Now().UnixNano())
+}
+
+// 等待新连接（包括有意的等待，例如 max_connections_wait、工作线程队列已满时等待）
+func (l *acceptLoop) idle() {
+	l.busySince.Store(0)
+}
+
+// 检查 SNI Proxy 是否正常运行（配合 systemd 看门狗 WatchdogSec 使用），不正常时返回原因
+// 需要获取内部的锁（死锁时不会返回，调用方需要设置超时），已启动且每个监听的接受连接线程都在运行，
+// 并且没有一个线程处理同一个新连接（或 QUIC 数据报）超过 stall
+func (p *Proxy) Healthy(stall time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.started {
+		return errors.New("没有在监听")
+	}
+	for _, l := range p.acceptLoops {
+		if l.stopped.Load() {
+			return fmt.Errorf("监听 %s 已停止接受连接", l.addr)
+		}
+		if since := l.busySince.Load(); since != 0 {
+			if d := time.Since(time.Unix(0, since)); d > stall {
+				return fmt.Errorf("监听 %s 处理一个新连接已超过 %v", l.addr, d.Truncate(time.Millisecond))
+			}
+		}
+	}
+	return nil
+}
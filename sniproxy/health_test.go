@@ -0,0 +1,39 @@
+package sniproxy
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 监听正常时 Healthy 返回空，接受连接的线程卡住或停止时返回原因
+func TestHealthy(t *testing.T) {
+	p := newTestProxy(t, "listen_addr: 127.0.0.1:0\nallow_all_hosts: true\n")
+	if err := p.Healthy(time.Second); err == nil {
+		t.Error("启动前 Healthy 没有返回错误")
+	}
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.Healthy(time.Second); err != nil {
+		t.Fatalf("启动后 Healthy 返回 %v", err)
+	}
+
+	loop := p.acceptLoops[0]
+	loop.busySince.Store(time.Now().Add(-2 * time.Second).UnixNano()) // 处理一个新连接已超过 2 秒
+	if err := p.Healthy(time.Second); err == nil || !strings.Contains(err.Error(), "处理一个新连接已超过") {
+		t.Errorf("接受连接的线程卡住时 Healthy 返回 %v", err)
+	}
+	loop.idle()
+
+	p.closeListeners()
+	deadline := time.Now().Add(5 * time.Second)
+	for !loop.stopped.Load() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := p.Healthy(time.Second); err == nil || !strings.Contains(err.Error(), "已停止接受连接") {
+		t.Errorf("监听关闭后 Healthy 返回 %v", err)
+	}
+}
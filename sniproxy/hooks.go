@@ -0,0 +1,39 @@
+package sniproxy
+
+import (
+	"fmt"
+	"net"
+)
+
+// 调用 OnAccept 回调
+func (p *Proxy) onAccept(client net.Addr) {
+	if p.OnAccept != nil {
+		p.OnAccept(client)
+	}
+}
+
+// 调用 OnSNI 回调，返回错误时拒绝该连接
+func (p *Proxy) onSNI(client net.Addr, sni string) error {
+	if p.OnSNI == nil {
+		return nil
+	}
+	if err := p.OnSNI(client, sni); err != nil {
+		metricRejectedConnections.WithLabelValues("hook").Inc()
+		return fmt.Errorf("OnSNI 拒绝: %v", err)
+	}
+	return nil
+}
+
+// 调用 OnForward 回调
+func (p *Proxy) onForward(sni, target string) {
+	if p.OnForward != nil {
+		p.OnForward(sni, target)
+	}
+}
+
+// 调用 OnClose 回调
+func (p *Proxy) onClose(stats LogFields) {
+	if p.OnClose != nil {
+		p.OnClose(stats)
+	}
+}
@@ -0,0 +1,76 @@
+package sniproxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+)
+
+const (
+	defaultHTTPForwardPort = 80        // http 模式默认转发至的目标端口
+	maxHTTPHeaderSize      = 16 * 1024 // 读取 HTTP 请求头时的缓冲区上限
+)
+
+// 读取 HTTP 请求头（直到空行，可能包括之后的部分请求体），buf 为初始缓冲区，必要时扩大缓冲区（上限 maxHTTPHeaderSize）
+func readHTTPHeader(r io.Reader, buf []byte) ([]byte, error) {
+	n := 0
+	for {
+		m, err := r.Read(buf[n:])
+		n += m
+		if bytes.Contains(buf[:n], []byte("\r\n\r\n")) || bytes.Contains(buf[:n], []byte("\n\n")) {
+			return buf[:n], nil
+		}
+		if err != nil {
+			return buf[:n], err
+		}
+		if n == len(buf) { // 请求头大于缓冲区，扩大缓冲区
+			if n >= maxHTTPHeaderSize {
+				return buf[:n], fmt.Errorf("HTTP 请求头过大 (上限 %d 字节)", maxHTTPHeaderSize)
+			}
+			size := 2 * len(buf)
+			if size > maxHTTPHeaderSize {
+				size = maxHTTPHeaderSize
+			}
+			grown := make([]byte, size)
+			copy(grown, buf[:n])
+			buf = grown
+		}
+	}
+}
+
+// 从 HTTP 请求中获取目标域名（不包括端口）：请求行为绝对 URI 时使用其中的域名，否则使用 Host 头部
+// 没有 Host 头部（例如 HTTP/1.0 请求）时返回空字符串
+func parseHTTPHost(buf []byte) (string, error) {
+	lines := strings.Split(string(buf), "\n")
+	requestLine := strings.TrimSuffix(lines[0], "\r")
+	parts := strings.Split(requestLine, " ")
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "HTTP/") {
+		if len(requestLine) > 64 {
+			requestLine = requestLine[:64]
+		}
+		return "", fmt.Errorf("不是 HTTP 请求: %q", requestLine)
+	}
+
+	host := ""
+	if target := strings.ToLower(parts[1]); strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		if u, err := url.Parse(parts[1]); err == nil {
+			host = u.Host
+		}
+	}
+	for _, line := range lines[1:] {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" || host != "" { // 请求头结束
+			break
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(name), "Host") {
+			host = strings.TrimSpace(value)
+		}
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.Trim(host, "[]"), nil
+}
@@ -0,0 +1,86 @@
+package sniproxy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// 读取 include、include_dir 中的配置文件并合并到 cfg（路径为相对路径时相对于主配置文件所在的目录）
+// 按 include 中的顺序、再按 include_dir 中的文件名顺序（*.yaml、*.yml、*.json、*.toml，格式根据扩展名判断）依次合并，后面的文件：
+// 列表（例如 rules、blocked_hosts、listeners）追加到前面的列表之后，映射（例如 hosts）逐个键覆盖，其他选项不为零值时覆盖
+func loadIncludes(cfg *Config, path string) error {
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	files := make([]string, 0, len(cfg.Include))
+	for _, p := range cfg.Include {
+		files = append(files, resolve(p))
+	}
+	if cfg.IncludeDir != "" {
+		entries, err := os.ReadDir(resolve(cfg.IncludeDir))
+		if err != nil {
+			return fmt.Errorf("配置文件中 include_dir 读取失败: %v", err)
+		}
+		var names []string
+		for _, e := range entries {
+			if ext := strings.ToLower(filepath.Ext(e.Name())); !e.IsDir() && (ext == ".yaml" || ext == ".yml" || ext == ".json" || ext == ".toml") {
+				names = append(names, e.Name())
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			files = append(files, filepath.Join(resolve(cfg.IncludeDir), name))
+		}
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("配置文件 %s 读取失败: %v", file, err)
+		}
+		included, err := parseConfigData(data, configFormat(file))
+		if err != nil {
+			return fmt.Errorf("配置文件 %s 解析失败: %v", file, err)
+		}
+		if len(included.Include) > 0 || included.IncludeDir != "" {
+			return fmt.Errorf("配置文件 %s 中不能再使用 include、include_dir", file)
+		}
+		mergeConfig(reflect.ValueOf(cfg).Elem(), reflect.ValueOf(included).Elem())
+	}
+	return nil
+}
+
+// 把 src 中配置文件里的选项合并到 dst（只处理有 yaml 标签的选项）
+func mergeConfig(dst, src reflect.Value) {
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("yaml"); tag == "" || tag == "-" {
+			continue
+		}
+		d, s := dst.Field(i), src.Field(i)
+		if s.IsZero() {
+			continue
+		}
+		switch s.Kind() {
+		case reflect.Slice:
+			d.Set(reflect.AppendSlice(d, s))
+		case reflect.Map:
+			if d.IsNil() {
+				d.Set(reflect.MakeMap(d.Type()))
+			}
+			iter := s.MapRange()
+			for iter.Next() {
+				d.SetMapIndex(iter.Key(), iter.Value())
+			}
+		default:
+			d.Set(s)
+		}
+	}
+}
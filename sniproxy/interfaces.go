@@ -0,0 +1,269 @@
+package sniproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// 获取出站连接使用的 Dialer（启用前置代理时通过 Socks5 代理连接目标）
+func GetDialer(cfg *Config) (proxy.ContextDialer, error) {
+	direct := &net.Dialer{Control: outboundControl(cfg)} // 指定了出站地址、网卡时，连接目标或 Socks5 代理都会使用
+	if cfg.outboundIP != nil {
+		direct.LocalAddr = &net.TCPAddr{IP: cfg.outboundIP}
+	}
+	direct.KeepAlive = time.Duration(cfg.TCPKeepAlive) * time.Second // tcp_keepalive 小于 0 时关闭 keepalive
+	if !cfg.EnableSocks {
+		return direct, nil
+	}
+	var auth *proxy.Auth
+	if cfg.SocksUser != "" || cfg.SocksPass != "" { // 配置了用户名或密码时使用用户名/密码认证（RFC 1929），否则为无认证
+		auth = &proxy.Auth{User: cfg.SocksUser, Password: cfg.SocksPass}
+	}
+	proxyDialer, err := proxy.SOCKS5("tcp", cfg.SocksAddr, auth, direct)
+	if err != nil {
+		return nil, fmt.Errorf("创建 Socks5 代理 %s 失败: %v", cfg.SocksAddr, err)
+	}
+	contextDialer, ok := proxyDialer.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("Socks5 代理 %s 不支持 DialContext", cfg.SocksAddr)
+	}
+	return contextDialer, nil
+}
+
+// 连接转发目标（先解析域名，启用 dns_cache_ttl 时优先使用缓存，再连接解析得到的 IP）
+// 解析后会排除指向本服务自身监听地址的 IP，避免循环转发
+// fromSNI 表示目标来自客户端的 SNI 域名（而不是规则中指定的目标），启用 block_private_ips 时检查解析结果
+// 解析域名和连接（包括 Socks5 握手、多个 IP 之间的故障转移）合计不超过 dial_timeout
+func dialTarget(ctx context.Context, cfg *Config, addr string, fromSNI bool) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.DialTimeout)*time.Second)
+	defer cancel()
+	dialer, err := GetDialer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("无效的端口: %s", portStr)
+	}
+	_, static := cfg.staticHost(host)
+	checkPrivate := fromSNI && cfg.BlockPrivateIPs && !static // 静态 hosts 与规则指定的目标一样由配置文件指定，不检查
+	ip := net.ParseIP(host)
+	// 启用前置代理时由 Socks5 代理解析域名（静态 hosts 中的域名除外），需要检查内网目标或限制 ip_version 时在本地解析，再通过代理连接解析得到的 IP
+	if ip == nil && !static && cfg.EnableSocks && !checkPrivate && cfg.IPVersion == 0 {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+	var ips []net.IP
+	if ip != nil { // 目标本身就是 IP
+		ips = []net.IP{ip}
+	} else if ips, err = resolveHost(ctx, host, cfg); err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("域名 %s 没有解析到 IP 地址", host)
+	}
+	if !cfg.EnableSocks { // 通过前置代理连接时，目标地址是相对于代理而言的
+		if ips, err = filterSelfIPs(host, ips, port); err != nil {
+			return nil, err
+		}
+	}
+	if checkPrivate { // 只连接检查通过的 IP（启用前置代理时也是如此），避免检查后再次解析得到不同的地址
+		if ips, err = filterPrivateIPs(host, ips, cfg); err != nil {
+			return nil, err
+		}
+	}
+	if ips, err = filterIPVersion(host, ips, int(cfg.IPVersion)); err != nil {
+		return nil, err
+	}
+	if !fromSNI && cfg.LoadBalance == loadBalanceRoundRobin && len(ips) > 1 { // 规则指定的目标域名解析到多个 IP 时轮询这些 IP
+		ips = rotate(ips, nextHostIndex(host))
+	}
+	return dialIPs(ctx, dialer, ips, portStr)
+}
+
+// 只保留 ip_version 指定版本的 IP（为 0 时不限制）
+func filterIPVersion(host string, ips []net.IP, version int) ([]net.IP, error) {
+	if version == 0 {
+		return ips, nil
+	}
+	var kept []net.IP
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (version == 4) {
+			kept = append(kept, ip)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("域名 %s 没有解析到 IPv%d 地址", host, version)
+	}
+	return kept, nil
+}
+
+const happyEyeballsDelay = 300 * time.Millisecond // 开始并行连接另一个地址族前的等待时间（与 Go 标准库的默认值相同）
+
+// 连接解析得到的 IP（Happy Eyeballs，RFC 8305）：先依次连接第一个 IP 所在的地址族（IPv6 或 IPv4），
+// 超过 happyEyeballsDelay 依然没有连接成功时并行连接另一个地址族，使用最先连接成功的连接，避免 IPv6 线路故障时长时间卡住
+func dialIPs(ctx context.Context, dialer proxy.ContextDialer, ips []net.IP, port string) (net.Conn, error) {
+	var primary, fallback []net.IP
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (ips[0].To4() != nil) {
+			primary = append(primary, ip)
+		} else {
+			fallback = append(fallback, ip)
+		}
+	}
+	if len(fallback) == 0 {
+		return dialSerial(ctx, dialer, primary, port)
+	}
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	ctx, cancel := context.WithCancel(ctx) // 返回时取消还在进行的连接
+	defer cancel()
+	results := make(chan dialResult, 2)
+	start := func(ips []net.IP) {
+		go func() {
+			conn, err := dialSerial(ctx, dialer, ips, port)
+			results <- dialResult{conn, err}
+		}()
+	}
+	start(primary)
+	timer := time.NewTimer(happyEyeballsDelay)
+	defer timer.Stop()
+	var errs []error
+	pending, fallbackStarted := 1, false
+	for {
+		select {
+		case <-timer.C:
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if pending > 0 { // 另一个地址族也连接成功时关闭其连接
+					go func() {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}()
+				}
+				return r.conn, nil
+			}
+			errs = append(errs, r.err)
+		}
+		if !fallbackStarted { // 等待超时或第一个地址族全部连接失败时，开始连接另一个地址族
+			start(fallback)
+			pending++
+			fallbackStarted = true
+		} else if pending == 0 {
+			return nil, errors.Join(errs...)
+		}
+	}
+}
+
+// 依次连接各个 IP，返回第一个连接成功的连接
+func dialSerial(ctx context.Context, dialer proxy.ContextDialer, ips []net.IP, port string) (net.Conn, error) {
+	var errs []error
+	for _, ip := range ips {
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// 依次连接转发目标（规则指定了多个目标时连接失败会尝试下一个），返回连接成功的目标
+func (p *Proxy) dialTargets(ctx context.Context, cfg *Config, targets []string, fromSNI bool, fields LogFields) (net.Conn, string, error) {
+	if len(targets) == 0 {
+		return nil, "", errors.New("没有可用的转发目标")
+	}
+	if len(targets) == 1 {
+		conn, err := p.dialTargetWithRetry(ctx, cfg, targets[0], fromSNI, fields)
+		return conn, targets[0], err
+	}
+	var errs []error
+	for i, target := range targets {
+		conn, err := p.dialTargetWithRetry(ctx, cfg, target, fromSNI, fields)
+		if err == nil {
+			return conn, target, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", target, err))
+		if ctx.Err() != nil { // 正在退出
+			break
+		}
+		if i < len(targets)-1 {
+			p.serviceLoggerFields(fmt.Sprintf("连接目标 %s 失败, 尝试下一个目标: %v", target, err), LevelWarn, fields)
+		}
+	}
+	return nil, strings.Join(targets, ","), errors.Join(errs...)
+}
+
+const maxDialRetryBackoff = 5 * time.Second // 重试连接目标前的最长等待时间
+
+// 连接转发目标，遇到临时性错误（超时、连接被拒绝等）时按 dial_retries 重试，每次重试前的等待时间翻倍
+func (p *Proxy) dialTargetWithRetry(ctx context.Context, cfg *Config, addr string, fromSNI bool, fields LogFields) (net.Conn, error) {
+	backoff := time.Duration(cfg.DialRetryBackoff) * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		if !p.breakers.allow(addr, cfg) { // 该目标熔断中（每次重试前也会检查）
+			return nil, errCircuitOpen
+		}
+		conn, err := dialTarget(ctx, cfg, addr, fromSNI)
+		if err == nil {
+			if p.breakers.success(addr) {
+				p.serviceLoggerFields(fmt.Sprintf("目标 %s 已恢复, 解除熔断", addr), LevelInfo, fields)
+			}
+			return conn, nil
+		}
+		if isRetryableDialError(err) && p.breakers.failure(addr, cfg) { // 只有目标无法连接（超时、连接被拒绝等）才计入连续失败次数
+			message := fmt.Sprintf("目标 %s 连续连接失败, 熔断 %d 秒: %v", addr, cfg.CircuitBreakerCooldown, err)
+			p.serviceLoggerFields(message, LevelWarn, fields)
+			p.webhook.notify(cfg, eventCircuitOpen, addr, message)
+		}
+		if attempt > cfg.DialRetries || !isRetryableDialError(err) {
+			return nil, err
+		}
+		p.serviceLoggerFields(fmt.Sprintf("连接目标 %s 失败, %v 后进行第 %d 次重试: %v", addr, backoff, attempt, err), LevelDebug, fields)
+		select {
+		case <-ctx.Done(): // 正在退出
+			return nil, err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxDialRetryBackoff {
+			backoff = maxDialRetryBackoff
+		}
+	}
+}
+
+// 是否为重试可能成功的连接错误（域名不存在、目标被禁止、代理认证失败等重试也不会成功）
+func isRetryableDialError(err error) bool {
+	if errors.Is(err, errPrivateTarget) || errors.Is(err, errSelfTarget) || isSocksAuthError(err) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound && (dnsErr.IsTimeout || dnsErr.IsTemporary)
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ENETUNREACH) || errors.Is(err, syscall.EHOSTUNREACH) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// 是否为 Socks5 代理拒绝了用户名/密码（x/net/proxy 没有导出该错误，只能判断错误信息）
+func isSocksAuthError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "username/password authentication failed")
+}
@@ -0,0 +1,98 @@
+package sniproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+// 测试用的 Socks5 代理：记录每个 CONNECT 请求的目标地址（域名或 IP），然后拒绝连接
+func newTestSocks5(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	targets := make(chan string, 16)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				buf := make([]byte, 512)
+				if _, err := io.ReadFull(c, buf[:2]); err != nil { // VER NMETHODS
+					return
+				}
+				if _, err := io.ReadFull(c, buf[:buf[1]]); err != nil {
+					return
+				}
+				c.Write([]byte{5, 0}) // 不需要认证
+
+				if _, err := io.ReadFull(c, buf[:4]); err != nil { // VER CMD RSV ATYP
+					return
+				}
+				var host string
+				switch buf[3] {
+				case 1:
+					io.ReadFull(c, buf[:4])
+					host = net.IP(buf[:4]).String()
+				case 4:
+					io.ReadFull(c, buf[:16])
+					host = net.IP(buf[:16]).String()
+				case 3:
+					io.ReadFull(c, buf[:1])
+					n := int(buf[0])
+					io.ReadFull(c, buf[:n])
+					host = "domain:" + string(buf[:n])
+				}
+				io.ReadFull(c, buf[:2])
+				targets <- host
+				c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0}) // 连接被拒绝
+			}()
+		}
+	}()
+	return l.Addr().String(), targets
+}
+
+// 启用前置代理时默认由代理解析域名，配置了 ip_version 时在本地解析并只连接该版本的 IP
+func TestDialTargetSocksIPVersion(t *testing.T) {
+	socksAddr, targets := newTestSocks5(t)
+	for _, tt := range []struct {
+		ipVersion string
+		want      string
+	}{
+		{"auto", "domain:localhost"},
+		{"ipv4", "127.0.0.1"},
+	} {
+		cfg, err := ParseConfig([]byte(fmt.Sprintf("allow_all_hosts: true\nenable_socks5: true\nsocks_addr: %s\nip_version: %s\n", socksAddr, tt.ipVersion)), "test.yaml")
+		if err == nil {
+			cfg, err = prepareConfig(cfg)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn, err := dialTarget(context.Background(), cfg, "localhost:443", false); err == nil {
+			conn.Close()
+			t.Fatalf("ip_version %s: Socks5 代理拒绝连接时没有出错", tt.ipVersion)
+		}
+		select {
+		case got := <-targets:
+			if got != tt.want {
+				t.Errorf("ip_version %s: 代理收到的目标为 %q, 期望 %q", tt.ipVersion, got, tt.want)
+			}
+		default:
+			t.Errorf("ip_version %s: 代理没有收到连接请求", tt.ipVersion)
+		}
+		for len(targets) > 0 { // Happy Eyeballs 可能连接了多个 IP
+			if got := <-targets; got != tt.want {
+				t.Errorf("ip_version %s: 代理收到的目标为 %q, 期望 %q", tt.ipVersion, got, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package sniproxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+var (
+	errPrivateTarget = errors.New("目标为内网地址")       // SNI 域名解析到了内网地址（block_private_ips）
+	errSelfTarget    = errors.New("目标为本服务自身的监听地址") // 转发至自身会导致循环转发
+)
+
+// 本服务正在监听的地址（用于检查转发目标是否指向自身）
+type listenerRegistry struct {
+	mu    sync.Mutex
+	addrs []*net.TCPAddr
+}
+
+var localListeners = &listenerRegistry{}
+
+// 登记监听地址（quic 模式的 UDP 监听地址也按 TCP 地址登记，不区分协议）
+func (r *listenerRegistry) add(addr net.Addr) {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if udpAddr, isUDP := addr.(*net.UDPAddr); isUDP {
+		tcpAddr, ok = &net.TCPAddr{IP: udpAddr.IP, Port: udpAddr.Port, Zone: udpAddr.Zone}, true
+	}
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	r.addrs = append(r.addrs, tcpAddr)
+	r.mu.Unlock()
+}
+
+// 移除监听地址（监听关闭后）
+func (r *listenerRegistry) remove(addr net.Addr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, a := range r.addrs {
+		if a.String() == addr.String() {
+			r.addrs = append(r.addrs[:i], r.addrs[i+1:]...)
+			return
+		}
+	}
+}
+
+// 目标 IP:端口 是否为本服务自身的监听地址（监听 0.0.0.0 等地址时，本机的任意 IP 都算）
+func (r *listenerRegistry) isSelf(ip net.IP, port int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, addr := range r.addrs {
+		if addr.Port != port {
+			continue
+		}
+		if addr.IP.Equal(ip) || ip.IsUnspecified() || (addr.IP.IsUnspecified() && isLocalIP(ip)) {
+			return true
+		}
+	}
+	return false
+}
+
+// 是否为本机地址（环回地址或网卡上的地址）
+func isLocalIP(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 过滤掉指向本服务自身监听地址的 IP，全部被过滤时返回错误
+func filterSelfIPs(host string, ips []net.IP, port int) ([]net.IP, error) {
+	allowed := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if !localListeners.isSelf(ip, port) {
+			allowed = append(allowed, ip)
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("%w: %s:%d", errSelfTarget, host, port)
+	}
+	return allowed, nil
+}
+
+// 运营商级 NAT 地址段（RFC 6598）和 "本网络" 地址段（RFC 1122），net.IP 没有对应的判断方法
+var extraPrivateNets = mustParseCIDRs("100.64.0.0/10", "0.0.0.0/8")
+
+// 是否为内网地址（环回、链路本地、私有地址等）
+func isPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, n := range extraPrivateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 过滤掉不允许连接的内网地址（allowed_private_ips 中的地址除外），全部被过滤时返回错误
+func filterPrivateIPs(host string, ips []net.IP, cfg *Config) ([]net.IP, error) {
+	allowed := make([]net.IP, 0, len(ips))
+	var blocked []string
+	for _, ip := range ips {
+		if isPrivateIP(ip) && !ipInNets(ip, cfg.allowedPrivateNets) {
+			blocked = append(blocked, ip.String())
+			continue
+		}
+		allowed = append(allowed, ip)
+	}
+	if len(allowed) == 0 && len(blocked) > 0 {
+		return nil, fmt.Errorf("%w: %s => %s", errPrivateTarget, host, strings.Join(blocked, ", "))
+	}
+	return allowed, nil
+}
+
+// 客户端是否允许连接（未配置 allowed_clients 时允许所有客户端）
+func clientAllowed(ip net.IP, cfg *Config) bool {
+	return len(cfg.allowedClientNets) == 0 || ipInNets(ip, cfg.allowedClientNets)
+}
+
+// IP 是否在任意一个地址段中
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 解析 CIDR 地址段列表（单个 IP 视为只包含该 IP 的地址段）
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("无效的 IP 地址: %s", s)
+			}
+			if ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("无效的地址段: %s", s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// 解析 CIDR 地址段列表（仅用于内置的地址段）
+func mustParseCIDRs(list ...string) []*net.IPNet {
+	nets, err := parseCIDRs(list)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
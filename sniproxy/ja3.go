@@ -0,0 +1,74 @@
+package sniproxy
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 计算 JA3 指纹：MD5(版本,加密套件,扩展,椭圆曲线,椭圆曲线点格式)，列表中的值以 - 分隔（排除 GREASE）
+// parseClientHello 已经排除了 GREASE 值，这里再检查一次，也适用于其它方式构造的 clientHelloMsg
+func ja3Fingerprint(m *clientHelloMsg) string {
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(int(m.vers)))
+	b.WriteByte(',')
+	writeJA3List(&b, m.cipherSuites)
+	b.WriteByte(',')
+	writeJA3List(&b, m.extensions)
+	b.WriteByte(',')
+	curves := make([]uint16, len(m.supportedCurves))
+	for i, c := range m.supportedCurves {
+		curves[i] = uint16(c)
+	}
+	writeJA3List(&b, curves)
+	b.WriteByte(',')
+	for i, p := range m.supportedPoints {
+		if i > 0 {
+			b.WriteByte('-')
+		}
+		b.WriteString(strconv.Itoa(int(p)))
+	}
+	sum := md5.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// 写入以 - 分隔的列表（排除 GREASE）
+func writeJA3List(b *strings.Builder, values []uint16) {
+	first := true
+	for _, v := range values {
+		if isGREASE(v) {
+			continue
+		}
+		if !first {
+			b.WriteByte('-')
+		}
+		b.WriteString(strconv.Itoa(int(v)))
+		first = false
+	}
+}
+
+// 解析 JA3 指纹列表（32 位十六进制 MD5，不区分大小写）
+func parseJA3List(list []string) (map[string]bool, error) {
+	set := make(map[string]bool, len(list))
+	for _, s := range list {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if _, err := hex.DecodeString(s); err != nil || len(s) != 32 {
+			return nil, fmt.Errorf("无效的 JA3 指纹: %s", s)
+		}
+		set[s] = true
+	}
+	return set, nil
+}
+
+// 检查 JA3 指纹是否允许连接，不允许时返回原因（未配置 blocked_ja3、allowed_ja3 时允许所有指纹）
+func checkJA3(ja3 string, cfg *Config) string {
+	if cfg.blockedJA3[ja3] {
+		return "在 blocked_ja3 中"
+	}
+	if len(cfg.allowedJA3) > 0 && !cfg.allowedJA3[ja3] {
+		return "不在 allowed_ja3 中"
+	}
+	return ""
+}
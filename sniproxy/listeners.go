@@ -0,0 +1,173 @@
+package sniproxy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 监听模式（mode）
+const (
+	listenModeSNI  = "sni"  // 根据 TLS ClientHello 中的 SNI 域名转发（默认）
+	listenModeHTTP = "http" // 根据明文 HTTP 请求中的 Host 头部转发
+	listenModeQUIC = "quic" // 监听 UDP，根据 QUIC Initial 包中的 SNI 域名转发（HTTP/3）
+)
+
+// 监听配置（listeners 中的一项；顶层的 listen_addr、rules、forward_port、allow_all_hosts 为其中一个监听的简写）
+type ListenerConfig struct {
+	ListenAddr    addrList `yaml:"listen_addr,omitempty"`
+	ForwardRules  []string `yaml:"rules,omitempty"`
+	ForwardPort   int      `yaml:"forward_port,omitempty"`
+	AllowAllHosts bool     `yaml:"allow_all_hosts,omitempty"`
+	Mode          string   `yaml:"mode,omitempty"`
+
+	rules []*forwardRule // 解析后的 rules
+}
+
+// 解析所有监听配置（配置了顶层 listen_addr 或没有配置 listeners 时，顶层配置作为第一个监听），返回所有错误
+func parseListeners(cfg *Config) []error {
+	var errs []error
+	if len(cfg.ListenAddr) > 0 || len(cfg.Listeners) == 0 {
+		top := &ListenerConfig{ListenAddr: cfg.ListenAddr, ForwardRules: cfg.ForwardRules, ForwardPort: cfg.ForwardPort, AllowAllHosts: cfg.AllowAllHosts, Mode: cfg.Mode}
+		if err := top.checkMode("mode"); err != nil {
+			errs = append(errs, err)
+		}
+		if len(top.ListenAddr) == 0 { // 未配置 listen_addr 时监听随机端口（与 net.Listen 的空地址相同）
+			top.ListenAddr = addrList{""}
+		}
+		errs = append(errs, top.checkAddrs("listen_addr")...)
+		if len(top.ForwardRules) <= 0 && !top.AllowAllHosts { // 如果 rules 为空且 allow_all_hosts 不等于 true
+			errs = append(errs, errors.New("配置文件中 rules 不能为空（除非 allow_all_hosts 等于 true）!"))
+		}
+		errs = append(errs, top.parseRules(cfg, "rules")...)
+		cfg.listeners = append(cfg.listeners, top)
+	} else if len(cfg.ForwardRules) > 0 || cfg.AllowAllHosts {
+		errs = append(errs, errors.New("配置文件中 rules、allow_all_hosts 需要与 listen_addr 一起配置（或移到 listeners 中）!"))
+	}
+
+	for i, lc := range cfg.Listeners {
+		l := new(ListenerConfig) // 在副本上填充默认值，不修改传入的配置
+		*l = *lc
+		l.rules = nil
+		name := fmt.Sprintf("listeners[%d]", i)
+		if len(l.ListenAddr) == 0 {
+			errs = append(errs, fmt.Errorf("配置文件中 %s 的 listen_addr 不能为空!", name))
+		}
+		errs = append(errs, l.checkAddrs(name+".listen_addr")...)
+		if err := l.checkMode(name + ".mode"); err != nil {
+			errs = append(errs, err)
+		}
+		if l.ForwardPort == 0 && l.Mode == listenModeHTTP { // http 模式下未配置时默认转发至 80 端口
+			l.ForwardPort = defaultHTTPForwardPort
+		} else if l.ForwardPort == 0 { // 未配置时使用顶层的 forward_port
+			l.ForwardPort = cfg.ForwardPort
+		}
+		if l.ForwardPort < 1 || l.ForwardPort > 65535 {
+			errs = append(errs, fmt.Errorf("配置文件中 %s 的 forward_port 无效: %d（范围 1-65535）!", name, l.ForwardPort))
+		}
+		if len(l.ForwardRules) <= 0 && !l.AllowAllHosts {
+			errs = append(errs, fmt.Errorf("配置文件中 %s 的 rules 不能为空（除非 allow_all_hosts 等于 true）!", name))
+		}
+		errs = append(errs, l.parseRules(cfg, name+".rules")...)
+		cfg.listeners = append(cfg.listeners, l)
+	}
+	for _, l := range cfg.listeners {
+		if l.hasUnixAddr() && (l.Mode == listenModeQUIC || cfg.Transparent != "") { // Unix socket 只能是流式连接，也没有原始目标地址
+			errs = append(errs, errors.New("配置文件中 listen_addr 为 Unix socket（unix:路径）时不能使用 quic 模式或 transparent!"))
+			break
+		}
+	}
+	for _, l := range cfg.listeners {
+		if l.Mode == listenModeQUIC && cfg.EnableSocks { // Socks5 代理（CONNECT）只能转发 TCP
+			errs = append(errs, errors.New("配置文件中 mode 为 quic 时不能启用 enable_socks5!"))
+			break
+		}
+	}
+	return errs
+}
+
+// 检查监听地址的格式（只检查格式，能否监听在启动时才知道）
+func (l *ListenerConfig) checkAddrs(name string) []error {
+	var errs []error
+	for _, addr := range l.ListenAddr {
+		if addr == "" { // 空地址代表监听随机端口
+			continue
+		}
+		if _, ok := unixSocketPath(addr); ok {
+			if err := checkUnixAddr(addr); err != nil {
+				errs = append(errs, fmt.Errorf("配置文件中 %s 无效: %v!", name, err))
+			}
+			continue
+		}
+		if err := checkAddr(addr); err != nil {
+			errs = append(errs, fmt.Errorf("配置文件中 %s 无效: %v!", name, err))
+		}
+	}
+	return errs
+}
+
+// 是否有 Unix socket 监听地址
+func (l *ListenerConfig) hasUnixAddr() bool {
+	for _, addr := range l.ListenAddr {
+		if _, ok := unixSocketPath(addr); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 检查监听模式
+func (l *ListenerConfig) checkMode(name string) error {
+	switch l.Mode {
+	case "": // 未配置 mode 时默认为 sni 模式
+		l.Mode = listenModeSNI
+	case listenModeSNI, listenModeHTTP, listenModeQUIC:
+	default:
+		return fmt.Errorf("配置文件中 %s 无效: %s（可选 sni、http、quic）!", name, l.Mode)
+	}
+	return nil
+}
+
+// 解析该监听的规则，返回所有无效规则的错误
+func (l *ListenerConfig) parseRules(cfg *Config, name string) []error {
+	var errs []error
+	for _, rule := range l.ForwardRules { // 解析规则中的所有域名
+		r, err := parseRule(rule, l.ForwardPort, cfg.LegacyRuleMatch)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("配置文件中 %s 无效: %v", name, err))
+			continue
+		}
+		l.rules = append(l.rules, r)
+	}
+	return errs
+}
+
+// 所有监听地址（用于判断重载配置时是否修改了监听地址）
+func (cfg *Config) listenAddrs() string {
+	var addrs []string
+	for _, l := range cfg.listeners {
+		addrs = append(addrs, strings.Join(l.ListenAddr, ", "))
+	}
+	return strings.Join(addrs, "; ")
+}
+
+// 输出监听配置（只有一个监听时与顶层配置的输出相同）
+func (p *Proxy) printListeners(cfg *Config) {
+	for _, l := range cfg.listeners {
+		prefix := ""
+		if len(cfg.listeners) > 1 {
+			prefix = fmt.Sprintf("[%s] ", strings.Join(l.ListenAddr, ", "))
+		}
+		for _, rule := range l.ForwardRules { // 输出规则中的所有域名
+			p.serviceLogger(fmt.Sprintf("%s加载规则: %v", prefix, rule), LevelInfo)
+		}
+		p.serviceLogger(fmt.Sprintf("%s转发端口: %v", prefix, l.ForwardPort), LevelInfo)
+		p.serviceLogger(fmt.Sprintf("%s任意域名: %v", prefix, l.AllowAllHosts), LevelInfo)
+		switch l.Mode {
+		case listenModeHTTP:
+			p.serviceLogger(fmt.Sprintf("%s监听模式: http（根据 HTTP 请求的 Host 头部转发）", prefix), LevelInfo)
+		case listenModeQUIC:
+			p.serviceLogger(fmt.Sprintf("%s监听模式: quic（UDP，根据 QUIC Initial 包中的 SNI 域名转发）", prefix), LevelInfo)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package sniproxy
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// 日志文件（保持打开同一个文件句柄，超过大小上限时轮转）
+type logFile struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	size int64 // 当前文件大小
+}
+
+var serviceLogFile = &logFile{}
+
+// 写入一行日志
+func (l *logFile) writeLine(cfg *Config, line string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil || l.path != cfg.LogFile {
+		if err := l.open(cfg.LogFile); err != nil {
+			return err
+		}
+	}
+	if maxSize := logMaxSize(cfg); maxSize > 0 && l.size > 0 && l.size+int64(len(line))+1 > maxSize {
+		if err := l.rotate(cfg); err != nil {
+			return err
+		}
+	}
+	n, err := fmt.Fprintf(l.file, "%s\n", line)
+	l.size += int64(n)
+	return err
+}
+
+// 打开日志文件（不存在则创建）
+func (l *logFile) open(path string) error {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.path, l.file, l.size = path, file, info.Size()
+	return nil
+}
+
+// 重新打开日志文件（配合外部 logrotate 等工具使用，收到 SIGUSR1 信号时调用）
+func (l *logFile) reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return l.open(l.path)
+}
+
+// 轮转日志文件：sni.log => sni.log.1 => sni.log.2 ...，超过保留数量或保留天数的旧文件会被删除
+func (l *logFile) rotate(cfg *Config) error {
+	l.file.Close()
+	l.file = nil
+	backups := logMaxBackups(cfg)
+	if backups <= 0 { // 不保留旧文件
+		os.Remove(l.path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", l.path, backups))
+		for i := backups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+		}
+		os.Rename(l.path, l.path+".1")
+	}
+	if maxAge := logMaxAge(cfg); maxAge > 0 {
+		for i := 1; i <= backups; i++ {
+			name := fmt.Sprintf("%s.%d", l.path, i)
+			if info, err := os.Stat(name); err == nil && time.Since(info.ModTime()) > maxAge {
+				os.Remove(name)
+			}
+		}
+	}
+	return l.open(l.path)
+}
+
+// 日志文件大小上限（字节，0 代表不轮转）
+func logMaxSize(cfg *Config) int64 {
+	return int64(cfg.MaxLogSizeMB) * 1024 * 1024
+}
+
+// 轮转后保留的旧日志文件数量
+func logMaxBackups(cfg *Config) int {
+	return cfg.MaxLogBackups
+}
+
+// 轮转后保留的旧日志文件天数（0 代表不按天数删除）
+func logMaxAge(cfg *Config) time.Duration {
+	return time.Duration(cfg.MaxLogAgeDays) * 24 * time.Hour
+}
@@ -0,0 +1,207 @@
+package sniproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// 日志格式
+const (
+	logFormatText = "text" // 带颜色的文本（默认）
+	logFormatJSON = "json" // 每行一个 JSON 对象
+)
+
+// 日志级别
+type Level int
+
+const (
+	LevelDebug Level = iota // 调试（详细日志）
+	LevelInfo               // 信息
+	LevelWarn               // 警告
+	LevelError              // 错误
+)
+
+// 日志级别名称
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// 日志级别对应的颜色代码
+func (l Level) color() int {
+	switch l {
+	case LevelDebug:
+		return 36 // 青色
+	case LevelWarn:
+		return 33 // 黄色
+	case LevelError:
+		return 31 // 红色
+	default:
+		return 32 // 绿色
+	}
+}
+
+// 解析日志级别名称
+func parseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("未知的日志级别: %s（可选 debug、info、warn、error）", name)
+}
+
+// 日志附加字段（JSON 格式时输出为对应字段）
+type LogFields struct {
+	ID      string   `json:"conn_id,omitempty"` // 连接 ID
+	Client  string   `json:"client,omitempty"`  // 客户端地址
+	SNI     string   `json:"sni,omitempty"`     // SNI 域名
+	Target  string   `json:"target,omitempty"`  // 转发目标
+	ALPN    []string `json:"alpn,omitempty"`    // 客户端提供的 ALPN 协议列表
+	JA3     string   `json:"ja3,omitempty"`     // 客户端的 JA3 指纹
+	Country string   `json:"country,omitempty"` // 客户端所在的国家或地区（配置了 geoip_db 时）
+	*ConnSummary
+}
+
+// 连接结束时的统计（仅用于连接结束的日志）
+type ConnSummary struct {
+	BytesUp    int64 `json:"bytes_up"`    // 上行（客户端到目标）字节数
+	BytesDown  int64 `json:"bytes_down"`  // 下行（目标到客户端）字节数
+	DurationMs int64 `json:"duration_ms"` // 连接时长（毫秒）
+}
+
+// JSON 格式的一行日志
+type jsonLogEntry struct {
+	Timestamp string `json:"timestamp"`
+	Level     string `json:"level"`
+	Message   string `json:"message"`
+	LogFields
+}
+
+// 日志输出（默认为 stdLogger，可以替换为自己的实现，例如接入已有的日志系统、在测试中收集日志）
+// 低于 min_log_level 的日志不会交给 Logger；fields 为连接 ID、SNI 域名等附加字段（没有时为空）
+type Logger interface {
+	Log(message string, level Level, fields LogFields)
+}
+
+// 默认日志：输出到标准输出（log_format 为 text 或 json），配置了日志文件时同时写入日志文件，配置了 syslog_addr 时同时发送到 syslog 服务器
+type stdLogger struct {
+	config func() *Config // 获取当前配置（日志格式、颜色、日志文件等），返回空时使用默认设置
+}
+
+// 未创建 Proxy 时使用的默认日志（例如配置无效时输出错误）
+var defaultLogger = &stdLogger{config: func() *Config { return nil }}
+
+// 日志格式（未加载配置时为默认格式）
+func logFormat(cfg *Config) string {
+	if cfg != nil && cfg.LogFormat != "" {
+		return cfg.LogFormat
+	}
+	return logFormatText
+}
+
+// 标准输出是否为终端（重定向到文件或管道时不是）
+var stdoutIsTerminal = func() bool {
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}()
+
+// 是否输出颜色代码（仅在终端中输出，且未通过 no_color 或环境变量 NO_COLOR 关闭）
+func colorEnabled(cfg *Config) bool {
+	if !stdoutIsTerminal || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return cfg == nil || !cfg.NoColor
+}
+
+// 最低日志级别（低于该级别的日志不输出，-d 调试模式下始终为 debug）
+func logLevel(cfg *Config) Level {
+	if cfg == nil {
+		return LevelInfo
+	}
+	if cfg.Debug {
+		return LevelDebug
+	}
+	return cfg.minLogLevel
+}
+
+// 使用默认日志输出一行日志（用于创建 Proxy 之前，例如配置无效时）
+func Log(message string, level Level) {
+	if level >= logLevel(nil) {
+		defaultLogger.Log(message, level, LogFields{})
+	}
+}
+
+// 输出一行日志（与 Proxy 内部的日志一样受 min_log_level 限制、交给 Logger 输出）
+func (p *Proxy) Log(message string, level Level) {
+	p.serviceLoggerFields(message, level, LogFields{})
+}
+
+// 服务日志
+func (p *Proxy) serviceLogger(message string, level Level) {
+	p.serviceLoggerFields(message, level, LogFields{})
+}
+
+// 服务日志（带附加字段）
+func (p *Proxy) serviceLoggerFields(message string, level Level, fields LogFields) {
+	if level < logLevel(p.getConfig()) {
+		return
+	}
+	if p.Logger != nil {
+		p.Logger.Log(message, level, fields)
+		return
+	}
+	p.std.Log(message, level, fields)
+}
+
+// 输出一行日志
+func (l *stdLogger) Log(message string, level Level, fields LogFields) {
+	cfg := l.config()
+	if logFormat(cfg) == logFormatJSON {
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false) // 不转义错误信息中的 -> 等字符
+		encoder.Encode(jsonLogEntry{
+			Timestamp: time.Now().Format(time.RFC3339Nano),
+			Level:     level.String(),
+			Message:   message,
+			LogFields: fields,
+		})
+		message = strings.TrimSuffix(buf.String(), "\n")
+		fmt.Println(message)
+	} else {
+		if fields.ID != "" { // 文本格式时在开头加上连接 ID
+			message = fmt.Sprintf("[%s] %s", fields.ID, message)
+		}
+		if colorEnabled(cfg) {
+			fmt.Printf("\x1b[%dm%s\x1b[0m\n", level.color(), message)
+		} else {
+			fmt.Println(message)
+		}
+	}
+	if cfg != nil && cfg.LogFile != "" { // 日志文件中始终不带颜色代码
+		if err := serviceLogFile.writeLine(cfg, message); err != nil {
+			fmt.Printf("无法写入日志文件: %v\n", err)
+		}
+	}
+	if cfg != nil && cfg.SyslogAddr != "" {
+		serviceSyslog.write(cfg, level, message)
+	}
+}
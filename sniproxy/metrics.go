@@ -0,0 +1,106 @@
+package sniproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus 指标（未配置 metrics_addr 时也会统计，只是不对外提供）
+var (
+	metricConnectionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sniproxy_connections_total",
+		Help: "已接受的连接总数",
+	})
+	metricActiveConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sniproxy_active_connections",
+		Help: "当前活动的连接数",
+	})
+	metricMaxConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sniproxy_max_connections",
+		Help: "允许的最大连接数（max_connections，0 为不限制）",
+	})
+	metricRejectedConnections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sniproxy_rejected_connections_total",
+		Help: "在转发前就被拒绝的连接数（包括 QUIC 会话，reason: allowed_clients、rate_limit、max_conns_per_ip、max_connections、blocked_hosts、ja3、hook、geoip、schedule、invalid_sni、non_tls、worker_queue_full）",
+	}, []string{"reason"})
+	metricBytesForwarded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sniproxy_bytes_forwarded_total",
+		Help: "转发的字节总数（direction: upstream 为客户端到目标，downstream 为目标到客户端）",
+	}, []string{"direction"})
+	metricDialFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sniproxy_upstream_dial_failures_total",
+		Help: "连接目标失败的次数",
+	})
+	metricCircuitBreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sniproxy_circuit_breaker_state",
+		Help: "连接失败过的目标的熔断器状态（0 为正常，1 为熔断中，2 为正在试探，连接成功后删除该目标）",
+	}, []string{"target"})
+	metricCircuitBreakerOpens = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sniproxy_circuit_breaker_opens_total",
+		Help: "目标开始熔断的次数",
+	})
+	metricSNIParseFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sniproxy_sni_parse_failures_total",
+		Help: "解析 ClientHello 失败或未找到 SNI 域名的次数",
+	})
+	metricALPN = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sniproxy_alpn_total",
+		Help: "客户端首选的 ALPN 协议（protocol: h2、http/1.1、http/1.0、h3、other 为其他协议，none 为未提供 ALPN）",
+	}, []string{"protocol"})
+	metricRuleMatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sniproxy_rule_matches_total",
+		Help: "各规则匹配的次数（allow_all_hosts 时 rule 为 *）",
+	}, []string{"rule"})
+	metricSyslogDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sniproxy_syslog_dropped_total",
+		Help: "未能发送到 syslog 服务器而丢弃的日志行数（发送队列已满、连接失败或等待重新连接期间）",
+	})
+)
+
+// ALPN 指标的 protocol 标签（ALPN 由客户端随意填写，只记录常见协议，避免标签数量无限增长）
+func alpnLabel(protocols []string) string {
+	if len(protocols) == 0 {
+		return "none"
+	}
+	switch protocols[0] {
+	case "h2", "http/1.1", "http/1.0", "h3":
+		return protocols[0]
+	}
+	return "other"
+}
+
+// 启动 Prometheus 指标服务（/metrics）
+func (p *Proxy) startMetricsServer(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	localListeners.add(listener.Addr())
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			p.serviceLogger(fmt.Sprintf("指标服务出错: %v", err), LevelError)
+		}
+	}()
+	p.serviceLogger(fmt.Sprintf("指标服务: http://%v/metrics", listener.Addr()), LevelInfo)
+	return server, nil
+}
+
+// 关闭 HTTP 服务（Prometheus 指标服务、管理 API、pprof 服务）
+func stopHTTPServer(server *http.Server) {
+	if server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
+}
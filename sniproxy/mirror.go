@@ -0,0 +1,96 @@
+package sniproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const mirrorQueueSize = 32 // 每个连接等待发送到镜像地址的数据块数量上限（超过时丢弃，不影响转发）
+
+// 把客户端发送给目标的数据复制一份发送到 mirror_addr（每个连接一个镜像连接，只有上行方向，尽力而为）
+type trafficMirror struct {
+	queue   chan []byte
+	dropped atomic.Int64 // 镜像连接失败或发送过慢时丢弃的字节数
+}
+
+// 开始镜像该连接（未配置 mirror_addr 时返回空），在后台连接镜像地址并发送数据
+func (p *Proxy) startMirror(ctx context.Context, cfg *Config, fields LogFields) *trafficMirror {
+	if cfg.MirrorAddr == "" {
+		return nil
+	}
+	m := &trafficMirror{queue: make(chan []byte, mirrorQueueSize)}
+	go m.run(ctx, p, cfg, fields)
+	return m
+}
+
+// 连接镜像地址并依次发送数据，出错后丢弃之后的数据（只记录一次日志）
+func (m *trafficMirror) run(ctx context.Context, p *Proxy, cfg *Config, fields LogFields) {
+	timeout := time.Duration(cfg.DialTimeout) * time.Second
+	dialer := &net.Dialer{Timeout: timeout, Control: outboundControl(cfg)}
+	conn, err := dialer.DialContext(ctx, "tcp", cfg.MirrorAddr)
+	if err != nil {
+		p.serviceLoggerFields(fmt.Sprintf("连接镜像地址 %s 失败, 该连接不再镜像: %v", cfg.MirrorAddr, err), LevelWarn, fields)
+	} else {
+		defer conn.Close()
+	}
+	for b := range m.queue {
+		if conn == nil {
+			m.dropped.Add(int64(len(b)))
+			continue
+		}
+		conn.SetWriteDeadline(time.Now().Add(timeout)) // 镜像地址不读取数据时不会一直等待
+		if _, err := conn.Write(b); err != nil {
+			p.serviceLoggerFields(fmt.Sprintf("向镜像地址 %s 发送数据时出错, 该连接不再镜像: %v", cfg.MirrorAddr, err), LevelWarn, fields)
+			conn.Close()
+			conn = nil
+			m.dropped.Add(int64(len(b)))
+		}
+	}
+	if n := m.dropped.Load(); n > 0 && conn != nil { // 连接失败、发送出错时已经记录过日志
+		p.serviceLoggerFields(fmt.Sprintf("镜像地址 %s 处理过慢, 该连接丢弃了 %d 字节的镜像数据", cfg.MirrorAddr, n), LevelWarn, fields)
+	}
+}
+
+// 复制一份数据放入发送队列（队列已满时直接丢弃，不会阻塞转发）
+func (m *trafficMirror) write(b []byte) {
+	if m == nil || len(b) == 0 {
+		return
+	}
+	select {
+	case m.queue <- append([]byte(nil), b...):
+	default:
+		m.dropped.Add(int64(len(b)))
+	}
+}
+
+// 转发结束（发送完队列中的数据后关闭镜像连接）
+func (m *trafficMirror) close() {
+	if m != nil {
+		close(m.queue)
+	}
+}
+
+// 包装客户端连接，读取的数据同时放入镜像队列（没有镜像时返回原连接）
+// 包装后不再是 *net.TCPConn，因此上行方向不会使用 splice 转发
+func (m *trafficMirror) wrap(c net.Conn) net.Conn {
+	if m == nil {
+		return c
+	}
+	return &mirroredConn{Conn: c, mirror: m}
+}
+
+// 读取的数据会被镜像的连接
+type mirroredConn struct {
+	net.Conn
+	mirror *trafficMirror
+}
+
+// 读取数据
+func (c *mirroredConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.mirror.write(b[:n])
+	return n, err
+}
@@ -0,0 +1,41 @@
+package sniproxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// pprof_addr 的地址，省略 IP 时只监听本机（避免意外对外开放）
+func pprofListenAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// 启动 pprof 性能分析服务（/debug/pprof/，使用单独的 ServeMux，不影响嵌入本库的程序）
+func (p *Proxy) startPprofServer(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", pprofListenAddr(addr))
+	if err != nil {
+		return nil, err
+	}
+	localListeners.add(listener.Addr())
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second} // CPU profile、trace 需要较长时间，不设置写入超时
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			p.serviceLogger(fmt.Sprintf("pprof 服务出错: %v", err), LevelError)
+		}
+	}()
+	p.serviceLogger(fmt.Sprintf("pprof 服务: http://%v/debug/pprof/", listener.Addr()), LevelInfo)
+	return server, nil
+}
@@ -0,0 +1,735 @@
+package sniproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// SNI Proxy 实例（可以嵌入其他程序中运行，Prometheus 指标、DNS 缓存为全局，同一进程中的多个实例共用）
+type Proxy struct {
+	config      atomic.Pointer[Config] // 当前使用的配置，重载配置时整体替换
+	conns       *connRegistry          // 正在处理的连接（退出时等待这些连接结束）
+	clientConns *ipConnCounter         // 每个客户端 IP 的连接数（max_conns_per_ip）
+	clientRate  *rateLimiter           // 每个客户端 IP 的新建连接速率（conn_rate_per_ip）
+	breakers    *circuitBreakers       // 各转发目标的熔断器（circuit_breaker_failures）
+	summary     *runSummary            // 运行期间的累计统计（退出时输出）
+	tracer      *traceExporter         // 发送追踪数据（otlp_endpoint）
+	webhook     *webhookNotifier       // 发送事件通知（webhook_url）
+	std         *stdLogger             // 默认日志
+
+	reloadMu sync.Mutex // 保护 source，重载配置、管理 API 修改规则时持有
+	source   *Config    // New、Reload 传入的原始配置（管理 API 在其副本上修改规则，写回配置文件时使用）
+
+	Logger Logger // 日志输出，为空时使用默认日志（标准输出、日志文件），需要在 Start 之前设置
+
+	// 连接生命周期回调（均为可选，需要在 Start 之前设置），在处理该连接的 goroutine 中同步调用，不应长时间阻塞
+	OnAccept  func(client net.Addr)                   // 接受新连接后（启用 accept_proxy_protocol 时为 PROXY protocol 头部中的客户端地址）
+	OnSNI     func(client net.Addr, sni string) error // 获得 SNI 域名后（匹配规则之前），返回错误时拒绝该连接
+	OnForward func(sni, target string)                // 连接目标成功后
+	OnClose   func(stats LogFields)                   // 转发结束后（stats.ConnSummary 为连接统计），只有连接了目标的连接
+
+	mu            sync.Mutex
+	started       bool
+	cancel        context.CancelFunc // 取消后关闭所有连接
+	listeners     []net.Listener
+	packetConns   []net.PacketConn // quic 模式的 UDP 监听
+	acceptLoops   []*acceptLoop    // 每个监听的接受连接线程（Healthy 检查）
+	metricsServer *http.Server
+	adminServer   *http.Server
+	pprofServer   *http.Server
+	closeOnce     sync.Once
+}
+
+// 创建 SNI Proxy（检查配置，配置无效时返回错误），之后调用 Start 开始监听
+func New(cfg *Config) (*Proxy, error) {
+	prepared, err := prepareConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{
+		conns:       &connRegistry{conns: make(map[string]*connInfo)},
+		clientConns: &ipConnCounter{counts: make(map[string]int)},
+		clientRate:  &rateLimiter{buckets: make(map[string]*tokenBucket)},
+		breakers:    &circuitBreakers{targets: make(map[string]*breakerState)},
+		summary:     &runSummary{start: time.Now(), snis: make(map[string]int64)},
+	}
+	p.tracer = newTraceExporter(p)
+	p.webhook = newWebhookNotifier(p)
+	p.std = &stdLogger{config: p.getConfig}
+	p.config.Store(prepared)
+	p.source = cfg
+	return p, nil
+}
+
+// 获取当前配置（每个连接开始时获取一次，重载配置不会影响已有连接）
+func (p *Proxy) getConfig() *Config {
+	return p.config.Load()
+}
+
+// 开始监听（监听失败时返回错误，不会留下已打开的监听），之后在后台接受连接
+// ctx 被取消时立即关闭所有连接并停止监听（与 Close 相同，但不等待已有连接结束）
+func (p *Proxy) Start(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.started {
+		return errors.New("SNI Proxy 已经启动")
+	}
+	p.started = true
+	cfg := p.getConfig()
+	p.printConfig(cfg)
+
+	ctx, p.cancel = context.WithCancel(ctx)             // 退出时取消，以关闭所有连接
+	lc := net.ListenConfig{Control: listenControl(cfg)} // 透明代理 tproxy 模式需要设置 IP_TRANSPARENT
+	var indexes []int                                   // 每个监听 socket 对应的监听配置（同一个监听的多个地址共用同一套规则）
+	maxConns := cfg.MaxConnections                      // 全局连接数限制（所有监听地址共用，包括 QUIC 会话，修改需要重启后才能生效）
+	limiter := newConnLimiter(maxConns)
+	metricMaxConnections.Set(float64(maxConns))
+	fail := func(format string, err error) error {
+		p.closeListeners()
+		p.cancel()
+		p.listeners, p.packetConns, p.acceptLoops, p.started = nil, nil, nil, false
+		return fmt.Errorf(format, err)
+	}
+	for i, l := range cfg.listeners {
+		for _, addr := range l.ListenAddr {
+			if l.Mode == listenModeQUIC {
+				conn, activated, err := listenUDP(ctx, &lc, cfg, addr)
+				if err != nil {
+					return fail("监听失败: %v", err)
+				}
+				localListeners.add(conn.LocalAddr())
+				p.serviceLogger(fmt.Sprintf("开始监听: %v（UDP, QUIC）%s", conn.LocalAddr(), activatedNote(activated)), LevelInfo)
+				p.packetConns = append(p.packetConns, conn)
+				loop := newAcceptLoop(conn.LocalAddr().String())
+				p.acceptLoops = append(p.acceptLoops, loop)
+				go (&quicProxy{proxy: p, conn: conn, index: i, limiter: limiter, loop: loop, sessions: make(map[string]*quicSession)}).serve(ctx)
+				continue
+			}
+			listener, activated, err := listenTCP(ctx, &lc, cfg, addr)
+			if err != nil {
+				return fail("监听失败: %v", err)
+			}
+			localListeners.add(listener.Addr())
+			p.serviceLogger(fmt.Sprintf("开始监听: %v%s", listener.Addr(), activatedNote(activated)), LevelInfo)
+			p.listeners = append(p.listeners, listener)
+			indexes = append(indexes, i)
+		}
+	}
+
+	if cfg.SocketActivation {
+		if n := unusedActivatedSockets(); n > 0 {
+			p.serviceLogger(fmt.Sprintf("systemd 传入的 %d 个 socket 与 listen_addr 中的地址都不相同, 未使用", n), LevelWarn)
+		}
+	}
+
+	if addr := cfg.MetricsAddr; addr != "" { // 启动 Prometheus 指标服务
+		var err error
+		if p.metricsServer, err = p.startMetricsServer(addr); err != nil {
+			return fail("指标服务监听失败: %v", err)
+		}
+	}
+	if addr := cfg.AdminAddr; addr != "" { // 启动管理 API
+		var err error
+		if p.adminServer, err = p.startAdminServer(addr); err != nil {
+			stopHTTPServer(p.metricsServer)
+			return fail("管理 API 监听失败: %v", err)
+		}
+	}
+	if addr := cfg.PprofAddr; addr != "" { // 启动 pprof 性能分析服务
+		var err error
+		if p.pprofServer, err = p.startPprofServer(addr); err != nil {
+			stopHTTPServer(p.metricsServer)
+			stopHTTPServer(p.adminServer)
+			return fail("pprof 服务监听失败: %v", err)
+		}
+	}
+
+	pool := newWorkerPool(cfg.NumWorkers, cfg.WorkerQueueSize, cfg.WorkerQueueFull) // 工作线程（修改需要重启后才能生效）
+	var accepting sync.WaitGroup
+	for i, listener := range p.listeners {
+		loop := newAcceptLoop(listener.Addr().String())
+		p.acceptLoops = append(p.acceptLoops, loop)
+		accepting.Add(1)
+		go func(listener net.Listener, index int) {
+			defer accepting.Done()
+			p.acceptConns(ctx, listener, index, limiter, pool, loop)
+		}(listener, indexes[i])
+	}
+	go func() { // 所有监听都停止接受连接后关闭工作线程队列
+		accepting.Wait()
+		pool.close()
+	}()
+	go func() { // ctx 被取消（包括调用 Close）时停止监听
+		<-ctx.Done()
+		p.Close()
+	}()
+	return nil
+}
+
+// 监听日志中 systemd 传入的 socket 的说明
+func activatedNote(activated bool) string {
+	if activated {
+		return "（systemd 传入）"
+	}
+	return ""
+}
+
+// 停止监听并关闭所有 QUIC 会话
+func (p *Proxy) closeListeners() {
+	for _, listener := range p.listeners { // 停止接受新连接
+		localListeners.remove(listener.Addr())
+		listener.Close()
+	}
+	for _, conn := range p.packetConns { // 关闭所有 QUIC 会话
+		localListeners.remove(conn.LocalAddr())
+		conn.Close()
+	}
+}
+
+// 停止监听，等待已有连接结束（最多 shutdown_timeout 秒，超时后强制关闭），可以多次调用
+func (p *Proxy) Close() error {
+	p.closeOnce.Do(func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if !p.started {
+			return
+		}
+		p.closeListeners()
+		if n := p.conns.count(); n > 0 {
+			timeout := time.Duration(p.getConfig().ShutdownTimeout) * time.Second
+			p.serviceLogger(fmt.Sprintf("等待 %d 个连接结束（最多 %v）...", n, timeout), LevelInfo)
+			drained, forced := p.conns.drain(timeout, p.cancel)
+			p.serviceLogger(fmt.Sprintf("已结束连接: %d 个自然结束, %d 个强制关闭", drained, forced), LevelInfo)
+		}
+		p.cancel()
+		stopHTTPServer(p.metricsServer)
+		stopHTTPServer(p.adminServer)
+		stopHTTPServer(p.pprofServer)
+		p.logSummary()
+		p.tracer.close() // 发送剩余的追踪数据
+		p.webhook.close()
+		serviceSyslog.close(syslogCloseTimeout) // 发送队列中剩余的日志（包括以上的退出日志）
+	})
+	return nil
+}
+
+// 重载配置（新配置无效时返回错误并继续使用旧配置），只影响之后的新连接
+func (p *Proxy) Reload(c *Config) error {
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+	if err := p.reload(c); err != nil {
+		p.webhook.notify(p.getConfig(), eventReload, "", fmt.Sprintf("重载配置失败: %v", err))
+		return err
+	}
+	p.serviceLogger("重载配置成功", LevelInfo)
+	p.webhook.notify(p.getConfig(), eventReload, "", "重载配置成功")
+	p.printConfig(p.getConfig())
+	return nil
+}
+
+// 检查并替换配置（调用方需持有 reloadMu）
+func (p *Proxy) reload(c *Config) error {
+	cfg, err := prepareConfig(c)
+	if err != nil {
+		return err
+	}
+	old := p.getConfig()
+	if cfg.listenAddrs() != old.listenAddrs() {
+		p.serviceLogger(fmt.Sprintf("监听地址 listen_addr 的修改（%s => %s）需要重启后才能生效", old.listenAddrs(), cfg.listenAddrs()), LevelWarn)
+		if len(cfg.listeners) != len(old.listeners) { // 监听数量变化时无法与已有的监听对应，继续使用旧的监听配置
+			cfg.listeners = old.listeners
+		}
+	}
+	if cfg.ReusePort != old.ReusePort || cfg.SocketActivation != old.SocketActivation {
+		p.serviceLogger("reuse_port、socket_activation 的修改需要重启后才能生效", LevelWarn)
+	}
+	if cfg.Transparent != old.Transparent {
+		p.serviceLogger(fmt.Sprintf("透明代理模式 transparent 的修改（%s => %s）需要重启后才能生效", old.Transparent, cfg.Transparent), LevelWarn)
+		cfg.Transparent = old.Transparent // 监听 socket 的选项无法修改，继续使用旧的模式
+	}
+	if cfg.MaxConnections != old.MaxConnections {
+		p.serviceLogger(fmt.Sprintf("总连接数上限 max_connections 的修改（%d => %d）需要重启后才能生效", old.MaxConnections, cfg.MaxConnections), LevelWarn)
+	}
+	if cfg.NumWorkers != old.NumWorkers || cfg.WorkerQueueSize != old.WorkerQueueSize || cfg.WorkerQueueFull != old.WorkerQueueFull {
+		p.serviceLogger("num_workers、worker_queue_size、worker_queue_full 的修改需要重启后才能生效", LevelWarn)
+	}
+	if cfg.MetricsAddr != old.MetricsAddr {
+		p.serviceLogger(fmt.Sprintf("指标服务地址 metrics_addr 的修改（%s => %s）需要重启后才能生效", old.MetricsAddr, cfg.MetricsAddr), LevelWarn)
+	}
+	if cfg.PprofAddr != old.PprofAddr {
+		p.serviceLogger(fmt.Sprintf("pprof 服务地址 pprof_addr 的修改（%s => %s）需要重启后才能生效", old.PprofAddr, cfg.PprofAddr), LevelWarn)
+	}
+	if cfg.AdminAddr != old.AdminAddr {
+		p.serviceLogger(fmt.Sprintf("管理 API 地址 admin_addr 的修改（%s => %s）需要重启后才能生效", old.AdminAddr, cfg.AdminAddr), LevelWarn)
+	}
+	if dnsSettingsChanged(old, cfg) { // 缓存的是旧的 DNS 设置的解析结果
+		resolverCache.clear()
+		p.serviceLogger("DNS 设置已修改, 已清空 DNS 缓存", LevelInfo)
+	}
+	p.config.Store(cfg)
+	p.source = c
+	return nil
+}
+
+// 重新打开日志文件（配合外部 logrotate 等工具使用）
+func (p *Proxy) ReopenLogFile() error {
+	return serviceLogFile.reopen()
+}
+
+// 接受监听地址上的连接，检查限制后交给 serve 处理（index 为该监听在 listeners 中的位置，loop 记录运行状态）
+func (p *Proxy) acceptConns(ctx context.Context, listener net.Listener, index int, limiter *connLimiter, pool *workerPool, loop *acceptLoop) {
+	defer listener.Close()
+	defer loop.stopped.Store(true)
+	for {
+		loop.idle()
+		connection, err := listener.Accept()
+		loop.busy()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) { // 退出时关闭了监听，停止接受新连接
+				return
+			}
+			p.serviceLogger(fmt.Sprintf("接受连接请求时出错: %v", err), LevelError)
+			continue
+		}
+		metricConnectionsTotal.Inc()
+		p.summary.accepted()
+		p.statsd(p.getConfig(), statsdCount("connections", 1))
+		raddr := connection.RemoteAddr().(*net.TCPAddr)
+		fields := LogFields{ID: newConnID(), Client: raddr.String()} // 该连接的所有日志都带有同一个连接 ID
+		p.serviceLoggerFields("连接来自: "+raddr.String(), LevelDebug, fields)
+		clientIP := raddr.IP.String()
+		if cfg := p.getConfig(); !p.clientRate.allow(clientIP, cfg.ConnRatePerIP, cfg.ConnBurstPerIP) { // 该 IP 新建连接过于频繁
+			metricRejectedConnections.WithLabelValues("rate_limit").Inc()
+			p.serviceLoggerFields(fmt.Sprintf("拒绝客户端 %s 的连接: 新建连接速率超过限制 %v 个/秒", clientIP, cfg.ConnRatePerIP), LevelWarn, fields)
+			connection.Close()
+			continue
+		}
+		if limit := p.getConfig().MaxConnsPerIP; !p.clientConns.acquire(clientIP, limit) { // 该 IP 的连接数已达到 max_conns_per_ip
+			metricRejectedConnections.WithLabelValues("max_conns_per_ip").Inc()
+			p.serviceLoggerFields(fmt.Sprintf("拒绝客户端 %s 的连接: 连接数已达到上限 %d", clientIP, limit), LevelWarn, fields)
+			connection.Close()
+			continue
+		}
+		// 总连接数已达到 max_connections 时最多等待 max_connections_wait 秒（新连接会在此排队），仍未空出名额则关闭新连接
+		loop.idle()
+		acquired := limiter.acquire(time.Duration(p.getConfig().MaxConnectionsWait) * time.Second)
+		loop.busy()
+		if !acquired {
+			p.clientConns.release(clientIP)
+			metricRejectedConnections.WithLabelValues("max_connections").Inc()
+			p.serviceLoggerFields(fmt.Sprintf("拒绝客户端 %s 的连接: 总连接数已达到上限 %d（当前 %d）", clientIP, limiter.limit(), limiter.count()), LevelWarn, fields)
+			connection.Close()
+			continue
+		}
+		p.conns.add(fields)
+		p.summary.enter()
+		release := func() {
+			p.summary.leave()
+			limiter.release()
+			p.clientConns.release(clientIP)
+		}
+		// 有新连接进来，启动一个新线程处理（配置了 num_workers 时交给工作线程处理）
+		loop.idle() // 工作线程队列已满时可能等待（worker_queue_full: block）
+		submitted := pool.submit(ctx, func() { defer release(); p.serve(ctx, connection, index, fields) })
+		loop.busy()
+		if !submitted {
+			if ctx.Err() == nil { // 退出时放弃的连接不需要记录
+				metricRejectedConnections.WithLabelValues("worker_queue_full").Inc()
+				p.serviceLoggerFields(fmt.Sprintf("拒绝客户端 %s 的连接: 工作线程队列已满", clientIP), LevelWarn, fields)
+			}
+			connection.Close()
+			p.conns.remove(fields.ID)
+			release()
+		}
+	}
+}
+
+// 处理新连接（index 为接受该连接的监听在 listeners 中的位置）
+func (p *Proxy) serve(ctx context.Context, c net.Conn, index int, fields LogFields) {
+	defer p.conns.remove(fields.ID)
+	defer c.Close()
+	defer closeOnDone(ctx, c)() // 退出时关闭连接
+	metricActiveConnections.Inc()
+	defer metricActiveConnections.Dec()
+	cfg := p.getConfig() // 本连接使用的配置（重载配置不影响已有连接）
+	listener := cfg.listeners[index]
+	raddr := fields.Client
+	ctx, trace := p.startTrace(ctx, cfg, fields)
+	defer trace.finish()
+	setTCPOptions(c, cfg)
+	p.logSocketBuffers(c, cfg, "客户端", fields)
+	if cfg.DSCPClient { // 目标连接的 DSCP 在连接前由 outboundControl 设置
+		if err := setConnDSCP(c, cfg.DSCP); err != nil {
+			p.serviceLoggerFields(fmt.Sprintf("设置客户端连接的 DSCP 时出错: %v", err), LevelDebug, fields)
+		}
+	}
+
+	// 设置读取 PROXY protocol 头部和 ClientHello 的超时（开始转发后会清除）
+	c.SetDeadline(time.Now().Add(time.Duration(cfg.HandshakeTimeout) * time.Second))
+
+	forwardPort := listener.ForwardPort // 转发至的目标端口（透明代理模式下为原始目标端口）
+	var origDst *net.TCPAddr
+	if cfg.Transparent != "" {
+		addr, err := originalDst(c, cfg.Transparent)
+		if err != nil { // 例如客户端直接连接了本服务，没有经过 iptables
+			p.serviceLoggerFields(fmt.Sprintf("获取原始目标地址失败, 使用 forward_port: %v", err), LevelDebug, fields)
+		} else {
+			origDst, forwardPort = addr, addr.Port
+		}
+	}
+
+	var rest []byte              // PROXY protocol 头部之后已经读到的数据
+	if cfg.AcceptProxyProtocol { // 本服务位于负载均衡之后时，从 PROXY protocol 头部获得客户端的真实地址
+		hdr, data, err := readProxyHeader(c)
+		if err != nil {
+			switch {
+			case isClosedConnError(err): // 例如负载均衡的 TCP 健康检查
+			case isTimeoutError(err):
+				p.serviceLoggerFields(fmt.Sprintf("读取 PROXY protocol 头部超时: %v", err), LevelDebug, fields)
+			default: // 声称使用 PROXY protocol 但头部无效（或根本没有发送头部）
+				p.serviceLoggerFields(fmt.Sprintf("拒绝连接: PROXY protocol 头部无效: %v", err), LevelWarn, fields)
+				rejectConn(c, cfg, listener, alertNone)
+			}
+			return
+		}
+		if hdr.src != nil {
+			c = &proxiedConn{Conn: c, remote: hdr.src, local: hdr.dst}
+			raddr = hdr.src.String()
+			fields.Client = raddr
+			p.conns.update(fields)
+			trace.attr("client.address", raddr)
+		}
+		rest = data
+	}
+
+	p.onAccept(c.RemoteAddr())
+	if clientIP := c.RemoteAddr().(*net.TCPAddr).IP; !clientAllowed(clientIP, cfg) { // 不在 allowed_clients 中的客户端直接关闭连接
+		metricRejectedConnections.WithLabelValues("allowed_clients").Inc()
+		p.serviceLoggerFields(fmt.Sprintf("拒绝客户端 %s 的连接: 不在 allowed_clients 中", clientIP), LevelWarn, fields)
+		rejectConn(c, cfg, listener, alertNone)
+		return
+	}
+	if reason := checkSchedule(cfg); reason != "" { // 不在允许转发的时间段内（schedule）
+		metricRejectedConnections.WithLabelValues("schedule").Inc()
+		p.serviceLoggerFields(fmt.Sprintf("拒绝客户端 %s 的连接: %s", raddr, reason), LevelWarn, fields)
+		rejectConn(c, cfg, listener, alertNone)
+		return
+	}
+	country, reason := checkCountry(c.RemoteAddr().(*net.TCPAddr).IP, cfg)
+	if fields.Country = country; reason != "" { // 根据 GeoIP 数据库中客户端所在的国家或地区拒绝连接
+		metricRejectedConnections.WithLabelValues("geoip").Inc()
+		p.serviceLoggerFields(fmt.Sprintf("拒绝客户端 %s 的连接: %s", raddr, reason), LevelWarn, fields)
+		rejectConn(c, cfg, listener, alertNone)
+		return
+	}
+
+	// 读入新连接的内容（完整的 ClientHello，http 模式下为 HTTP 请求头），缓冲区在连接结束后才放回（payload 在转发时仍在使用）
+	readPool := readBufPool(cfg.ReadBufferSize)
+	readBuf := readPool.get()
+	defer readPool.put(readBuf)
+	readRequest := readClientHello
+	if listener.Mode == listenModeHTTP {
+		readRequest = readHTTPHeader
+	}
+	parse := trace.phase("read_client_hello", spanKindInternal) // 读取并解析 ClientHello（http 模式下为 HTTP 请求头）
+	payload, err := readRequest(io.MultiReader(bytes.NewReader(rest), c), *readBuf)
+	if err := clientHelloCapture.write(cfg, c.RemoteAddr(), c.LocalAddr(), payload); err != nil { // 调试时保存读到的原始数据（包括读取出错时已读到的部分）
+		p.serviceLoggerFields(fmt.Sprintf("写入抓包文件 %s 时出错: %v", cfg.CaptureFile, err), LevelWarn, fields)
+	}
+	if err != nil && !errors.Is(err, io.EOF) { // EOF 时继续尝试解析已读到的内容
+		parse.finish(err)
+		switch {
+		case errors.Is(err, net.ErrClosed): // 退出时关闭了连接
+		case isTimeoutError(err):
+			p.serviceLoggerFields(fmt.Sprintf("读取连接请求超时: %v", err), LevelDebug, fields)
+		case errors.Is(err, errNotTLS): // 不尝试解析 SNI 域名，直接关闭连接（对方不是 TLS 客户端，不发送 TLS alert）
+			metricRejectedConnections.WithLabelValues("non_tls").Inc()
+			p.serviceLoggerFields(fmt.Sprintf("拒绝客户端 %s 的连接: %v", raddr, err), LevelInfo, fields)
+			rejectConn(c, cfg, listener, alertNone)
+		default:
+			p.serviceLoggerFields(fmt.Sprintf("读取连接请求时出错: %v", err), LevelError, fields)
+		}
+		return
+	}
+
+	c.SetDeadline(time.Time{}) // 清除超时，之后由空闲超时 idle_timeout 控制
+
+	var ServerName string
+	if listener.Mode == listenModeHTTP { // http 模式下使用 Host 头部中的域名，之后与 SNI 域名一样匹配规则
+		if ServerName, err = parseHTTPHost(payload); err != nil {
+			parse.finish(err)
+			metricSNIParseFailures.Inc()
+			p.serviceLoggerFields(fmt.Sprintf("解析 HTTP 请求失败: %v", err), LevelDebug, fields)
+			return
+		}
+		parse.finish(nil)
+	} else {
+		hello, err := parseClientHello(payload) // 解析 ClientHello，获取 SNI 域名等信息
+		if err != nil {
+			parse.finish(err)
+			metricSNIParseFailures.Inc()
+			p.serviceLoggerFields(fmt.Sprintf("解析 ClientHello 失败: %v", err), LevelDebug, fields)
+			return
+		}
+		ServerName = hello.serverName
+		fields.ALPN = hello.alpnProtocols
+		fields.JA3 = ja3Fingerprint(hello)
+		parse.attr("tls.client.ja3", fields.JA3)
+		parse.finish(nil)
+		if reason := checkJA3(fields.JA3, cfg); reason != "" { // 根据 TLS 指纹拒绝已知的扫描器、机器人等客户端（无论其 SNI 域名是什么）
+			metricRejectedConnections.WithLabelValues("ja3").Inc()
+			p.serviceLoggerFields(fmt.Sprintf("拒绝客户端 %s 的连接: JA3 指纹 %s %s", raddr, fields.JA3, reason), LevelWarn, fields)
+			rejectConn(c, cfg, listener, alertAccessDenied)
+			return
+		}
+		metricALPN.WithLabelValues(alpnLabel(hello.alpnProtocols)).Inc()
+	}
+
+	if ServerName == "" {
+		metricSNIParseFailures.Inc()
+		if cfg.defaultTarget != "" { // 配置了 default_upstream 时转发至默认目标（例如不发送 SNI 的旧客户端、直接通过 IP 访问）
+			fields.Target = cfg.defaultTarget
+			p.serviceLoggerFields(fmt.Sprintf("未找到 SNI 域名, 转发至默认目标: %s", fields.Target), LevelInfo, fields)
+			p.forward(ctx, c, payload, fields, cfg, nil, []string{cfg.defaultTarget}, false)
+			return
+		}
+		if origDst != nil { // 透明代理模式下转发至原始目标地址
+			fields.Target = origDst.String()
+			p.serviceLoggerFields(fmt.Sprintf("未找到 SNI 域名, 转发至原始目标: %s", fields.Target), LevelInfo, fields)
+			p.forward(ctx, c, payload, fields, cfg, nil, []string{fields.Target}, false)
+			return
+		}
+		p.serviceLoggerFields("未找到 SNI 域名, 忽略...", LevelDebug, fields)
+		rejectConn(c, cfg, listener, alertUnrecognizedName)
+		return
+	}
+	name, err := normalizeServerName(ServerName) // 域名不区分大小写，国际化域名统一为 punycode 形式
+	if err != nil {
+		metricRejectedConnections.WithLabelValues("invalid_sni").Inc()
+		p.serviceLoggerFields(fmt.Sprintf("拒绝客户端 %s 的连接: SNI 域名 %s 无效: %v", raddr, quoteInvalidName(ServerName), err), LevelDebug, fields)
+		rejectConn(c, cfg, listener, alertUnrecognizedName)
+		return
+	}
+	ServerName = name
+	fields.SNI = ServerName
+	p.summary.sni(ServerName)
+	trace.attr("tls.client.server_name", ServerName)
+	p.conns.update(fields)
+	if err := p.onSNI(c.RemoteAddr(), ServerName); err != nil {
+		p.serviceLoggerFields(fmt.Sprintf("拒绝客户端 %s 的连接: %v", raddr, err), LevelWarn, fields)
+		rejectConn(c, cfg, listener, alertAccessDenied)
+		return
+	}
+
+	if rule := firstMatch(cfg.blockedHosts, ServerName); rule != nil { // blocked_hosts 优先于 allow_all_hosts 和 rules
+		metricRejectedConnections.WithLabelValues("blocked_hosts").Inc()
+		message := fmt.Sprintf("拒绝客户端 %s 的连接: SNI 域名 %s 命中屏蔽规则 %s", raddr, ServerName, rule.raw)
+		p.serviceLoggerFields(message, LevelWarn, fields)
+		p.webhook.notify(cfg, eventBlocked, ServerName, message)
+		rejectConn(c, cfg, listener, alertAccessDenied)
+		return
+	}
+
+	// 选择转发目标：country_rules 优先于该监听的规则（包括 allow_all_hosts），每组规则都只使用第一个匹配的规则
+	// 所有情况都在最后只调用一次 forward（同一个连接只能转发一次，多次转发会使多个目标同时读写该连接）
+	var targets []string
+	fromSNI, note := true, ""
+	rule := firstMatch(cfg.countryRules[country], ServerName)
+	switch {
+	case rule != nil:
+		note = fmt.Sprintf("（%s 的规则 %s）", country, rule.raw)
+	case listener.AllowAllHosts: // 如果 allow_all_hosts 为 true 则代表无需判断 SNI 域名
+		metricRuleMatches.WithLabelValues("*").Inc()
+		targets = []string{fmt.Sprintf("%s:%d", ServerName, forwardPort)}
+	default:
+		if rule = firstMatch(listener.rules, ServerName); rule == nil { // 按顺序匹配 rules 中的白名单域名
+			rejectConn(c, cfg, listener, alertUnrecognizedName)
+			return
+		}
+	}
+	if rule != nil {
+		metricRuleMatches.WithLabelValues(rule.raw).Inc()
+		targets = rule.targetsFor(ServerName, forwardPort, cfg.LoadBalance) // 规则指定了转发目标时转发至该目标，否则转发至 SNI 域名自身
+		fromSNI = len(rule.targets) == 0
+	}
+	fields.Target = strings.Join(targets, ",")
+	p.serviceLoggerFields(fmt.Sprintf("转发目标: %s%s", fields.Target, note), LevelInfo, fields)
+	p.forward(ctx, c, payload, fields, cfg, rule, targets, fromSNI)
+}
+
+// 转发连接（依次尝试 targets 中的目标，直到连接成功；fromSNI 表示目标来自 SNI 域名；rule 为匹配的规则，没有时为空）
+func (p *Proxy) forward(ctx context.Context, src net.Conn, firstPayload []byte, fields LogFields, cfg *Config, rule *forwardRule, targets []string, fromSNI bool) {
+	start := time.Now()
+	raddr := fields.Client
+	trace := traceFrom(ctx)
+	dial := trace.phase("dial", spanKindClient)
+	dst, dstAddr, err := p.dialTargets(ctx, cfg, targets, fromSNI, fields)
+	fields.Target = dstAddr // 连接成功的目标
+	dial.attr("sniproxy.target", dstAddr)
+	dial.finish(err)
+	trace.attr("sniproxy.target", dstAddr)
+	if err != nil {
+		if errors.Is(err, errPrivateTarget) { // 可能是利用代理访问内网的尝试
+			p.serviceLoggerFields(fmt.Sprintf("已阻止客户端 %s 连接内网目标: %v", raddr, err), LevelWarn, fields)
+			return
+		}
+		if errors.Is(err, errSelfTarget) { // 转发至自身会无限循环，可能是恶意构造的 SNI 域名
+			p.serviceLoggerFields(fmt.Sprintf("已拒绝客户端 %s 的连接, 转发目标指向本服务自身: %v", raddr, err), LevelError, fields)
+			return
+		}
+		if errors.Is(err, errCircuitOpen) && len(targets) == 1 { // 目标熔断中，没有尝试连接
+			p.serviceLoggerFields(fmt.Sprintf("拒绝客户端 %s 的连接: 目标 %s 熔断中", raddr, dstAddr), LevelWarn, fields)
+			return
+		}
+		metricDialFailures.Inc()
+		p.statsd(cfg, statsdCount("dial_failures", 1))
+		p.webhook.notify(cfg, eventDialFailure, dstAddr, fmt.Sprintf("客户端 %s 连接目标 %s 失败: %v", raddr, dstAddr, err))
+		if isSocksAuthError(err) {
+			p.serviceLoggerFields(fmt.Sprintf("Socks5 代理 %s 认证失败（请检查 socks_user 和 socks_pass）: %v", cfg.SocksAddr, err), LevelError, fields)
+		} else if isTimeoutError(err) { // 目标无响应（例如被防火墙丢弃），与连接被拒绝（目标在线但端口未监听）分开记录
+			p.serviceLoggerFields(fmt.Sprintf("连接目标 %s 超时（dial_timeout %d 秒）: %v", dstAddr, cfg.DialTimeout, err), LevelError, fields)
+		} else if errors.Is(err, syscall.ECONNREFUSED) {
+			p.serviceLoggerFields(fmt.Sprintf("连接目标 %s 被拒绝: %v", dstAddr, err), LevelError, fields)
+		} else if cfg.EnableSocks {
+			p.serviceLoggerFields(fmt.Sprintf("通过 Socks5 代理 %s 连接目标 %s 时出错: %v", cfg.SocksAddr, dstAddr, err), LevelError, fields)
+		} else {
+			p.serviceLoggerFields(fmt.Sprintf("连接目标 %s 时出错: %v", dstAddr, err), LevelError, fields)
+		}
+		return
+	}
+	defer dst.Close()
+	defer closeOnDone(ctx, dst)() // 退出时关闭目标连接
+	relay := trace.phase("forward", spanKindInternal)
+	setTCPOptions(dst, cfg)
+	p.logSocketBuffers(dst, cfg, "目标", fields)
+	p.onForward(fields.SNI, dstAddr)
+	info := p.conns.update(fields)
+	if info == nil { // 不会出现（所有 TCP 连接都已登记），避免空指针
+		info = &connInfo{}
+	}
+
+	if cfg.SendProxyProtocol != "" { // 在 ClientHello 之前发送 PROXY protocol 头部，让目标获得客户端的真实地址
+		header, err := buildProxyHeader(cfg.SendProxyProtocol, src.RemoteAddr(), src.LocalAddr())
+		if err == nil {
+			_, err = dst.Write(header)
+		}
+		if err != nil {
+			p.serviceLoggerFields(fmt.Sprintf("向目标 %s 发送 PROXY protocol 头部时出错: %v", dstAddr, err), LevelError, fields)
+			return
+		}
+	}
+
+	mirror := p.startMirror(ctx, cfg, fields) // 配置了 mirror_addr 时把上行数据（包括 ClientHello）复制一份发送到镜像地址
+	defer mirror.close()
+
+	n, err := dst.Write(firstPayload)
+	info.bytesUp.Add(int64(n))
+	metricBytesForwarded.WithLabelValues("upstream").Add(float64(n))
+	mirror.write(firstPayload[:n])
+	if err != nil {
+		p.serviceLoggerFields(fmt.Sprintf("向目标 %s 发送初始数据时出错: %v", dstAddr, err), LevelError, fields)
+		return
+	}
+
+	// 超过 idle_timeout 两个方向都没有数据传输时视为空闲，关闭连接（持续传输的连接不受影响）
+	idleTimeout := time.Duration(cfg.IdleTimeout) * time.Second
+	var probe func() (time.Duration, bool)
+	if srcTCP, dstTCP, ok := spliceConns(src, dst); ok { // splice 转发时从内核获取连接的活动时间、已转发的字节数
+		probe = lastDataRecvProbe(srcTCP, dstTCP)
+		p.conns.setProbe(info, bytesSentProbe(srcTCP, dstTCP))
+	}
+	throttleCtx, stopThrottle := context.WithCancel(ctx) // 关闭连接时取消，停止等待带宽限制
+	defer stopThrottle()
+	closeConns := func() {
+		stopThrottle()
+		src.Close()
+		dst.Close()
+	}
+	idle := newIdleTracker(idleTimeout, probe, func() {
+		p.serviceLoggerFields(fmt.Sprintf("连接空闲超过 %v, 关闭连接", idleTimeout), LevelDebug, fields)
+		closeConns()
+	})
+	defer idle.stop()
+
+	// 配置了 max_bytes_per_conn 时两个方向合计超过配额后关闭连接（此时不使用 splice）
+	quota := newByteQuota(cfg.MaxBytesPerConn, int64(n), func() {
+		p.serviceLoggerFields(fmt.Sprintf("连接流量超过 max_bytes_per_conn（%d 字节）, 关闭连接", cfg.MaxBytesPerConn), LevelWarn, fields)
+		closeConns()
+	})
+	// 配置了 max_conn_lifetime 时连接时长（从开始连接目标时计算）超过上限后关闭连接，无论是否仍在传输数据
+	if cfg.MaxConnLifetime > 0 {
+		lifetime := time.Duration(cfg.MaxConnLifetime) * time.Second
+		timer := time.AfterFunc(lifetime-time.Since(start), func() {
+			p.serviceLoggerFields(fmt.Sprintf("连接时长超过 max_conn_lifetime（%v）, 关闭连接", lifetime), LevelInfo, fields)
+			closeConns()
+		})
+		defer timer.Stop()
+	}
+	// 配置了带宽限制时两个方向都计入限制（此时也不使用 splice）
+	var ruleLimiter *bandwidthLimiter
+	if rule != nil {
+		ruleLimiter = rule.limiter
+	}
+	throttledSrc := throttle(throttleCtx, mirror.wrap(quota.wrap(src)), cfg.globalLimiter, ruleLimiter)
+	throttledDst := throttle(throttleCtx, quota.wrap(dst), cfg.globalLimiter, ruleLimiter)
+
+	// 并发地将数据从源连接传输到目标连接
+	// 一个方向正常结束时只关闭接收方的写入（半关闭），另一个方向可以继续传输剩余数据，两个方向都结束后才完全关闭
+	upstream := make(chan int64, 1)
+	go func() {
+		n, err := copyData(dst, throttledSrc, idle, cfg.CopyBufferSize, &info.bytesUp)
+		metricBytesForwarded.WithLabelValues("upstream").Add(float64(n))
+		p.logCopyError(fmt.Sprintf("将数据从源 %s 复制到目标 %s", raddr, dstAddr), err, fields)
+		finishCopy(dst, src, err)
+		upstream <- n
+	}()
+
+	written, err := copyData(src, throttledDst, idle, cfg.CopyBufferSize, &info.bytesDown)
+	metricBytesForwarded.WithLabelValues("downstream").Add(float64(written))
+	p.logCopyError(fmt.Sprintf("将数据从目标 %s 复制到源 %s", dstAddr, raddr), err, fields)
+	finishCopy(src, dst, err)
+	upstreamBytes := <-upstream
+	src.Close()
+	dst.Close()
+
+	// 输出连接统计（上行包括 ClientHello，时长从连接目标开始计算）
+	summary := &ConnSummary{BytesUp: int64(n) + upstreamBytes, BytesDown: written, DurationMs: time.Since(start).Milliseconds()}
+	fields.ConnSummary = summary
+	p.summary.forwarded(summary.BytesUp, summary.BytesDown)
+	relay.finish(nil)
+	trace.attr("sniproxy.bytes_up", summary.BytesUp)
+	trace.attr("sniproxy.bytes_down", summary.BytesDown)
+	p.statsd(cfg, statsdCount("bytes.upstream", summary.BytesUp), statsdCount("bytes.downstream", summary.BytesDown), statsdTiming("connection.duration", time.Since(start)))
+	alpn := strings.Join(fields.ALPN, ",")
+	if alpn == "" {
+		alpn = "无"
+	}
+	ja3 := fields.JA3
+	if ja3 == "" { // http 模式下没有 JA3 指纹
+		ja3 = "无"
+	}
+	p.serviceLoggerFields(fmt.Sprintf("连接结束: 客户端 %s, SNI 域名 %s, ALPN %s, JA3 %s, 目标 %s, 上行 %d 字节, 下行 %d 字节, 时长 %v",
+		raddr, fields.SNI, alpn, ja3, dstAddr, summary.BytesUp, summary.BytesDown, time.Duration(summary.DurationMs)*time.Millisecond), LevelInfo, fields)
+	p.onClose(fields)
+}
+
+// 记录转发数据时的错误（连接正常结束、一方关闭连接导致的错误不记录，超时仅在调试模式下记录）
+func (p *Proxy) logCopyError(action string, err error, fields LogFields) {
+	switch {
+	case err == nil, isClosedConnError(err), errors.Is(err, errByteQuotaExceeded):
+	case isTimeoutError(err):
+		p.serviceLoggerFields(fmt.Sprintf("%s 时超时: %v", action, err), LevelDebug, fields)
+	default:
+		p.serviceLoggerFields(fmt.Sprintf("%s 时出错: %v", action, err), LevelError, fields)
+	}
+}
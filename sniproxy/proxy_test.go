@@ -0,0 +1,179 @@
+package sniproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 生成发往 serverName 的 ClientHello（完整的 TLS 记录）
+func testClientHello(t testing.TB, serverName string) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+		client.Close()
+	}()
+	server.SetDeadline(time.Now().Add(5 * time.Second))
+	hdr := make([]byte, recordHeaderLen)
+	if _, err := io.ReadFull(server, hdr); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, int(hdr[3])<<8|int(hdr[4]))
+	if _, err := io.ReadFull(server, body); err != nil {
+		t.Fatal(err)
+	}
+	return append(hdr, body...)
+}
+
+// 本机上的测试目标：每个连接读取 ClientHello 后回复自身的名称再关闭连接
+type testUpstream struct {
+	name     string
+	listener net.Listener
+	accepts  atomic.Int32
+	wg       sync.WaitGroup
+}
+
+func newTestUpstream(t testing.TB, name string) *testUpstream {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &testUpstream{name: name, listener: l}
+	u.wg.Add(1)
+	go func() {
+		defer u.wg.Done()
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			u.accepts.Add(1)
+			go func() {
+				defer c.Close()
+				c.SetDeadline(time.Now().Add(5 * time.Second))
+				buf := make([]byte, 16*1024)
+				c.Read(buf)
+				io.WriteString(c, u.name)
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		l.Close()
+		u.wg.Wait()
+	})
+	return u
+}
+
+func (u *testUpstream) addr() string {
+	return u.listener.Addr().String()
+}
+
+// 丢弃所有日志
+type discardLogger struct{}
+
+func (discardLogger) Log(string, Level, LogFields) {}
+
+// 使用 YAML 配置创建测试用的 Proxy（不调用 Start，由测试直接调用 serve）
+func newTestProxy(t testing.TB, config string) *Proxy {
+	t.Helper()
+	cfg, err := ParseConfig([]byte(config), "test.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Logger = discardLogger{}
+	return p
+}
+
+// 在本机 TCP 连接上交给 serve 处理，发送 payload 后返回收到的全部回复（serve 返回后才返回）
+func serveTestConn(t testing.TB, p *Proxy, payload []byte) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := LogFields{ID: newConnID(), Client: server.RemoteAddr().String()}
+	p.conns.add(fields)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.serve(context.Background(), server, 0, fields)
+	}()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	reply, _ := io.ReadAll(client)
+	client.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve 没有返回")
+	}
+	return string(reply)
+}
+
+// 多个规则（com 和 example.com）都匹配时，连接只转发一次（只连接第一个匹配的规则的目标）
+func TestServeForwardsOnceWithOverlappingRules(t *testing.T) {
+	broad := newTestUpstream(t, "broad")
+	specific := newTestUpstream(t, "specific")
+	tests := []struct {
+		rules []string
+		want  *testUpstream
+	}{
+		{[]string{"com=" + broad.addr(), "example.com=" + specific.addr()}, broad},
+		{[]string{"example.com=" + specific.addr(), "com=" + broad.addr()}, specific},
+	}
+	hello := testClientHello(t, "example.com")
+	for _, tt := range tests {
+		broad.accepts.Store(0)
+		specific.accepts.Store(0)
+		config := "listen_addr: 127.0.0.1:0\nrules:\n"
+		for _, rule := range tt.rules {
+			config += fmt.Sprintf("  - %q\n", rule)
+		}
+		p := newTestProxy(t, config)
+		var forwards atomic.Int32
+		var target atomic.Value
+		p.OnForward = func(sni, t string) {
+			forwards.Add(1)
+			target.Store(t)
+		}
+
+		if reply := serveTestConn(t, p, hello); reply != tt.want.name {
+			t.Errorf("规则 %q: 收到的回复为 %q, 期望 %q", tt.rules, reply, tt.want.name)
+		}
+		if n := forwards.Load(); n != 1 {
+			t.Errorf("规则 %q: 连接了 %d 次目标, 期望 1 次", tt.rules, n)
+		}
+		if got, _ := target.Load().(string); got != tt.want.addr() {
+			t.Errorf("规则 %q: 连接的目标为 %q, 期望 %q", tt.rules, got, tt.want.addr())
+		}
+		if n := broad.accepts.Load() + specific.accepts.Load(); n != 1 {
+			t.Errorf("规则 %q: 目标共接受了 %d 个连接, 期望 1 个", tt.rules, n)
+		}
+	}
+}
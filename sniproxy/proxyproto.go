@@ -0,0 +1,188 @@
+package sniproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// PROXY protocol 版本（send_proxy_protocol）
+const (
+	proxyProtocolV1 = "v1" // 文本格式
+	proxyProtocolV2 = "v2" // 二进制格式
+)
+
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n") // v2 头部的固定签名（12 字节）
+
+// 生成 PROXY protocol 头部（src 为客户端地址，dst 为本服务接受该连接的本端地址）
+func buildProxyHeader(version string, src, dst net.Addr) ([]byte, error) {
+	srcAddr, ok1 := src.(*net.TCPAddr)
+	dstAddr, ok2 := dst.(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		return nil, errors.New("不是 TCP 连接")
+	}
+	srcIP, dstIP := srcAddr.IP.To4(), dstAddr.IP.To4()
+	ipv4 := srcIP != nil && dstIP != nil
+	if !ipv4 { // 两端地址类型不同时都使用 IPv6 格式（IPv4 转为 IPv4 映射地址）
+		srcIP, dstIP = srcAddr.IP.To16(), dstAddr.IP.To16()
+	}
+
+	if version == proxyProtocolV1 {
+		proto := "TCP6"
+		if ipv4 {
+			proto = "TCP4"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, srcIP, dstIP, srcAddr.Port, dstAddr.Port)), nil
+	}
+
+	family, addrLen := byte(0x21), 36 // TCP over IPv6：源 IP、目标 IP 各 16 字节，源端口、目标端口各 2 字节
+	if ipv4 {
+		family, addrLen = 0x11, 12 // TCP over IPv4
+	}
+	header := make([]byte, 0, 16+addrLen)
+	header = append(header, proxyProtocolV2Signature...)
+	header = append(header, 0x21, family) // 版本 2、命令 PROXY
+	header = binary.BigEndian.AppendUint16(header, uint16(addrLen))
+	header = append(header, srcIP...)
+	header = append(header, dstIP...)
+	header = binary.BigEndian.AppendUint16(header, uint16(srcAddr.Port))
+	header = binary.BigEndian.AppendUint16(header, uint16(dstAddr.Port))
+	return header, nil
+}
+
+const maxProxyHeaderV1Len = 107 // v1 头部的最大长度（包括结尾的 \r\n）
+
+var errNotProxyHeader = errors.New("不是 PROXY protocol 头部")
+
+// 通过 PROXY protocol 获得的地址（头部为 v1 UNKNOWN、v2 LOCAL 或不支持的地址类型时为空）
+type proxyHeader struct {
+	src, dst *net.TCPAddr
+}
+
+// 读取并解析连接开头的 PROXY protocol v1/v2 头部，返回头部之后已经读到的数据（即 ClientHello 的开头）
+func readProxyHeader(r io.Reader) (*proxyHeader, []byte, error) {
+	buf := make([]byte, 0, 256)
+	fill := func(n int) error { // 至少读到 n 字节
+		for len(buf) < n {
+			if cap(buf) < n {
+				buf = append(make([]byte, 0, n), buf...)
+			}
+			m, err := r.Read(buf[len(buf):cap(buf)])
+			buf = buf[:len(buf)+m]
+			if err != nil && len(buf) < n {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := fill(len(proxyProtocolV2Signature)); err != nil { // 最短的 v1 头部 "PROXY UNKNOWN\r\n" 也不少于 12 字节
+		return nil, nil, err
+	}
+
+	if bytes.HasPrefix(buf, proxyProtocolV2Signature) {
+		if err := fill(16); err != nil {
+			return nil, nil, err
+		}
+		length := 16 + int(binary.BigEndian.Uint16(buf[14:16]))
+		if err := fill(length); err != nil {
+			return nil, nil, err
+		}
+		hdr, err := parseProxyHeaderV2(buf[:length])
+		return hdr, buf[length:], err
+	}
+
+	if !bytes.HasPrefix(buf, []byte("PROXY ")) {
+		return nil, nil, errNotProxyHeader
+	}
+	for {
+		if i := bytes.Index(buf, []byte("\r\n")); i >= 0 && i+2 <= maxProxyHeaderV1Len {
+			hdr, err := parseProxyHeaderV1(string(buf[:i]))
+			return hdr, buf[i+2:], err
+		}
+		if len(buf) >= maxProxyHeaderV1Len {
+			return nil, nil, errors.New("PROXY protocol v1 头部过长")
+		}
+		if err := fill(len(buf) + 1); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// 解析 v1 头部（不包括结尾的 \r\n），例如 "PROXY TCP4 1.2.3.4 5.6.7.8 12345 443"
+func parseProxyHeaderV1(line string) (*proxyHeader, error) {
+	parts := strings.Split(line, " ")
+	if len(parts) >= 2 && parts[1] == "UNKNOWN" {
+		return &proxyHeader{}, nil
+	}
+	if len(parts) != 6 || (parts[1] != "TCP4" && parts[1] != "TCP6") {
+		return nil, fmt.Errorf("无效的 PROXY protocol v1 头部: %q", line)
+	}
+	src, err1 := parseProxyAddr(parts[2], parts[4], parts[1] == "TCP4")
+	dst, err2 := parseProxyAddr(parts[3], parts[5], parts[1] == "TCP4")
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("无效的 PROXY protocol v1 头部: %q", line)
+	}
+	return &proxyHeader{src: src, dst: dst}, nil
+}
+
+// 解析 v1 头部中的 IP 和端口
+func parseProxyAddr(ipStr, portStr string, ipv4 bool) (*net.TCPAddr, error) {
+	ip := net.ParseIP(ipStr)
+	port, err := strconv.Atoi(portStr)
+	if ip == nil || (ip.To4() != nil) != ipv4 || err != nil || port < 0 || port > 65535 {
+		return nil, errors.New("无效的地址")
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// 解析 v2 头部（包括 16 字节的固定部分）
+func parseProxyHeaderV2(b []byte) (*proxyHeader, error) {
+	if b[12]>>4 != 2 {
+		return nil, fmt.Errorf("无效的 PROXY protocol v2 版本: %d", b[12]>>4)
+	}
+	switch b[12] & 0x0f {
+	case 0x00: // LOCAL（例如负载均衡的健康检查），使用连接本身的地址
+		return &proxyHeader{}, nil
+	case 0x01: // PROXY
+	default:
+		return nil, fmt.Errorf("无效的 PROXY protocol v2 命令: %d", b[12]&0x0f)
+	}
+	addrs := b[16:]
+	switch b[13] {
+	case 0x11: // TCP over IPv4
+		if len(addrs) < 12 {
+			return nil, errors.New("PROXY protocol v2 头部中的地址长度无效")
+		}
+		return &proxyHeader{
+			src: &net.TCPAddr{IP: net.IP(append([]byte(nil), addrs[0:4]...)), Port: int(binary.BigEndian.Uint16(addrs[8:10]))},
+			dst: &net.TCPAddr{IP: net.IP(append([]byte(nil), addrs[4:8]...)), Port: int(binary.BigEndian.Uint16(addrs[10:12]))},
+		}, nil
+	case 0x21: // TCP over IPv6
+		if len(addrs) < 36 {
+			return nil, errors.New("PROXY protocol v2 头部中的地址长度无效")
+		}
+		return &proxyHeader{
+			src: &net.TCPAddr{IP: net.IP(append([]byte(nil), addrs[0:16]...)), Port: int(binary.BigEndian.Uint16(addrs[32:34]))},
+			dst: &net.TCPAddr{IP: net.IP(append([]byte(nil), addrs[16:32]...)), Port: int(binary.BigEndian.Uint16(addrs[34:36]))},
+		}, nil
+	default: // 不支持的地址类型（UDP、Unix socket 等），使用连接本身的地址
+		return &proxyHeader{}, nil
+	}
+}
+
+// 使用 PROXY protocol 中的地址作为客户端地址、本端地址的连接
+type proxiedConn struct {
+	net.Conn
+	remote, local net.Addr
+}
+
+// 客户端地址
+func (c *proxiedConn) RemoteAddr() net.Addr { return c.remote }
+
+// 本端地址（客户端连接的负载均衡地址）
+func (c *proxiedConn) LocalAddr() net.Addr { return c.local }
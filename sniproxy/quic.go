@@ -0,0 +1,263 @@
+package sniproxy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// 支持的 QUIC 版本
+const (
+	quicVersion1 = 0x00000001 // RFC 9000
+	quicVersion2 = 0x6b3343cf // RFC 9369
+)
+
+// 计算 Initial 包密钥使用的固定 salt（RFC 9001 5.2、RFC 9369 3.3.1）
+var (
+	quicV1InitialSalt = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+	quicV2InitialSalt = []byte{0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93, 0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9}
+)
+
+var errNotQUICInitial = errors.New("不是 QUIC Initial 包")
+
+// CRYPTO 帧中的一段数据（ClientHello 可能分散在多个帧、多个数据报中，且顺序不固定）
+type cryptoFragment struct {
+	offset uint64
+	data   []byte
+}
+
+// HKDF-Extract（SHA-256）
+func hkdfExtract(salt, ikm []byte) []byte {
+	h := hmac.New(sha256.New, salt)
+	h.Write(ikm)
+	return h.Sum(nil)
+}
+
+// HKDF-Expand-Label（RFC 8446 7.1，上下文为空，length 不超过 32 字节）
+func hkdfExpandLabel(secret []byte, label string, length int) []byte {
+	info := binary.BigEndian.AppendUint16(nil, uint16(length))
+	info = append(info, byte(len("tls13 ")+len(label)))
+	info = append(info, "tls13 "...)
+	info = append(info, label...)
+	info = append(info, 0, 1) // 上下文长度 0、HKDF-Expand 的计数器 1
+	h := hmac.New(sha256.New, secret)
+	h.Write(info)
+	return h.Sum(nil)[:length]
+}
+
+// 客户端 Initial 包的密钥（由客户端选择的目标连接 ID 计算得出，任何人都可以解密，只用于防止被中间设备篡改）
+type quicInitialKeys struct {
+	aead cipher.AEAD  // AES-128-GCM 负载加密
+	iv   []byte       // 负载加密的 IV
+	hp   cipher.Block // AES-128 头部保护
+}
+
+// 计算客户端 Initial 包的密钥
+func newQUICInitialKeys(version uint32, dcid []byte) (*quicInitialKeys, error) {
+	salt, prefix := quicV1InitialSalt, "quic "
+	if version == quicVersion2 {
+		salt, prefix = quicV2InitialSalt, "quicv2 "
+	}
+	secret := hkdfExpandLabel(hkdfExtract(salt, dcid), "client in", 32)
+	block, err := aes.NewCipher(hkdfExpandLabel(secret, prefix+"key", 16))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	hp, err := aes.NewCipher(hkdfExpandLabel(secret, prefix+"hp", 16))
+	if err != nil {
+		return nil, err
+	}
+	return &quicInitialKeys{aead: aead, iv: hkdfExpandLabel(secret, prefix+"iv", 12), hp: hp}, nil
+}
+
+// 读取 QUIC 变长整数，返回值和占用的字节数
+func readQUICVarint(b []byte) (uint64, int, bool) {
+	if len(b) == 0 {
+		return 0, 0, false
+	}
+	n := 1 << (b[0] >> 6)
+	if len(b) < n {
+		return 0, 0, false
+	}
+	v := uint64(b[0] & 0x3f)
+	for i := 1; i < n; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, n, true
+}
+
+// 解析 UDP 数据报中的客户端 Initial 包（一个数据报中可能有多个 QUIC 包），返回其中 CRYPTO 帧的数据
+func parseQUICInitial(datagram []byte) ([]cryptoFragment, error) {
+	var frags []cryptoFragment
+	b := datagram
+	for len(b) > 0 && b[0]&0x80 != 0 { // 长头部包（之后的短头部包直到数据报末尾）
+		if len(b) < 7 {
+			return nil, errNotQUICInitial
+		}
+		version := binary.BigEndian.Uint32(b[1:5])
+		if version != quicVersion1 && version != quicVersion2 {
+			return nil, fmt.Errorf("不支持的 QUIC 版本: 0x%08x", version)
+		}
+		initialType := byte(0) // 包类型：v1 中 Initial 为 0，v2 中为 1
+		if version == quicVersion2 {
+			initialType = 1
+		}
+		isInitial := (b[0]>>4)&0x03 == initialType
+
+		pos := 5
+		dcidLen := int(b[pos])
+		if dcidLen > 20 || len(b) < pos+1+dcidLen+1 {
+			return nil, errNotQUICInitial
+		}
+		dcid := b[pos+1 : pos+1+dcidLen]
+		pos += 1 + dcidLen
+		scidLen := int(b[pos])
+		if scidLen > 20 || len(b) < pos+1+scidLen {
+			return nil, errNotQUICInitial
+		}
+		pos += 1 + scidLen
+		if isInitial { // 只有 Initial 包有 Token
+			tokenLen, n, ok := readQUICVarint(b[pos:])
+			if !ok || uint64(len(b)-pos-n) < tokenLen {
+				return nil, errNotQUICInitial
+			}
+			pos += n + int(tokenLen)
+		}
+		length, n, ok := readQUICVarint(b[pos:])
+		if !ok || uint64(len(b)-pos-n) < length {
+			return nil, errNotQUICInitial
+		}
+		pos += n
+		packet, pnOffset := b[:pos+int(length)], pos
+		b = b[pos+int(length):]
+		if !isInitial { // 0-RTT 等其他包跳过
+			continue
+		}
+
+		payload, err := decryptQUICInitial(version, dcid, packet, pnOffset)
+		if err != nil {
+			return nil, err
+		}
+		f, err := parseCryptoFrames(payload)
+		if err != nil {
+			return nil, err
+		}
+		frags = append(frags, f...)
+	}
+	if len(frags) == 0 {
+		return nil, errNotQUICInitial
+	}
+	return frags, nil
+}
+
+// 去除头部保护并解密 Initial 包的负载（pnOffset 为包序号在包中的位置）
+func decryptQUICInitial(version uint32, dcid, packet []byte, pnOffset int) ([]byte, error) {
+	if len(packet) < pnOffset+4+16 { // 头部保护的采样从包序号之后 4 字节开始，共 16 字节
+		return nil, errNotQUICInitial
+	}
+	keys, err := newQUICInitialKeys(version, dcid)
+	if err != nil {
+		return nil, err
+	}
+	mask := make([]byte, 16)
+	keys.hp.Encrypt(mask, packet[pnOffset+4:pnOffset+20])
+	header := append([]byte(nil), packet[:pnOffset+4]...) // 不修改原始数据报（之后需要原样转发）
+	header[0] ^= mask[0] & 0x0f
+	pnLen := int(header[0]&0x03) + 1
+	var pn uint64
+	for i := 0; i < pnLen; i++ {
+		header[pnOffset+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(header[pnOffset+i])
+	}
+	header = header[:pnOffset+pnLen]
+
+	nonce := append([]byte(nil), keys.iv...)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+	payload, err := keys.aead.Open(nil, nonce, packet[pnOffset+pnLen:], header)
+	if err != nil {
+		return nil, fmt.Errorf("解密 QUIC Initial 包失败: %v", err)
+	}
+	return payload, nil
+}
+
+// 解析 Initial 包负载中的帧，返回 CRYPTO 帧的数据
+func parseCryptoFrames(p []byte) ([]cryptoFragment, error) {
+	var frags []cryptoFragment
+	for len(p) > 0 {
+		switch p[0] {
+		case 0x00, 0x01: // PADDING、PING
+			p = p[1:]
+		case 0x02, 0x03: // ACK（0x03 带有 ECN 计数）
+			fields := 4 // 最大确认序号、确认延迟、范围数量、第一个范围
+			t := p[0]
+			p = p[1:]
+			for i := 0; i < fields; i++ {
+				v, n, ok := readQUICVarint(p)
+				if !ok {
+					return nil, errors.New("QUIC ACK 帧不完整")
+				}
+				p = p[n:]
+				if i == 2 { // 每个范围有间隔、长度两个字段
+					fields += 2 * int(v)
+				}
+			}
+			if t == 0x03 {
+				for i := 0; i < 3; i++ {
+					_, n, ok := readQUICVarint(p)
+					if !ok {
+						return nil, errors.New("QUIC ACK 帧不完整")
+					}
+					p = p[n:]
+				}
+			}
+		case 0x06: // CRYPTO
+			offset, n1, ok1 := readQUICVarint(p[1:])
+			length, n2, ok2 := readQUICVarint(p[1+n1:])
+			start := 1 + n1 + n2
+			if !ok1 || !ok2 || uint64(len(p)-start) < length {
+				return nil, errors.New("QUIC CRYPTO 帧不完整")
+			}
+			frags = append(frags, cryptoFragment{offset: offset, data: p[start : start+int(length)]})
+			p = p[start+int(length):]
+		case 0x1c: // CONNECTION_CLOSE，之后的内容不再解析
+			return frags, nil
+		default:
+			return nil, fmt.Errorf("QUIC Initial 包中有未知的帧类型: 0x%02x", p[0])
+		}
+	}
+	return frags, nil
+}
+
+// 拼接 CRYPTO 帧的数据，ClientHello 完整时返回带有 TLS 记录头的 ClientHello（可直接交给 parseClientHello）
+func assembleClientHello(frags []cryptoFragment) ([]byte, bool) {
+	sort.Slice(frags, func(i, j int) bool { return frags[i].offset < frags[j].offset })
+	var data []byte
+	for _, f := range frags {
+		if f.offset > uint64(len(data)) { // 中间还缺少数据
+			break
+		}
+		if end := f.offset + uint64(len(f.data)); end > uint64(len(data)) {
+			data = append(data, f.data[uint64(len(data))-f.offset:]...)
+		}
+	}
+	if len(data) < 4 || data[0] != typeClientHello {
+		return nil, false
+	}
+	need := 4 + (int(data[1])<<16 | int(data[2])<<8 | int(data[3]))
+	if len(data) < need || need > 0xffff {
+		return nil, false
+	}
+	record := []byte{byte(recordTypeHandshake), 0x03, 0x01, byte(need >> 8), byte(need)} // QUIC 中没有 TLS 记录层
+	return append(record, data[:need]...), true
+}
@@ -0,0 +1,415 @@
+package sniproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	maxQUICPendingPackets = 10 // 获得 SNI 域名前最多缓存的数据报数量（ClientHello 通常只占 1-2 个数据报）
+	maxQUICDatagramSize   = 65535
+	quicSweepInterval     = 5 * time.Second // 清理空闲 QUIC 会话的间隔
+)
+
+// QUIC 监听（mode: quic）：从客户端的 Initial 包中获取 SNI 域名，之后按客户端地址原样转发 UDP 数据报
+type quicProxy struct {
+	proxy   *Proxy
+	conn    net.PacketConn
+	index   int          // 监听配置在 listeners 中的位置
+	limiter *connLimiter // 全局连接数限制（max_connections，与 TCP 连接共用）
+	loop    *acceptLoop  // 读取数据报线程的运行状态（Healthy 检查）
+
+	mu       sync.Mutex
+	sessions map[string]*quicSession // 客户端地址 => 会话
+}
+
+// 一个客户端地址的 QUIC 会话
+type quicSession struct {
+	client net.Addr
+	fields LogFields
+	start  time.Time
+
+	mu       sync.Mutex
+	upstream net.Conn // 连接目标后不为空
+	pending  [][]byte // 连接目标前收到的数据报（连接后按顺序发送给目标）
+	frags    []cryptoFragment
+	routing  bool // 已获得 SNI 域名，正在连接目标
+	rejected bool // 已拒绝（之后的数据报直接丢弃，直到会话过期）
+	closed   bool // 会话已关闭（正在连接目标时被关闭，连接后直接关闭目标连接）
+
+	release     func() // 释放连接数名额并注销连接（会话通过连接数限制后设置）
+	releaseOnce sync.Once
+
+	lastActive atomic.Int64 // 最后一次收发数据的时间（UnixNano）
+	bytesUp    atomic.Int64
+	bytesDown  atomic.Int64
+}
+
+// 记录活动
+func (s *quicSession) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+// 会话结束（被拒绝或关闭）时释放连接数名额并注销连接，可以多次调用
+func (s *quicSession) finish() {
+	if s.release != nil {
+		s.releaseOnce.Do(s.release)
+	}
+}
+
+// 读取客户端的数据报，直到监听被关闭
+func (p *quicProxy) serve(ctx context.Context) {
+	go p.sweep(ctx)
+	defer p.loop.stopped.Store(true)
+	buf := make([]byte, maxQUICDatagramSize)
+	for {
+		p.loop.idle()
+		n, addr, err := p.conn.ReadFrom(buf)
+		p.loop.busy()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) { // 退出时关闭了监听
+				p.closeAll()
+				return
+			}
+			p.proxy.serviceLogger(fmt.Sprintf("读取 QUIC 数据报时出错: %v", err), LevelError)
+			continue
+		}
+		p.handle(ctx, addr, append([]byte(nil), buf[:n]...))
+	}
+}
+
+// 处理客户端的一个数据报（只在 serve 线程中调用，因此只有这里会创建会话）
+func (p *quicProxy) handle(ctx context.Context, client net.Addr, datagram []byte) {
+	key := client.String()
+	p.mu.Lock()
+	s, ok := p.sessions[key]
+	p.mu.Unlock()
+	if !ok { // 新会话的检查不持有 p.mu，避免阻塞清理会话
+		s = p.newSession(client)
+		p.mu.Lock()
+		p.sessions[key] = s
+		p.mu.Unlock()
+	}
+	s.touch()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.rejected:
+		return
+	case s.upstream != nil:
+		if n, err := s.upstream.Write(datagram); err == nil {
+			s.bytesUp.Add(int64(n))
+			metricBytesForwarded.WithLabelValues("upstream").Add(float64(n))
+		}
+		return
+	}
+	s.pending = append(s.pending, datagram)
+	if s.routing { // 正在连接目标，连接后一起发送
+		return
+	}
+	if len(s.pending) > maxQUICPendingPackets {
+		metricSNIParseFailures.Inc()
+		p.proxy.serviceLoggerFields(fmt.Sprintf("%d 个数据报中没有完整的 ClientHello, 忽略...", maxQUICPendingPackets), LevelDebug, s.fields)
+		s.reject()
+		return
+	}
+	frags, err := parseQUICInitial(datagram)
+	if err != nil {
+		if len(s.frags) == 0 { // 会话的第一个数据报就不是 Initial 包（例如已经过期的会话）
+			metricSNIParseFailures.Inc()
+			p.proxy.serviceLoggerFields(fmt.Sprintf("解析 QUIC Initial 包失败: %v", err), LevelDebug, s.fields)
+			s.reject()
+		}
+		return
+	}
+	s.frags = append(s.frags, frags...)
+	record, ok := assembleClientHello(s.frags)
+	if !ok { // ClientHello 分散在多个数据报中，等待之后的数据报
+		return
+	}
+	s.frags = nil
+	hello, err := parseClientHello(record)
+	if err != nil {
+		metricSNIParseFailures.Inc()
+		p.proxy.serviceLoggerFields(fmt.Sprintf("解析 ClientHello 失败: %v", err), LevelDebug, s.fields)
+		s.reject()
+		return
+	}
+	s.routing = true
+	go p.route(ctx, s, hello)
+}
+
+// 创建新会话，与 TCP 连接一样检查限制（conn_rate_per_ip、max_conns_per_ip、max_connections 已满时不等待）并登记到活动连接中
+// 未通过检查的会话同样保留（直到过期），之后该客户端地址的数据报直接丢弃
+func (p *quicProxy) newSession(client net.Addr) *quicSession {
+	key := client.String()
+	s := &quicSession{client: client, start: time.Now(), fields: LogFields{ID: newConnID(), Client: key}}
+	cfg := p.proxy.getConfig()
+	metricConnectionsTotal.Inc()
+	p.proxy.summary.accepted()
+	p.proxy.statsd(cfg, statsdCount("connections", 1))
+	p.proxy.serviceLoggerFields("QUIC 连接来自: "+key, LevelDebug, s.fields)
+	udpAddr, isUDP := client.(*net.UDPAddr)
+	clientIP := key
+	if isUDP {
+		clientIP = udpAddr.IP.String()
+	}
+	reject := func(reason, message string) *quicSession {
+		metricRejectedConnections.WithLabelValues(reason).Inc()
+		p.proxy.serviceLoggerFields(fmt.Sprintf("拒绝客户端 %s 的 QUIC 连接: %s", clientIP, message), LevelWarn, s.fields)
+		s.reject()
+		return s
+	}
+	if !p.proxy.clientRate.allow(clientIP, cfg.ConnRatePerIP, cfg.ConnBurstPerIP) {
+		return reject("rate_limit", fmt.Sprintf("新建连接速率超过限制 %v 个/秒", cfg.ConnRatePerIP))
+	}
+	if !p.proxy.clientConns.acquire(clientIP, cfg.MaxConnsPerIP) {
+		return reject("max_conns_per_ip", fmt.Sprintf("连接数已达到上限 %d", cfg.MaxConnsPerIP))
+	}
+	if !p.limiter.acquire(0) { // 不能等待（会阻塞读取所有客户端的数据报）
+		p.proxy.clientConns.release(clientIP)
+		return reject("max_connections", fmt.Sprintf("总连接数已达到上限 %d（当前 %d）", p.limiter.limit(), p.limiter.count()))
+	}
+	p.proxy.conns.add(s.fields)
+	p.proxy.summary.enter()
+	s.release = func() {
+		p.proxy.conns.remove(s.fields.ID)
+		p.proxy.summary.leave()
+		p.limiter.release()
+		p.proxy.clientConns.release(clientIP)
+	}
+
+	p.proxy.onAccept(client)
+	if isUDP && !clientAllowed(udpAddr.IP, cfg) {
+		return reject("allowed_clients", "不在 allowed_clients 中")
+	}
+	if reason := checkSchedule(cfg); reason != "" {
+		return reject("schedule", reason)
+	}
+	if isUDP {
+		var reason string
+		if s.fields.Country, reason = checkCountry(udpAddr.IP, cfg); reason != "" {
+			return reject("geoip", reason)
+		}
+	}
+	return s
+}
+
+// 拒绝会话（之后的数据报直接丢弃）
+func (s *quicSession) reject() {
+	s.rejected, s.routing = true, false
+	s.pending, s.frags = nil, nil
+	s.finish()
+}
+
+// 根据 SNI 域名匹配规则并连接目标（规则与同一监听的 TCP 连接相同）
+func (p *quicProxy) route(ctx context.Context, s *quicSession, hello *clientHelloMsg) {
+	cfg := p.proxy.getConfig()
+	listener := cfg.listeners[p.index]
+	fields := s.fields
+	serverName, nameErr := normalizeServerName(hello.serverName)
+	fields.SNI, fields.ALPN, fields.JA3 = serverName, hello.alpnProtocols, ja3Fingerprint(hello)
+	metricALPN.WithLabelValues(alpnLabel(hello.alpnProtocols)).Inc()
+
+	fail := func(reason, message string, level Level) {
+		if reason != "" {
+			metricRejectedConnections.WithLabelValues(reason).Inc()
+		}
+		p.proxy.serviceLoggerFields(message, level, fields)
+		s.mu.Lock()
+		s.reject()
+		s.mu.Unlock()
+	}
+	if reason := checkJA3(fields.JA3, cfg); reason != "" {
+		fail("ja3", fmt.Sprintf("拒绝客户端 %s 的 QUIC 连接: JA3 指纹 %s %s", fields.Client, fields.JA3, reason), LevelWarn)
+		return
+	}
+	if hello.serverName == "" {
+		metricSNIParseFailures.Inc()
+		fail("", "未找到 SNI 域名, 忽略...", LevelDebug)
+		return
+	}
+	if nameErr != nil {
+		fail("invalid_sni", fmt.Sprintf("拒绝客户端 %s 的 QUIC 连接: SNI 域名 %s 无效: %v", fields.Client, quoteInvalidName(hello.serverName), nameErr), LevelDebug)
+		return
+	}
+	p.proxy.summary.sni(serverName)
+	if rule := firstMatch(cfg.blockedHosts, serverName); rule != nil {
+		message := fmt.Sprintf("拒绝客户端 %s 的 QUIC 连接: SNI 域名 %s 命中屏蔽规则 %s", fields.Client, serverName, rule.raw)
+		fail("blocked_hosts", message, LevelWarn)
+		p.proxy.webhook.notify(cfg, eventBlocked, serverName, message)
+		return
+	}
+	if err := p.proxy.onSNI(s.client, serverName); err != nil {
+		fail("", fmt.Sprintf("拒绝客户端 %s 的 QUIC 连接: %v", fields.Client, err), LevelWarn)
+		return
+	}
+	var targets []string
+	fromSNI := true
+	rule := firstMatch(cfg.countryRules[fields.Country], serverName) // 客户端所在国家或地区的规则优先
+	switch {
+	case rule != nil: // 已匹配 country_rules
+	case listener.AllowAllHosts:
+		metricRuleMatches.WithLabelValues("*").Inc()
+		targets = []string{net.JoinHostPort(serverName, strconv.Itoa(listener.ForwardPort))}
+	default:
+		rule = firstMatch(listener.rules, serverName) // 与 TCP 相同，只使用第一个匹配的规则
+	}
+	if rule != nil {
+		metricRuleMatches.WithLabelValues(rule.raw).Inc()
+		targets, fromSNI = rule.targetsFor(serverName, listener.ForwardPort, cfg.LoadBalance), len(rule.targets) == 0
+	}
+	if len(targets) == 0 {
+		fail("", fmt.Sprintf("SNI 域名 %s 不在规则中, 忽略...", serverName), LevelDebug)
+		return
+	}
+	fields.Target = targets[0]
+	p.proxy.serviceLoggerFields(fmt.Sprintf("QUIC 转发目标: %s", fields.Target), LevelInfo, fields)
+
+	upstream, err := dialUDPTarget(ctx, cfg, targets[0], fromSNI)
+	if err != nil {
+		metricDialFailures.Inc()
+		p.proxy.statsd(cfg, statsdCount("dial_failures", 1))
+		p.proxy.webhook.notify(cfg, eventDialFailure, fields.Target, fmt.Sprintf("客户端 %s 连接 QUIC 目标 %s 失败: %v", fields.Client, fields.Target, err))
+		fail("", fmt.Sprintf("连接 QUIC 目标 %s 时出错: %v", fields.Target, err), LevelError)
+		return
+	}
+	p.proxy.onForward(serverName, fields.Target)
+	s.mu.Lock()
+	if s.closed { // 连接目标期间会话已被关闭（例如退出时）
+		s.mu.Unlock()
+		upstream.Close()
+		return
+	}
+	s.fields = fields
+	if info := p.proxy.conns.update(fields); info != nil { // 管理 API 中显示的已转发字节数
+		p.proxy.conns.setProbe(info, func() (int64, int64, bool) { return s.bytesUp.Load(), s.bytesDown.Load(), true })
+	}
+	for _, datagram := range s.pending { // 发送连接目标前收到的数据报（包括 Initial 包）
+		if n, err := upstream.Write(datagram); err == nil {
+			s.bytesUp.Add(int64(n))
+			metricBytesForwarded.WithLabelValues("upstream").Add(float64(n))
+		}
+	}
+	s.pending, s.upstream, s.routing = nil, upstream, false
+	s.mu.Unlock()
+	p.relay(s, upstream)
+}
+
+// 将目标的数据报转发给客户端，直到会话结束（目标连接被关闭）
+func (p *quicProxy) relay(s *quicSession, upstream net.Conn) {
+	buf := make([]byte, maxQUICDatagramSize)
+	for {
+		n, err := upstream.Read(buf)
+		if err != nil {
+			if errors.Is(err, syscall.ECONNREFUSED) { // 目标暂时不可达（ICMP 端口不可达），继续等待
+				continue
+			}
+			return
+		}
+		if _, err := p.conn.WriteTo(buf[:n], s.client); err == nil {
+			s.bytesDown.Add(int64(n))
+			metricBytesForwarded.WithLabelValues("downstream").Add(float64(n))
+		}
+		s.touch()
+	}
+}
+
+// 定期清理空闲的会话（超过 idle_timeout 没有数据，尚未连接目标的会话为 handshake_timeout）
+func (p *quicProxy) sweep(ctx context.Context) {
+	ticker := time.NewTicker(quicSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		cfg := p.proxy.getConfig()
+		now := time.Now()
+		p.mu.Lock()
+		for key, s := range p.sessions {
+			s.mu.Lock()
+			timeout := time.Duration(cfg.HandshakeTimeout) * time.Second
+			if s.upstream != nil {
+				timeout = time.Duration(cfg.IdleTimeout) * time.Second
+			}
+			if now.Sub(time.Unix(0, s.lastActive.Load())) > timeout && !s.routing {
+				delete(p.sessions, key)
+				p.closeSession(s)
+			}
+			s.mu.Unlock()
+		}
+		p.mu.Unlock()
+	}
+}
+
+// 关闭所有会话
+func (p *quicProxy) closeAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, s := range p.sessions {
+		s.mu.Lock()
+		p.closeSession(s)
+		s.mu.Unlock()
+		delete(p.sessions, key)
+	}
+}
+
+// 关闭会话（已连接目标时输出连接统计），调用时需持有 s.mu
+func (p *quicProxy) closeSession(s *quicSession) {
+	s.closed = true
+	defer s.finish()
+	if s.upstream == nil {
+		return
+	}
+	s.upstream.Close()
+	s.fields.ConnSummary = &ConnSummary{BytesUp: s.bytesUp.Load(), BytesDown: s.bytesDown.Load(), DurationMs: time.Since(s.start).Milliseconds()}
+	p.proxy.summary.forwarded(s.bytesUp.Load(), s.bytesDown.Load())
+	p.proxy.statsd(p.proxy.getConfig(), statsdCount("bytes.upstream", s.bytesUp.Load()), statsdCount("bytes.downstream", s.bytesDown.Load()), statsdTiming("connection.duration", time.Since(s.start)))
+	p.proxy.serviceLoggerFields(fmt.Sprintf("QUIC 连接结束: 客户端 %s, SNI 域名 %s, 目标 %s, 上行 %d 字节, 下行 %d 字节, 时长 %v",
+		s.fields.Client, s.fields.SNI, s.fields.Target, s.bytesUp.Load(), s.bytesDown.Load(), time.Since(s.start).Round(time.Millisecond)), LevelInfo, s.fields)
+	p.proxy.onClose(s.fields)
+}
+
+// 解析 QUIC 目标并创建 UDP 连接（与 TCP 目标一样检查本服务自身地址、内网地址和 ip_version，有多个 IP 时使用第一个）
+func dialUDPTarget(ctx context.Context, cfg *Config, addr string, fromSNI bool) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("无效的端口: %s", portStr)
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		if ips, err = resolveHost(ctx, host, cfg); err != nil {
+			return nil, err
+		}
+	}
+	if ips, err = filterSelfIPs(host, ips, port); err != nil {
+		return nil, err
+	}
+	if _, static := cfg.staticHost(host); fromSNI && cfg.BlockPrivateIPs && !static {
+		if ips, err = filterPrivateIPs(host, ips, cfg); err != nil {
+			return nil, err
+		}
+	}
+	if ips, err = filterIPVersion(host, ips, int(cfg.IPVersion)); err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Control: outboundControl(cfg)}
+	if cfg.outboundIP != nil {
+		dialer.LocalAddr = &net.UDPAddr{IP: cfg.outboundIP}
+	}
+	return dialer.DialContext(ctx, "udp", net.JoinHostPort(ips[0].String(), portStr))
+}
@@ -0,0 +1,38 @@
+package sniproxy
+
+import (
+	"net"
+	"testing"
+)
+
+// 新 QUIC 会话与 TCP 连接一样受 max_conns_per_ip、max_connections 限制，并登记到活动连接中（关闭后注销）
+func TestQUICSessionLimits(t *testing.T) {
+	p := newTestProxy(t, "listen_addr: 127.0.0.1:0\nmode: quic\nmax_conns_per_ip: 1\nmax_connections: 2\nrules: [example.com]\n")
+	q := &quicProxy{proxy: p, limiter: newConnLimiter(2), sessions: make(map[string]*quicSession)}
+	clients := []struct {
+		addr     *net.UDPAddr
+		rejected bool
+	}{
+		{&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1001}, false},
+		{&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1002}, true}, // max_conns_per_ip
+		{&net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 1001}, false},
+		{&net.UDPAddr{IP: net.IPv4(127, 0, 0, 3), Port: 1001}, true}, // max_connections
+	}
+	for _, c := range clients {
+		s := q.newSession(c.addr)
+		if s.rejected != c.rejected {
+			t.Errorf("客户端 %v 的会话 rejected = %v, 期望 %v", c.addr, s.rejected, c.rejected)
+		}
+		q.sessions[c.addr.String()] = s
+	}
+	if n := p.conns.count(); n != 2 {
+		t.Errorf("活动连接数为 %d, 期望 2", n)
+	}
+	q.closeAll()
+	if n := p.conns.count(); n != 0 {
+		t.Errorf("关闭所有会话后活动连接数为 %d, 期望 0", n)
+	}
+	if s := q.newSession(clients[3].addr); s.rejected { // 名额已释放
+		t.Errorf("关闭所有会话后客户端 %v 的会话仍被拒绝", clients[3].addr)
+	}
+}
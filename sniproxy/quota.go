@@ -0,0 +1,59 @@
+package sniproxy
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+var errByteQuotaExceeded = errors.New("连接流量超过 max_bytes_per_conn") // 由 byteQuota 记录日志并关闭连接，不需要再记录
+
+// 单个连接的流量配额（max_bytes_per_conn，两个方向合计），超过时调用 onExceeded
+type byteQuota struct {
+	remaining  atomic.Int64
+	once       sync.Once
+	onExceeded func()
+}
+
+// 创建流量配额（limit 为 0 时返回 nil，即不限制），used 为已经转发的字节数（例如 ClientHello）
+func newByteQuota(limit, used int64, onExceeded func()) *byteQuota {
+	if limit <= 0 {
+		return nil
+	}
+	q := &byteQuota{onExceeded: onExceeded}
+	q.remaining.Store(limit - used)
+	return q
+}
+
+// 包装连接，读取的数据计入配额（没有配额时返回原连接）
+// 包装后不再是 *net.TCPConn，因此不会使用 splice 转发
+func (q *byteQuota) wrap(c net.Conn) net.Conn {
+	if q == nil {
+		return c
+	}
+	return &quotaConn{Conn: c, quota: q}
+}
+
+// 计入配额的连接
+type quotaConn struct {
+	net.Conn
+	quota *byteQuota
+}
+
+// 读取数据（最多读取剩余配额加 1 个字节，多读到的 1 个字节说明还有数据要传输，此时丢弃并关闭连接，转发的数据不会超过配额）
+func (c *quotaConn) Read(b []byte) (int, error) {
+	remaining := c.quota.remaining.Load()
+	if remaining < 0 {
+		remaining = 0
+	}
+	if int64(len(b)) > remaining+1 {
+		b = b[:remaining+1]
+	}
+	n, err := c.Conn.Read(b)
+	if left := c.quota.remaining.Add(-int64(n)); left < 0 {
+		c.quota.once.Do(c.quota.onExceeded)
+		return 0, errByteQuotaExceeded
+	}
+	return n, err
+}
@@ -0,0 +1,63 @@
+package sniproxy
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const rateLimiterSweepInterval = time.Minute // 清理空闲客户端令牌桶的间隔
+
+// 每个客户端 IP 的新建连接速率限制（令牌桶）
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// 令牌桶
+type tokenBucket struct {
+	tokens float64   // 剩余令牌数
+	last   time.Time // 上次更新令牌数的时间
+}
+
+// 是否允许该 IP 新建连接（每秒补充 rate 个令牌，最多 burst 个，每个连接消耗 1 个；rate 为 0 时不限制）
+func (l *rateLimiter) allow(ip string, rate float64, burst int) bool {
+	if rate <= 0 {
+		return true
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) >= rateLimiterSweepInterval {
+		l.sweep(now, rate, burst)
+	}
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[ip] = b
+	} else {
+		b.tokens = refill(b, now, rate, burst)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// 删除已经补满的令牌桶（和不存在时等价），避免 map 无限增长
+func (l *rateLimiter) sweep(now time.Time, rate float64, burst int) {
+	for ip, b := range l.buckets {
+		if refill(b, now, rate, burst) >= float64(burst) {
+			delete(l.buckets, ip)
+		}
+	}
+	l.lastSweep = now
+}
+
+// 计算到 now 时令牌桶中的令牌数
+func refill(b *tokenBucket, now time.Time, rate float64, burst int) float64 {
+	return math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+}
@@ -0,0 +1,48 @@
+package sniproxy
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// 拒绝连接时发送的 TLS 警报（RFC 8446 6.2）
+const (
+	alertNone             byte = 0   // 不发送警报（还没有读取 ClientHello 时）
+	alertAccessDenied     byte = 49  // 客户端被拒绝（屏蔽的域名、JA3 指纹等）
+	alertUnrecognizedName byte = 112 // 没有与 SNI 域名匹配的规则
+
+	alertWriteTimeout = time.Second // 发送 TLS 警报的超时时间
+)
+
+// 拒绝连接的方式
+const (
+	rejectActionClose  = "close"  // 正常关闭连接（默认）
+	rejectActionRST    = "rst"    // 发送 RST 重置连接
+	rejectActionTarpit = "tarpit" // 保持连接一段时间不做任何事再关闭，拖慢扫描器
+
+	defaultRejectTarpitTime = 30 // 默认 tarpit 保持连接的时间（秒）
+)
+
+// 拒绝连接（之后由调用方关闭连接）：开启 reject_tls_alert 时先发送 TLS 致命警报，让客户端显示有意义的握手失败，再按 reject_action 处理
+// alert 为 alertNone 或 http 模式的监听时不发送警报（客户端还没有发送 ClientHello，或不会理解 TLS 警报）
+func rejectConn(c net.Conn, cfg *Config, listener *ListenerConfig, alert byte) {
+	if cfg.RejectTLSAlert && alert != alertNone && listener.Mode != listenModeHTTP {
+		c.SetWriteDeadline(time.Now().Add(alertWriteTimeout))
+		c.Write([]byte{
+			byte(recordTypeAlert), 0x03, 0x03, // TLS 1.2 记录版本（TLS 1.3 的记录层也使用该版本）
+			0x00, 0x02, // 长度
+			0x02, // fatal
+			alert,
+		})
+	}
+	switch cfg.RejectAction {
+	case rejectActionRST:
+		if tc, ok := unwrapTCPConn(c); ok {
+			tc.SetLinger(0) // 关闭时直接发送 RST，不进行四次挥手
+		}
+	case rejectActionTarpit: // 丢弃客户端发送的数据，直到超时、客户端关闭连接或退出
+		c.SetReadDeadline(time.Now().Add(time.Duration(cfg.RejectTarpitTime) * time.Second))
+		io.Copy(io.Discard, c)
+	}
+}
@@ -0,0 +1,168 @@
+package sniproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultDNSNegativeTTL = 10              // 默认域名不存在（NXDOMAIN）的缓存时间（秒）
+	maxDNSCacheEntries    = 10000           // DNS 缓存最多保存的域名数量
+	defaultDNSPort        = "53"            // dns_servers 中省略端口时使用的端口
+	dnsDialTimeout        = 5 * time.Second // 连接 DNS 服务器的超时时间（TCP 查询时）
+)
+
+// DNS 解析缓存
+type dnsCache struct {
+	mu      sync.Mutex
+	entries map[string]dnsCacheEntry
+}
+
+// DNS 缓存条目
+type dnsCacheEntry struct {
+	ips     []net.IP
+	err     error // 不为空时代表域名不存在（负缓存）
+	expires time.Time
+}
+
+var resolverCache = &dnsCache{entries: make(map[string]dnsCacheEntry)}
+
+// 查询缓存
+func (c *dnsCache) get(host string) (dnsCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[host]
+	if !ok {
+		return entry, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, host)
+		return entry, false
+	}
+	return entry, true
+}
+
+// 写入缓存（缓存已满时先清理过期条目，清理后依然已满则不缓存）
+func (c *dnsCache) put(host string, entry dnsCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxDNSCacheEntries {
+		now := time.Now()
+		for h, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, h)
+			}
+		}
+		if len(c.entries) >= maxDNSCacheEntries {
+			return
+		}
+	}
+	c.entries[host] = entry
+}
+
+// 清空缓存（DNS 设置修改后，旧的解析结果、域名不存在的负缓存不再可信）
+func (c *dnsCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]dnsCacheEntry)
+}
+
+// 两个配置的 DNS 设置（dns_servers、doh_url、doh_fallback、dns_cache_ttl、dns_negative_ttl）是否不同
+func dnsSettingsChanged(old, cfg *Config) bool {
+	return strings.Join(old.DNSServers, ",") != strings.Join(cfg.DNSServers, ",") || old.DoHURL != cfg.DoHURL || old.DoHFallback != cfg.DoHFallback ||
+		old.DNSCacheTTL != cfg.DNSCacheTTL || old.DNSNegativeTTL != cfg.DNSNegativeTTL
+}
+
+// 查询静态 hosts（域名不区分大小写）
+func (cfg *Config) staticHost(host string) ([]net.IP, bool) {
+	ips, ok := cfg.hosts[strings.TrimSuffix(strings.ToLower(host), ".")]
+	return ips, ok
+}
+
+// 解析域名获得 IP 地址（静态 hosts 优先，启用 dns_cache_ttl 时优先使用缓存）
+func resolveHost(ctx context.Context, host string, cfg *Config) ([]net.IP, error) {
+	if ips, ok := cfg.staticHost(host); ok {
+		return ips, nil
+	}
+	if cfg.DNSCacheTTL <= 0 {
+		return lookupIP(ctx, host, cfg)
+	}
+	if entry, ok := resolverCache.get(host); ok {
+		return entry.ips, entry.err
+	}
+	ips, err := lookupIP(ctx, host, cfg)
+	switch {
+	case err == nil:
+		resolverCache.put(host, dnsCacheEntry{ips: ips, expires: time.Now().Add(time.Duration(cfg.DNSCacheTTL) * time.Second)})
+	case isNotFoundError(err): // 域名不存在时也缓存，避免无效 SNI 域名反复查询
+		resolverCache.put(host, dnsCacheEntry{err: err, expires: time.Now().Add(time.Duration(cfg.DNSNegativeTTL) * time.Second)})
+	}
+	return ips, err
+}
+
+// 解析域名（未配置 dns_servers、doh_url 时使用系统 DNS）
+// 开启 doh_fallback 时，DoH 查询失败（域名不存在除外）后改用系统 DNS 再查询一次
+func lookupIP(ctx context.Context, host string, cfg *Config) ([]net.IP, error) {
+	resolver := cfg.resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil && cfg.DoHURL != "" && cfg.DoHFallback && !isNotFoundError(err) && ctx.Err() == nil {
+		addrs, err = net.DefaultResolver.LookupIPAddr(ctx, host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return ips, nil
+}
+
+// 是否为域名不存在的错误（NXDOMAIN）
+func isNotFoundError(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// 解析 dns_servers 中的地址，格式为 IP 或 IP:端口（省略端口时为 53），不能是域名
+func parseDNSServer(server string) (string, error) {
+	server = strings.TrimSpace(server)
+	if ip := net.ParseIP(strings.Trim(server, "[]")); ip != nil {
+		return net.JoinHostPort(ip.String(), defaultDNSPort), nil
+	}
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		return "", fmt.Errorf("DNS 服务器地址 %q 无效: %v", server, err)
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("DNS 服务器地址 %q 无效: 需要是 IP 地址", server)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return "", fmt.Errorf("DNS 服务器地址 %q 的端口无效", server)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// 创建使用指定 DNS 服务器的解析器，每次连接 DNS 服务器时依次使用下一个（Go 解析器重试时即会换用其他服务器）
+func newResolver(servers []string, control func(network, address string, c syscall.RawConn) error) *net.Resolver {
+	var next atomic.Uint64
+	dialer := &net.Dialer{Timeout: dnsDialTimeout, Control: control} // 指定了 outbound_interface 时 DNS 查询也通过该网卡
+	return &net.Resolver{
+		PreferGo: true, // 只有 Go 解析器支持自定义 Dial
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			server := servers[(next.Add(1)-1)%uint64(len(servers))]
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+}
@@ -0,0 +1,43 @@
+package sniproxy
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// 重载配置修改了 DNS 设置时清空缓存（包括负缓存），修改其它设置时保留
+func TestReloadClearsDNSCache(t *testing.T) {
+	const base = "allow_all_hosts: true\ndns_cache_ttl: 60\n"
+	p := newTestProxy(t, base)
+	tests := []struct {
+		config  string
+		cleared bool
+	}{
+		{base + "idle_timeout: 30\n", false},
+		{base + "dns_servers: [127.0.0.1]\n", true},
+		{base + "dns_servers: [127.0.0.1]\n", false},
+		{base + "dns_servers: [127.0.0.1, 127.0.0.2]\n", true},
+		{base + "doh_url: https://127.0.0.1/dns-query\n", true},
+		{base + "doh_url: https://127.0.0.1/dns-query\ndoh_fallback: true\n", true},
+		{"allow_all_hosts: true\ndns_cache_ttl: 30\ndoh_url: https://127.0.0.1/dns-query\ndoh_fallback: true\n", true},
+		{"allow_all_hosts: true\ndns_cache_ttl: 30\ndns_negative_ttl: 5\ndoh_url: https://127.0.0.1/dns-query\ndoh_fallback: true\n", true},
+	}
+	for _, tt := range tests {
+		resolverCache.put("cached.test", dnsCacheEntry{ips: []net.IP{net.IPv4(192, 0, 2, 1)}, expires: time.Now().Add(time.Minute)})
+		resolverCache.put("missing.test", dnsCacheEntry{err: &net.DNSError{Err: "no such host", Name: "missing.test", IsNotFound: true}, expires: time.Now().Add(time.Minute)})
+		cfg, err := ParseConfig([]byte(tt.config), "test.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Reload(cfg); err != nil {
+			t.Fatalf("重载配置 %q 出错: %v", tt.config, err)
+		}
+		for _, host := range []string{"cached.test", "missing.test"} {
+			if _, ok := resolverCache.get(host); ok == tt.cleared {
+				t.Errorf("重载配置 %q 后 %s 的缓存存在: %v, 期望清空: %v", tt.config, host, ok, tt.cleared)
+			}
+		}
+	}
+	resolverCache.clear()
+}
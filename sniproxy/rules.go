@@ -0,0 +1,243 @@
+package sniproxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 规则指定了多个目标（或目标域名解析到多个 IP）时的选择方式（load_balance）
+const (
+	loadBalanceFailover   = "failover"    // 按顺序尝试（默认），前面的目标连接失败时才连接后面的
+	loadBalanceRoundRobin = "round_robin" // 轮询，每个新连接从下一个目标开始尝试（其余目标依然用于故障转移）
+)
+
+// 规则匹配方式
+type ruleKind int
+
+const (
+	ruleSuffix   ruleKind = iota // 匹配域名自身及其所有子域名
+	ruleContains                 // 旧版匹配方式：SNI 域名中包含该域名即匹配（legacy_rule_match）
+	ruleWildcard                 // 通配符（*.example.com）：只匹配其所有子域名，不匹配域名自身
+	ruleRegex                    // 正则表达式（~^cdn\d+\.example\.com$）：匹配正则表达式的 SNI 域名
+	ruleExact                    // 精确匹配（=example.com）：只匹配域名自身，不匹配子域名
+)
+
+// 转发规则
+type forwardRule struct {
+	raw     string        // 配置文件中的原始规则
+	kind    ruleKind      // 匹配方式
+	domain  string        // 要匹配的域名
+	targets []string      // 指定的转发目标 IP:端口（多个时按顺序尝试，为空则转发至 SNI 域名自身）
+	port    int           // 规则中指定的端口（"域名:端口"），为 0 时使用 forward_port
+	next    atomic.Uint64 // 轮询时下一个连接使用的目标
+
+	regex *regexp.Regexp // 正则表达式规则（加载配置文件时预先编译）
+
+	limiter *bandwidthLimiter // rule_rate_limits 中该规则的带宽限制（未配置时为空）
+}
+
+// 解析规则，格式为 "域名" 或 "域名=目标"（目标为 IP[:端口] 或 域名[:端口]，省略端口时使用 forward_port）
+// 可以用逗号分隔多个目标（例如 "example.com=10.0.0.1:443,10.0.0.2:443"），连接失败时依次尝试下一个
+// 域名以 "*." 开头时为通配符规则，只匹配其子域名（域名不区分大小写，国际化域名可以写成 Unicode 或 punycode 形式）
+// 以 "=" 开头时为精确匹配规则，只匹配域名自身（例如 "=example.com"，同样可以指定目标："=example.com=1.2.3.4"）
+// 以 "~" 开头时为正则表达式规则（SNI 域名会先规范化，即转为小写、国际化域名转为 punycode 形式，再匹配）
+// 域名后可以加上端口（例如 "example.com:8443"），该规则匹配后转发至该端口（而不是 forward_port），指定了目标时作为目标的默认端口
+func parseRule(rule string, defaultPort int, legacy bool) (*forwardRule, error) {
+	domain, target, hasTarget := rule, "", false
+	// 转发目标中不会有 =，因此以最后一个 = 分隔（开头的 = 为精确匹配）
+	// 正则表达式中也可以有 =，因此正则表达式规则只在最后一个 = 之后是有效的转发目标时才分隔（否则都属于正则表达式）
+	if i := strings.LastIndex(rule, "="); i > 0 && (!strings.HasPrefix(strings.TrimSpace(rule), "~") || isTargetList(rule[i+1:])) {
+		domain, target, hasTarget = rule[:i], rule[i+1:], true
+	}
+	domain, port, err := parseRulePort(rule, domain)
+	if err != nil {
+		return nil, err
+	}
+	r, err := parseDomain(rule, domain, legacy)
+	if err != nil {
+		return nil, err
+	}
+	if r.port = port; port != 0 {
+		defaultPort = port
+	}
+	return r.parseTarget(target, hasTarget, defaultPort)
+}
+
+// 分离规则域名后的端口（"域名:端口"），没有端口时返回 0
+// 域名中不会有冒号，正则表达式中则可能有（例如 (?:...)），因此正则表达式规则只在冒号后全是数字时才视为端口
+func parseRulePort(rule, domain string) (string, int, error) {
+	domain = strings.TrimSpace(domain)
+	i := strings.LastIndex(domain, ":")
+	if i < 0 {
+		return domain, 0, nil
+	}
+	port, err := strconv.Atoi(domain[i+1:])
+	if err != nil && strings.HasPrefix(domain, "~") {
+		return domain, 0, nil
+	}
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("规则 %q 中的端口无效: %s（范围 1-65535）", rule, domain[i+1:])
+	}
+	return domain[:i], port, nil
+}
+
+// 解析 blocked_hosts 中的域名（语法与 rules 中的域名相同，但不能指定转发目标，也不受 legacy_rule_match 影响）
+func parseBlockedHost(host string) (*forwardRule, error) {
+	return parseDomain(host, host, false)
+}
+
+// 解析规则中的域名部分
+func parseDomain(rule, domain string, legacy bool) (*forwardRule, error) {
+	r := &forwardRule{raw: rule, kind: ruleSuffix}
+	if legacy {
+		r.kind = ruleContains
+	}
+	domain = strings.TrimSpace(domain)
+	if strings.HasPrefix(domain, "~") {
+		regex, err := regexp.Compile(strings.TrimPrefix(domain, "~"))
+		if err != nil {
+			return nil, fmt.Errorf("规则 %q 中的正则表达式无效: %v", rule, err)
+		}
+		r.kind, r.regex, r.domain = ruleRegex, regex, domain
+		return r, nil
+	}
+
+	r.domain = strings.ToLower(domain)
+	if strings.HasPrefix(r.domain, "=") { // 精确匹配不受 legacy_rule_match 影响
+		r.kind = ruleExact
+		r.domain = strings.TrimPrefix(r.domain, "=")
+	} else if strings.HasPrefix(r.domain, "*.") {
+		r.kind = ruleWildcard
+		r.domain = strings.TrimPrefix(r.domain, "*.")
+	}
+	if r.domain == "" {
+		return nil, fmt.Errorf("规则 %q 中的域名为空", rule)
+	}
+	if strings.Contains(r.domain, "*") {
+		return nil, fmt.Errorf("规则 %q 中的通配符 * 只能用于开头（例如 *.example.com）", rule)
+	}
+	name, err := normalizeServerName(r.domain) // 与 SNI 域名一样规范化，规则可以写成 Unicode 或 punycode 形式
+	if err != nil {
+		return nil, fmt.Errorf("规则 %q 中的域名无效: %v", rule, err)
+	}
+	r.domain = name
+	return r, nil
+}
+
+// 解析规则中指定的转发目标
+func (r *forwardRule) parseTarget(target string, hasTarget bool, defaultPort int) (*forwardRule, error) {
+	if !hasTarget {
+		return r, nil
+	}
+	for _, t := range strings.Split(target, ",") {
+		t, err := parseHostPort(t, defaultPort)
+		if err != nil {
+			return nil, fmt.Errorf("规则 %q 中的%v", r.raw, err)
+		}
+		r.targets = append(r.targets, t)
+	}
+	return r, nil
+}
+
+// 是否为逗号分隔的转发目标 IP[:端口] 或 域名[:端口]（只包含域名和 IP 中可能出现的字符，用于区分正则表达式中的 =）
+func isTargetList(s string) bool {
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		host, port, err := net.SplitHostPort(t)
+		if err != nil {
+			host, port = strings.Trim(t, "[]"), ""
+		}
+		if host == "" || strings.Trim(port, "0123456789") != "" {
+			return false
+		}
+		if net.ParseIP(host) != nil {
+			continue
+		}
+		for _, c := range host {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// 解析转发目标 IP[:端口] 或 域名[:端口]（省略端口时使用 defaultPort）
+func parseHostPort(target string, defaultPort int) (string, error) {
+	target = strings.TrimSpace(target)
+	host, port, err := net.SplitHostPort(target)
+	if err != nil { // 未指定端口（或为不带方括号的 IPv6 地址）
+		host, port = strings.Trim(target, "[]"), strconv.Itoa(defaultPort)
+	}
+	if host == "" {
+		return "", errors.New("转发目标为空")
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return "", fmt.Errorf("转发目标端口无效: %s", port)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// SNI 域名是否匹配该规则（serverName 需为 normalizeServerName 规范化后的域名）
+func (r *forwardRule) match(serverName string) bool {
+	switch r.kind {
+	case ruleRegex:
+		return r.regex.MatchString(serverName)
+	case ruleWildcard: // SNI 域名是 Rule 白名单域名的子域名（一级或多级）时匹配（例如 www.aa.com 和 a.b.aa.com 匹配 *.aa.com，但 aa.com 不匹配）
+		return strings.HasSuffix(serverName, "."+r.domain)
+	case ruleExact: // SNI 域名等于 Rule 白名单域名时才匹配（例如 aa.com 匹配 =aa.com，但 www.aa.com 不匹配）
+		return serverName == r.domain
+	case ruleContains: // SNI 域名中包含 Rule 白名单域名即匹配（例如 www.aa.com 和 aa.com.evil.net 中都包含 aa.com）
+		return strings.Contains(serverName, r.domain)
+	default: // SNI 域名等于 Rule 白名单域名或是其子域名时匹配（例如 aa.com 和 www.aa.com 匹配 aa.com，但 notaa.com 不匹配）
+		return serverName == r.domain || strings.HasSuffix(serverName, "."+r.domain)
+	}
+}
+
+// 返回 rules 中第一个匹配 SNI 域名的规则（按配置中的顺序，靠前的规则优先），没有匹配时返回 nil
+// 多个规则都匹配时只使用第一个（例如 a.example.com 写在 example.com 之前时，a.example.com 使用自己的转发目标）
+func firstMatch(rules []*forwardRule, serverName string) *forwardRule {
+	for _, rule := range rules {
+		if rule.match(serverName) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// 获取该规则匹配后的转发目标（轮询时从下一个目标开始），规则中指定了端口时使用该端口而不是 defaultPort
+func (r *forwardRule) targetsFor(serverName string, defaultPort int, loadBalance string) []string {
+	if loadBalance == loadBalanceRoundRobin && len(r.targets) > 1 {
+		return rotate(r.targets, r.next.Add(1)-1)
+	}
+	if len(r.targets) > 0 {
+		return r.targets
+	}
+	if r.port != 0 {
+		defaultPort = r.port
+	}
+	return []string{net.JoinHostPort(serverName, strconv.Itoa(defaultPort))}
+}
+
+var hostCounters sync.Map // 轮询规则中目标域名解析到的 IP 时使用的计数器（域名 => *atomic.Uint64）
+
+// 获取目标域名的下一个轮询位置
+func nextHostIndex(host string) uint64 {
+	c, ok := hostCounters.Load(host)
+	if !ok {
+		c, _ = hostCounters.LoadOrStore(host, new(atomic.Uint64))
+	}
+	return c.(*atomic.Uint64).Add(1) - 1
+}
+
+// 返回从第 n 个（取余）元素开始的副本，原来在前面的元素移到末尾
+func rotate[T any](s []T, n uint64) []T {
+	i := int(n % uint64(len(s)))
+	return append(append(make([]T, 0, len(s)), s[i:]...), s[:i]...)
+}
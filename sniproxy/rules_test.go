@@ -0,0 +1,107 @@
+package sniproxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		rule    string
+		kind    ruleKind
+		domain  string
+		targets []string
+		port    int
+	}{
+		{rule: "example.com", kind: ruleSuffix, domain: "example.com"},
+		{rule: "Example.COM.", kind: ruleSuffix, domain: "example.com"},
+		{rule: "example.com=1.2.3.4", kind: ruleSuffix, domain: "example.com", targets: []string{"1.2.3.4:443"}},
+		{rule: "example.com=10.0.0.1:8443, 10.0.0.2", kind: ruleSuffix, domain: "example.com", targets: []string{"10.0.0.1:8443", "10.0.0.2:443"}},
+		{rule: "example.com=[2001:db8::1]:8443", kind: ruleSuffix, domain: "example.com", targets: []string{"[2001:db8::1]:8443"}},
+		{rule: "example.com:8443", kind: ruleSuffix, domain: "example.com", port: 8443},
+		{rule: "example.com:8443=backend.internal", kind: ruleSuffix, domain: "example.com", targets: []string{"backend.internal:8443"}, port: 8443},
+		{rule: "*.example.com", kind: ruleWildcard, domain: "example.com"},
+		{rule: "=example.com", kind: ruleExact, domain: "example.com"},
+		{rule: "=example.com=1.2.3.4:443", kind: ruleExact, domain: "example.com", targets: []string{"1.2.3.4:443"}},
+		{rule: "=example.com:8443=1.2.3.4", kind: ruleExact, domain: "example.com", targets: []string{"1.2.3.4:8443"}, port: 8443},
+		{rule: "bücher.example", kind: ruleSuffix, domain: "xn--bcher-kva.example"},
+		{rule: `~^cdn\d+\.example\.com$`, kind: ruleRegex, domain: `~^cdn\d+\.example\.com$`},
+		{rule: `~^cdn\d+\.example\.com$=1.2.3.4`, kind: ruleRegex, domain: `~^cdn\d+\.example\.com$`, targets: []string{"1.2.3.4:443"}},
+		{rule: `~^a=b\.example\.com$`, kind: ruleRegex, domain: `~^a=b\.example\.com$`},
+		{rule: `~^a=b\.example\.com$=1.2.3.4:8443`, kind: ruleRegex, domain: `~^a=b\.example\.com$`, targets: []string{"1.2.3.4:8443"}},
+		{rule: `~^(?:a|b)\.example\.com$`, kind: ruleRegex, domain: `~^(?:a|b)\.example\.com$`},
+		{rule: `~^(?:a|b)\.example\.com$:8443`, kind: ruleRegex, domain: `~^(?:a|b)\.example\.com$`, port: 8443},
+		{rule: `~^(?:a|b)\.example\.com$:8443=backend`, kind: ruleRegex, domain: `~^(?:a|b)\.example\.com$`, targets: []string{"backend:8443"}, port: 8443},
+	}
+	for _, tt := range tests {
+		r, err := parseRule(tt.rule, 443, false)
+		if err != nil {
+			t.Errorf("parseRule(%q) 出错: %v", tt.rule, err)
+			continue
+		}
+		if r.kind != tt.kind || r.domain != tt.domain || r.port != tt.port || !reflect.DeepEqual(r.targets, tt.targets) {
+			t.Errorf("parseRule(%q) = kind %d, domain %q, targets %q, port %d; 期望 kind %d, domain %q, targets %q, port %d",
+				tt.rule, r.kind, r.domain, r.targets, r.port, tt.kind, tt.domain, tt.targets, tt.port)
+		}
+	}
+}
+
+func TestParseRuleInvalid(t *testing.T) {
+	for _, rule := range []string{
+		"",
+		"=",
+		"*.",
+		"a.*.example.com",
+		"example.com:0",
+		"example.com:99999",
+		"example.com=",
+		"example.com=1.2.3.4:0",
+		"~^(example",
+	} {
+		if r, err := parseRule(rule, 443, false); err == nil {
+			t.Errorf("parseRule(%q) = %+v, 期望出错", rule, r)
+		}
+	}
+}
+
+func TestFirstMatch(t *testing.T) {
+	var rules []*forwardRule
+	for _, rule := range []string{
+		"a.example.com=10.0.0.1",
+		"example.com=10.0.0.2",
+		"*.example.com=10.0.0.3",
+		"=example.net=10.0.0.4",
+		`~^cdn\d+\.example\.net$=10.0.0.5`,
+		"example.net=10.0.0.6",
+	} {
+		r, err := parseRule(rule, 443, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, r)
+	}
+	tests := []struct {
+		serverName string
+		target     string // 为空表示没有匹配的规则
+	}{
+		{"a.example.com", "10.0.0.1:443"},
+		{"x.a.example.com", "10.0.0.1:443"},
+		{"example.com", "10.0.0.2:443"},
+		{"b.example.com", "10.0.0.2:443"}, // *.example.com 也匹配，但写在后面
+		{"example.net", "10.0.0.4:443"},
+		{"cdn1.example.net", "10.0.0.5:443"},
+		{"www.example.net", "10.0.0.6:443"},
+		{"example.org", ""},
+		{"notexample.com", ""},
+	}
+	for _, tt := range tests {
+		r := firstMatch(rules, tt.serverName)
+		got := ""
+		if r != nil {
+			got = r.targets[0]
+		}
+		if got != tt.target {
+			t.Errorf("firstMatch(%q) 的目标为 %q, 期望 %q", tt.serverName, got, tt.target)
+		}
+	}
+}
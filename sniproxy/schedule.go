@@ -0,0 +1,115 @@
+package sniproxy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 允许转发的时间段（schedule，未配置时任何时间都允许）
+type ScheduleConfig struct {
+	Timezone string           `yaml:"timezone,omitempty"` // 时区（例如 Asia/Shanghai，默认为系统时区）
+	Windows  []ScheduleWindow `yaml:"windows,omitempty"`  // 允许的时间段（任意一个时间段内即允许）
+}
+
+// 一个时间段
+type ScheduleWindow struct {
+	Days  []string `yaml:"days,omitempty"`  // 星期几（mon、tue、wed、thu、fri、sat、sun，默认每天）
+	Start string   `yaml:"start,omitempty"` // 开始时间（HH:MM）
+	End   string   `yaml:"end,omitempty"`   // 结束时间（HH:MM，不包括，可以是 24:00；早于开始时间时为跨过午夜，例如 22:00-06:00）
+}
+
+// 解析后的 schedule
+type schedule struct {
+	location *time.Location
+	windows  []timeWindow
+}
+
+// 解析后的时间段（一天中的分钟数）
+type timeWindow struct {
+	days       [7]bool // 按 time.Weekday 的顺序（0 为星期日）
+	start, end int
+}
+
+var weekdays = map[string]time.Weekday{"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday}
+
+// 解析 schedule（未配置时返回空）
+func parseSchedule(c *ScheduleConfig) (*schedule, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if len(c.Windows) == 0 {
+		return nil, errors.New("windows 不能为空")
+	}
+	s := &schedule{location: time.Local}
+	if c.Timezone != "" {
+		loc, err := time.LoadLocation(c.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("timezone 无效: %v", err)
+		}
+		s.location = loc
+	}
+	for i, w := range c.Windows {
+		var tw timeWindow
+		var err error
+		if tw.start, err = parseClock(w.Start); err != nil || tw.start == 24*60 {
+			return nil, fmt.Errorf("windows[%d] 的 start 无效: %q（格式为 HH:MM）", i, w.Start)
+		}
+		if tw.end, err = parseClock(w.End); err != nil || tw.end == tw.start {
+			return nil, fmt.Errorf("windows[%d] 的 end 无效: %q（格式为 HH:MM，不能与 start 相同）", i, w.End)
+		}
+		for _, day := range w.Days {
+			d, ok := weekdays[strings.ToLower(strings.TrimSpace(day))]
+			if !ok {
+				return nil, fmt.Errorf("windows[%d] 的 days 无效: %q（可选 mon、tue、wed、thu、fri、sat、sun）", i, day)
+			}
+			tw.days[d] = true
+		}
+		if len(w.Days) == 0 {
+			tw.days = [7]bool{true, true, true, true, true, true, true}
+		}
+		s.windows = append(s.windows, tw)
+	}
+	return s, nil
+}
+
+// 解析 HH:MM 格式的时间，返回一天中的分钟数（允许 24:00）
+func parseClock(s string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil {
+		return 0, err
+	}
+	if h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, errors.New("超出范围")
+	}
+	return h*60 + m, nil
+}
+
+// 该时间是否在允许的时间段内（每个连接都重新判断，修改系统时间、重载配置后立即生效）
+func (s *schedule) open(now time.Time) bool {
+	if s == nil {
+		return true
+	}
+	t := now.In(s.location)
+	minute, day := t.Hour()*60+t.Minute(), t.Weekday()
+	yesterday := (day + 6) % 7
+	for _, w := range s.windows {
+		if w.start < w.end {
+			if w.days[day] && minute >= w.start && minute < w.end {
+				return true
+			}
+		} else if w.days[day] && minute >= w.start || w.days[yesterday] && minute < w.end { // 跨过午夜的时间段属于开始的那一天
+			return true
+		}
+	}
+	return false
+}
+
+// 检查当前时间是否允许转发，返回拒绝的原因（允许时为空）
+func checkSchedule(cfg *Config) string {
+	if now := time.Now(); !cfg.schedule.open(now) {
+		return fmt.Sprintf("当前时间 %s 不在 schedule 允许的时间段内", now.In(cfg.schedule.location).Format("Mon 15:04 MST"))
+	}
+	return ""
+}
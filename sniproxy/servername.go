@@ -0,0 +1,87 @@
+package sniproxy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// 规范化域名时使用的 IDNA 配置（UTS #46 查询时的映射和检查），允许下划线（部分内部服务的域名中会有，由 checkDNSName 检查）
+var serverNameProfile = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.StrictDomainName(false))
+
+// 规范化域名（SNI 域名、HTTP Host，以及规则、blocked_hosts、hosts 中的域名）：按 UTS #46 映射（转为小写、全角字符转为半角等）、去掉末尾的点，
+// 国际化域名（IDN）的 Unicode 标签转为 punycode（xn--...），已经是 xn-- 开头的标签需要是有效且规范的 punycode
+// 这样规则无论写成 Unicode 还是 ASCII 形式都能一致地匹配，也避免通过不同的编码绕过规则；规范化后的域名需要符合 DNS 域名规则
+func normalizeServerName(name string) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", errors.New("不是有效的 UTF-8 字符串")
+	}
+	for _, label := range strings.FieldsFunc(name, isLabelSeparator) {
+		if len(label) >= 4 && strings.EqualFold(label[:4], "xn--") { // 例如 xn--abc- 会被解码为 abc，同一个域名只接受一种写法
+			if ascii, err := serverNameProfile.ToASCII(label); err != nil || ascii != strings.ToLower(label) {
+				return "", fmt.Errorf("标签 %q 不是有效的 punycode", label)
+			}
+		}
+	}
+	ascii, err := serverNameProfile.ToASCII(name)
+	if err != nil {
+		return "", fmt.Errorf("不是有效的国际化域名: %v", err)
+	}
+	name = strings.TrimSuffix(ascii, ".")
+	if name == "" {
+		return "", errors.New("域名为空")
+	}
+	if err := checkDNSName(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// 是否为域名中的标签分隔符（点，以及 UTS #46 中等同于点的中文句号、全角句点、半角句号）
+func isLabelSeparator(r rune) bool {
+	return r == '.' || r == '。' || r == '．' || r == '｡'
+}
+
+const (
+	maxDNSNameLength  = 253 // 域名的最大长度（不包括末尾的点）
+	maxDNSLabelLength = 63  // 每个标签的最大长度
+)
+
+// 检查规范化后的域名是否符合 DNS 域名规则：总长度不超过 253、每个标签 1-63 个字符，
+// 只包含字母、数字、连字符（不能在标签开头或结尾）和下划线（部分内部服务的域名中会有），
+// 避免控制字符、空字符、超长字符串等通过 SNI 域名进入日志和目标连接
+func checkDNSName(name string) error {
+	if len(name) > maxDNSNameLength {
+		return fmt.Errorf("域名长度 %d 超过 %d", len(name), maxDNSNameLength)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			return errors.New("域名中有空标签")
+		}
+		if len(label) > maxDNSLabelLength {
+			return fmt.Errorf("标签长度 %d 超过 %d", len(label), maxDNSLabelLength)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("标签 %q 不能以连字符开头或结尾", label)
+		}
+		for i := 0; i < len(label); i++ {
+			if c := label[i]; !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return fmt.Errorf("域名中有无效字符 %q", c)
+			}
+		}
+	}
+	return nil
+}
+
+const maxLoggedNameLength = 300 // 日志中无效域名的最大长度（超过时截断）
+
+// 日志中输出的无效域名（加上引号并转义控制字符等，过长时截断）
+func quoteInvalidName(name string) string {
+	if len(name) > maxLoggedNameLength {
+		return fmt.Sprintf("%q...（共 %d 字节）", name[:maxLoggedNameLength], len(name))
+	}
+	return fmt.Sprintf("%q", name)
+}
@@ -0,0 +1,87 @@
+package sniproxy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeServerName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"example.com", "example.com"},
+		{"Example.COM", "example.com"},
+		{"example.com.", "example.com"},
+		{"EXAMPLE.com.", "example.com"},
+		{"ＥＸＡＭＰＬＥ.com", "example.com"},
+		{"example。com", "example.com"},
+		{"a_b.example.com", "a_b.example.com"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"Bücher.Example.", "xn--bcher-kva.example"},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example"},
+		{"XN--BCHER-KVA.example", "xn--bcher-kva.example"},
+		{"例子。测试", "xn--fsqu00a.xn--0zwm56d"},
+		{"xn--fsqu00a.xn--0zwm56d", "xn--fsqu00a.xn--0zwm56d"},
+	}
+	for _, tt := range tests {
+		got, err := normalizeServerName(tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeServerName(%q) = %q, %v; 期望 %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// 规则和 SNI 域名分别写成 Unicode 或 punycode 形式时规范化结果相同，可以互相匹配
+func TestNormalizeServerNameRuleMatch(t *testing.T) {
+	tests := []struct {
+		rule, sni string
+	}{
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"xn--bcher-kva.example", "bücher.example"},
+		{"xn--bcher-kva.example", "www.BÜCHER.example."},
+		{"*.bücher.example", "a.xn--bcher-kva.example"},
+		{"=例子.测试", "xn--fsqu00a.xn--0zwm56d"},
+		{"Example.COM", "www.example.com."},
+	}
+	for _, tt := range tests {
+		r, err := parseRule(tt.rule, 443, false)
+		if err != nil {
+			t.Fatalf("parseRule(%q) 出错: %v", tt.rule, err)
+		}
+		name, err := normalizeServerName(tt.sni)
+		if err != nil {
+			t.Fatalf("normalizeServerName(%q) 出错: %v", tt.sni, err)
+		}
+		if !r.match(name) {
+			t.Errorf("规则 %q 不匹配 SNI 域名 %q（规范化后为 %q）", tt.rule, tt.sni, name)
+		}
+	}
+}
+
+func TestNormalizeServerNameInvalid(t *testing.T) {
+	for _, name := range []string{
+		"",
+		".",
+		"a..b",
+		"-a.example",
+		"a-.example",
+		"ex ample.com",
+		"a\x00b.example",
+		"a\nb.example",
+		"\xff.example",
+		"xn--a.example",          // 解码后为控制字符
+		"xn--zz.example",         // 无效的 punycode
+		"xn--.example",           // 空的 punycode
+		"xn--abc-.example",       // 解码后为 ASCII（与 abc.example 是同一个域名的另一种写法）
+		"xn--bcher-kva-.example", // 不规范的 punycode
+		"XN--ABC-.example",
+		"xn--abc-。example",
+		strings.Repeat("a", 64) + ".example",
+		strings.Repeat("a.", 127) + "example",
+	} {
+		if got, err := normalizeServerName(name); err == nil {
+			t.Errorf("normalizeServerName(%q) = %q, 期望出错", name, got)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package sniproxy
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// 出站连接的 socket 选项（在连接目标前设置），没有需要设置的选项时返回 nil
+func outboundControl(cfg *Config) func(network, address string, c syscall.RawConn) error {
+	if cfg.OutboundInterface == "" && cfg.SoMark == 0 && cfg.DSCP == 0 {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var opErr error
+		err := c.Control(func(fd uintptr) {
+			if cfg.OutboundInterface != "" {
+				opErr = bindToDevice(fd, cfg.OutboundInterface)
+			}
+			if cfg.SoMark != 0 && opErr == nil {
+				opErr = setMark(fd, cfg.SoMark)
+			}
+			if cfg.DSCP != 0 && opErr == nil {
+				opErr = setDSCP(fd, cfg.DSCP)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return opErr
+	}
+}
+
+// 设置客户端连接的 DSCP（dscp_client，标记发送给客户端的数据），不是 TCP 连接时（例如 Unix socket）忽略
+func setConnDSCP(c net.Conn, dscp int) error {
+	tc, ok := unwrapTCPConn(c)
+	if !ok {
+		return nil
+	}
+	raw, err := tc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var opErr error
+	if err := raw.Control(func(fd uintptr) {
+		opErr = setDSCP(fd, dscp)
+	}); err != nil {
+		return err
+	}
+	return opErr
+}
+
+// 检查 outbound_addr 是否为本机地址
+func checkLocalAddr(ip net.IP) error {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return fmt.Errorf("获取本机地址失败: %v", err)
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s 不是本机地址", ip)
+}
@@ -0,0 +1,66 @@
+package sniproxy
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// 将连接绑定到指定网卡（SO_BINDTODEVICE，需要 root 或 CAP_NET_RAW 权限）
+func bindToDevice(fd uintptr, iface string) error {
+	if err := syscall.BindToDevice(int(fd), iface); err != nil {
+		return fmt.Errorf("绑定网卡 %s 失败: %v", iface, err)
+	}
+	return nil
+}
+
+// 设置连接的 fwmark（SO_MARK，用于策略路由，需要 root 或 CAP_NET_ADMIN 权限）
+func setMark(fd uintptr, mark int) error {
+	if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, mark); err != nil {
+		return fmt.Errorf("设置 SO_MARK %d 失败: %v", mark, err)
+	}
+	return nil
+}
+
+// 设置连接的 DSCP（IPv4 为 IP_TOS，IPv6 为 IPV6_TCLASS，值为 DSCP 左移 2 位，低 2 位 ECN 不设置）
+// IPv6 socket 同时设置 IP_TOS，用于其中的 IPv4 映射地址连接（忽略该项的错误）
+func setDSCP(fd uintptr, dscp int) error {
+	tos := dscp << 2
+	domain, err := unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_DOMAIN)
+	if err == nil && domain == unix.AF_INET6 {
+		unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, tos)
+		err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tos)
+	} else {
+		err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, tos)
+	}
+	if err != nil {
+		return fmt.Errorf("设置 DSCP %d 失败: %v", dscp, err)
+	}
+	return nil
+}
+
+// 允许多个进程监听同一个地址（SO_REUSEPORT，内核在这些进程之间分配新连接）
+func setReusePort(fd uintptr) error {
+	if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+		return fmt.Errorf("设置 SO_REUSEPORT 失败: %v", err)
+	}
+	return nil
+}
+
+// 获取连接实际的接收、发送缓冲区大小（SO_RCVBUF、SO_SNDBUF）
+func socketBufferSizes(c *net.TCPConn) (rcv, snd int, ok bool) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return 0, 0, false
+	}
+	var err1, err2 error
+	if err := raw.Control(func(fd uintptr) {
+		rcv, err1 = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUF)
+		snd, err2 = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_SNDBUF)
+	}); err != nil || err1 != nil || err2 != nil {
+		return 0, 0, false
+	}
+	return rcv, snd, true
+}
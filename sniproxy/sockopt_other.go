@@ -0,0 +1,33 @@
+//go:build !linux
+
+package sniproxy
+
+import (
+	"errors"
+	"net"
+)
+
+// 将连接绑定到指定网卡（仅支持 Linux）
+func bindToDevice(fd uintptr, iface string) error {
+	return errors.New("outbound_interface 仅支持 Linux 系统")
+}
+
+// 设置连接的 fwmark（仅支持 Linux）
+func setMark(fd uintptr, mark int) error {
+	return errors.New("so_mark 仅支持 Linux 系统")
+}
+
+// 设置连接的 DSCP（仅支持 Linux）
+func setDSCP(fd uintptr, dscp int) error {
+	return errors.New("dscp 仅支持 Linux 系统")
+}
+
+// 允许多个进程监听同一个地址（仅支持 Linux）
+func setReusePort(fd uintptr) error {
+	return errors.New("reuse_port 仅支持 Linux 系统")
+}
+
+// 获取连接实际的缓冲区大小（仅支持 Linux）
+func socketBufferSizes(c *net.TCPConn) (rcv, snd int, ok bool) {
+	return 0, 0, false
+}
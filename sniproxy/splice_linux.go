@@ -0,0 +1,67 @@
+package sniproxy
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// 获取可以使用 splice 零拷贝转发的 TCP 连接（PROXY protocol 包装的连接也可以，只是替换了地址）
+func spliceConns(a, b net.Conn) (*net.TCPConn, *net.TCPConn, bool) {
+	ta, ok1 := unwrapTCPConn(a)
+	tb, ok2 := unwrapTCPConn(b)
+	return ta, tb, ok1 && ok2
+}
+
+// 从内核获取两个连接中距离上次收到数据最近的时间（TCP_INFO 中的 tcpi_last_data_recv）
+func lastDataRecvProbe(a, b *net.TCPConn) func() (time.Duration, bool) {
+	return func() (time.Duration, bool) {
+		da, ok1 := lastDataRecv(a)
+		db, ok2 := lastDataRecv(b)
+		if !ok1 || !ok2 {
+			return 0, false
+		}
+		if db < da {
+			return db, true
+		}
+		return da, true
+	}
+}
+
+// 距离该连接上次收到数据的时间
+func lastDataRecv(c *net.TCPConn) (time.Duration, bool) {
+	info, ok := tcpInfo(c)
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(info.Last_data_recv) * time.Millisecond, true
+}
+
+// 从内核获取已发送给目标连接 dst、客户端连接 src 并被确认的字节数（TCP_INFO 中的 tcpi_bytes_acked），即上行、下行字节数
+func bytesSentProbe(src, dst *net.TCPConn) func() (up, down int64, ok bool) {
+	return func() (int64, int64, bool) {
+		si, ok1 := tcpInfo(src)
+		di, ok2 := tcpInfo(dst)
+		if !ok1 || !ok2 {
+			return 0, 0, false
+		}
+		return int64(di.Bytes_acked), int64(si.Bytes_acked), true
+	}
+}
+
+// 获取连接的 TCP_INFO
+func tcpInfo(c *net.TCPConn) (*unix.TCPInfo, bool) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return nil, false
+	}
+	var info *unix.TCPInfo
+	var opErr error
+	if err := raw.Control(func(fd uintptr) {
+		info, opErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	}); err != nil || opErr != nil {
+		return nil, false
+	}
+	return info, true
+}
@@ -0,0 +1,97 @@
+package sniproxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// 建立一对本机 TCP 连接
+func tcpPair(tb testing.TB) (*net.TCPConn, *net.TCPConn) {
+	tb.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer l.Close()
+	a, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	b, err := l.Accept()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return a.(*net.TCPConn), b.(*net.TCPConn)
+}
+
+// 不是 *net.TCPConn 的连接（copyData 不能使用 splice，只能经过用户态缓冲区 io.CopyBuffer 复制）
+type plainConn struct {
+	net.Conn
+}
+
+// 本进程使用的 CPU 时间（用户态 + 内核态）
+func processCPUTime() time.Duration {
+	var ru unix.Rusage
+	unix.Getrusage(unix.RUSAGE_SELF, &ru)
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// 比较 splice 与 io.CopyBuffer 转发一个连接（单向 4 MiB）的耗时和 CPU 时间（cpu-ns/op，包括测试中收发数据的开销，两者相同）
+func BenchmarkCopyData(b *testing.B) {
+	const size = 4 << 20
+	data := bytes.Repeat([]byte("0123456789abcdef"), size/16)
+	for _, bc := range []struct {
+		name string
+		wrap func(net.Conn) net.Conn
+	}{
+		{"splice", func(c net.Conn) net.Conn { return c }},
+		{"copy_buffer", func(c net.Conn) net.Conn { return plainConn{c} }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.SetBytes(size)
+			b.ReportAllocs()
+			var cpu time.Duration
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				client, src := tcpPair(b) // 客户端 => 本服务
+				dst, server := tcpPair(b) // 本服务 => 目标
+				var wg sync.WaitGroup
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					client.Write(data)
+					client.CloseWrite()
+				}()
+				go func() {
+					defer wg.Done()
+					io.Copy(io.Discard, server)
+				}()
+				start := processCPUTime()
+				b.StartTimer()
+
+				var counter atomic.Int64
+				n, err := copyData(bc.wrap(dst), bc.wrap(src), nil, defaultCopyBufferSize, &counter)
+				dst.CloseWrite()
+				wg.Wait()
+
+				b.StopTimer()
+				cpu += processCPUTime() - start
+				if err != nil || n != size || counter.Load() != size {
+					b.Fatalf("转发了 %d 字节（计数 %d）, 出错: %v", n, counter.Load(), err)
+				}
+				for _, c := range []net.Conn{client, src, dst, server} {
+					c.Close()
+				}
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(cpu.Nanoseconds())/float64(b.N), "cpu-ns/op")
+		})
+	}
+}
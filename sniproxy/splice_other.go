@@ -0,0 +1,23 @@
+//go:build !linux
+
+package sniproxy
+
+import (
+	"net"
+	"time"
+)
+
+// 非 Linux 系统不使用 splice，数据经过用户态缓冲区复制
+func spliceConns(a, b net.Conn) (*net.TCPConn, *net.TCPConn, bool) {
+	return nil, nil, false
+}
+
+// 仅用于 Linux
+func lastDataRecvProbe(a, b *net.TCPConn) func() (time.Duration, bool) {
+	return nil
+}
+
+// 仅用于 Linux
+func bytesSentProbe(src, dst *net.TCPConn) func() (up, down int64, ok bool) {
+	return nil
+}
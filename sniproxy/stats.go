@@ -0,0 +1,132 @@
+package sniproxy
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 输出运行状态快照：活动连接数、已处理的连接总数、转发的字节数、goroutine 数量、各规则的匹配次数
+// 除活动连接数外均来自 Prometheus 指标（未配置 metrics_addr 时也会统计，同一进程中的多个实例共用）
+func (p *Proxy) LogStats() {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		p.serviceLogger(fmt.Sprintf("获取运行状态失败: %v", err), LevelError)
+		return
+	}
+	var total, up, down float64
+	matches := make(map[string]float64)
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			switch family.GetName() {
+			case "sniproxy_connections_total":
+				total = m.GetCounter().GetValue()
+			case "sniproxy_bytes_forwarded_total":
+				if labels["direction"] == "upstream" {
+					up = m.GetCounter().GetValue()
+				} else {
+					down = m.GetCounter().GetValue()
+				}
+			case "sniproxy_rule_matches_total":
+				matches[labels["rule"]] = m.GetCounter().GetValue()
+			}
+		}
+	}
+	p.serviceLogger(fmt.Sprintf("运行状态: 活动连接 %d 个, 已处理连接 %.0f 个, 上行 %.0f 字节, 下行 %.0f 字节, goroutine %d 个",
+		p.conns.count(), total, up, down, runtime.NumGoroutine()), LevelInfo)
+	rules := make([]string, 0, len(matches))
+	for rule := range matches {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	for _, rule := range rules {
+		p.serviceLogger(fmt.Sprintf("规则匹配次数: %s => %.0f", rule, matches[rule]), LevelInfo)
+	}
+}
+
+const (
+	summaryTopSNIs = 10    // 退出时输出连接数最多的前几个 SNI 域名
+	maxSummarySNIs = 10000 // 最多统计的不同 SNI 域名数量（超过后新的域名计入 "其它"，避免内存无限增长）
+)
+
+// 本实例运行期间的累计统计（退出时输出运行总结），与 Prometheus 指标不同，只统计本实例
+type runSummary struct {
+	start       time.Time
+	connections atomic.Int64 // 处理的连接总数（包括被拒绝的连接和 QUIC 会话）
+	bytesUp     atomic.Int64 // 已结束的连接转发的上行字节数
+	bytesDown   atomic.Int64
+	active      atomic.Int64 // 当前 TCP 连接数
+	peak        atomic.Int64 // 最高同时 TCP 连接数
+
+	mu   sync.Mutex
+	snis map[string]int64 // SNI 域名 => 连接数
+}
+
+// 新连接（包括 QUIC 会话）
+func (s *runSummary) accepted() {
+	s.connections.Add(1)
+}
+
+// 开始处理 TCP 连接，更新最高同时连接数
+func (s *runSummary) enter() {
+	n := s.active.Add(1)
+	for peak := s.peak.Load(); n > peak && !s.peak.CompareAndSwap(peak, n); peak = s.peak.Load() {
+	}
+}
+
+// TCP 连接处理结束
+func (s *runSummary) leave() {
+	s.active.Add(-1)
+}
+
+// 获得 SNI 域名（规范化后）
+func (s *runSummary) sni(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.snis[name]; !ok && len(s.snis) >= maxSummarySNIs {
+		name = "其它"
+	}
+	s.snis[name]++
+}
+
+// 转发结束，累计转发的字节数
+func (s *runSummary) forwarded(up, down int64) {
+	s.bytesUp.Add(up)
+	s.bytesDown.Add(down)
+}
+
+// 输出运行总结：运行时长、处理的连接总数、转发的字节数、最高同时连接数、连接数最多的 SNI 域名（退出时调用）
+func (p *Proxy) logSummary() {
+	s := p.summary
+	p.serviceLogger(fmt.Sprintf("运行总结: 运行 %v, 处理连接 %d 个, 上行 %d 字节, 下行 %d 字节, 最高同时连接 %d 个",
+		time.Since(s.start).Round(time.Second), s.connections.Load(), s.bytesUp.Load(), s.bytesDown.Load(), s.peak.Load()), LevelInfo)
+	s.mu.Lock()
+	counts := make(map[string]int64, len(s.snis))
+	names := make([]string, 0, len(s.snis))
+	for name, n := range s.snis {
+		counts[name] = n
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	sort.Slice(names, func(i, j int) bool { // 连接数相同时按域名排序
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > summaryTopSNIs {
+		names = names[:summaryTopSNIs]
+	}
+	for i, name := range names {
+		p.serviceLogger(fmt.Sprintf("连接数最多的 SNI 域名 #%d: %s => %d 个连接", i+1, name, counts[name]), LevelInfo)
+	}
+}
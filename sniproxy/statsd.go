@@ -0,0 +1,76 @@
+package sniproxy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultStatsdPrefix = "sniproxy" // 默认的 StatsD 指标名前缀（statsd_prefix）
+
+// 推送指标到 StatsD（statsd_addr，UDP，尽力而为：发送失败的指标直接丢弃），与 Prometheus 指标同时统计
+// 发送的指标（前缀默认为 sniproxy）：
+// sniproxy.connections                已接受的连接数（counter，包括 QUIC 会话）
+// sniproxy.bytes.upstream/downstream  转发的字节数（counter，连接结束时发送）
+// sniproxy.dial_failures              连接目标失败的次数（counter）
+// sniproxy.connection.duration        连接时长（timer，毫秒，连接结束时发送）
+type statsdClient struct {
+	mu       sync.Mutex
+	addr     string
+	conn     net.Conn
+	reported bool // 连接失败是否已经记录过日志（同一个地址只记录一次）
+}
+
+var serviceStatsd = &statsdClient{}
+
+// 生成计数指标（name:n|c）
+func statsdCount(name string, n int64) string {
+	return fmt.Sprintf("%s:%d|c", name, n)
+}
+
+// 生成时长指标（name:毫秒|ms）
+func statsdTiming(name string, d time.Duration) string {
+	return fmt.Sprintf("%s:%d|ms", name, d.Milliseconds())
+}
+
+// 发送指标（未配置 statsd_addr 时直接返回），同一次调用的多个指标合并为一个数据包
+func (p *Proxy) statsd(cfg *Config, metrics ...string) {
+	if cfg.StatsdAddr == "" {
+		return
+	}
+	lines := make([]string, len(metrics))
+	for i, m := range metrics {
+		lines[i] = cfg.StatsdPrefix + "." + m
+	}
+	if err := serviceStatsd.send(cfg.StatsdAddr, strings.Join(lines, "\n")); err != nil {
+		p.serviceLogger(fmt.Sprintf("连接 StatsD 服务器 %s 失败, 不会发送指标: %v", cfg.StatsdAddr, err), LevelWarn)
+	}
+}
+
+// 发送一个数据包（UDP 发送出错时忽略，例如 StatsD 服务器没有运行），只有连接失败时返回错误（同一个地址只返回一次）
+func (c *statsdClient) send(addr, packet string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.addr != addr { // 第一次发送或重载配置后地址改变
+		if c.conn != nil {
+			c.conn.Close()
+			c.conn = nil
+		}
+		c.addr, c.reported = addr, false
+	}
+	if c.conn == nil {
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			if c.reported {
+				return nil
+			}
+			c.reported = true
+			return err
+		}
+		c.conn = conn
+	}
+	c.conn.Write([]byte(packet))
+	return nil
+}
@@ -0,0 +1,226 @@
+package sniproxy
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	syslogTimeout         = 2 * time.Second  // 连接、发送日志到 syslog 服务器的超时时间
+	syslogRetryMin        = time.Second      // 连接失败后第一次重新连接的等待时间（之后每次失败翻倍）
+	syslogRetryMax        = 60 * time.Second // 重新连接的最长等待时间
+	syslogQueueSize       = 1024             // 等待发送的日志队列长度（已满时丢弃新的日志）
+	syslogCloseTimeout    = 2 * time.Second  // 退出时等待发送队列中剩余日志的时间
+	defaultSyslogFacility = "daemon"
+)
+
+// syslog facility 名称对应的值（RFC 5424）
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// 日志级别对应的 syslog severity
+func (l Level) syslogSeverity() int {
+	switch l {
+	case LevelDebug:
+		return 7 // debug
+	case LevelWarn:
+		return 4 // warning
+	case LevelError:
+		return 3 // err
+	default:
+		return 6 // info
+	}
+}
+
+// 解析 syslog_addr（udp://IP:端口、tcp://IP:端口，省略协议时为 UDP），返回协议和地址
+func parseSyslogAddr(addr string) (network, address string, err error) {
+	network, address = "udp", addr
+	if i := strings.Index(addr, "://"); i >= 0 {
+		network, address = strings.ToLower(addr[:i]), addr[i+3:]
+	}
+	if network != "udp" && network != "tcp" {
+		return "", "", fmt.Errorf("不支持的协议 %s（可选 udp、tcp）", network)
+	}
+	if err := checkAddr(address); err != nil {
+		return "", "", err
+	}
+	return network, address, nil
+}
+
+// 发送日志到远程 syslog 服务器（syslog_addr，RFC 5424 格式），与标准输出、日志文件同时输出
+// 日志先放入队列，由一个后台线程依次发送（syslog 服务器缓慢或无法连接时不会阻塞输出日志的连接），队列已满时丢弃并计数
+// TCP 时使用 octet counting 分帧（RFC 6587），连接失败或断开后等待一段时间（逐渐延长）再重新连接，期间的日志直接丢弃
+type syslogWriter struct {
+	start   sync.Once
+	mu      sync.RWMutex // 保护 closed（关闭队列后不能再放入）
+	queue   chan syslogMessage
+	done    chan struct{} // 后台发送线程已退出
+	closed  bool          // 已停止（退出时），之后的日志不再发送
+	dropped atomic.Int64  // 上次恢复以来丢弃的日志行数（从故障中恢复时输出并重置）
+
+	// 以下只在后台发送线程中使用
+	addr      string
+	conn      net.Conn
+	retryAt   time.Time     // 连接失败后在此之前不再尝试连接
+	backoff   time.Duration // 下次连接失败后的等待时间
+	failing   bool          // 是否处于故障中（已输出过错误，恢复后重置）
+	hostname  string
+	processID int
+}
+
+// 等待发送的一行日志
+type syslogMessage struct {
+	addr     string
+	priority int
+	time     time.Time
+	message  string
+}
+
+var serviceSyslog = &syslogWriter{}
+
+// 把一行日志放入发送队列（不等待发送），队列已满或已停止时丢弃
+func (w *syslogWriter) write(cfg *Config, level Level, message string) {
+	msg := syslogMessage{addr: cfg.SyslogAddr, priority: syslogFacilities[cfg.SyslogFacility]*8 + level.syslogSeverity(), time: time.Now(), message: message}
+	w.start.Do(func() {
+		w.queue, w.done = make(chan syslogMessage, syslogQueueSize), make(chan struct{})
+		go w.run()
+	})
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		w.drop()
+		return
+	}
+	select {
+	case w.queue <- msg:
+	default:
+		w.drop()
+	}
+}
+
+// 停止接受新的日志，等待后台发送线程发送完队列中剩余的日志（最多 timeout，退出时调用）
+func (w *syslogWriter) close(timeout time.Duration) {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	w.start.Do(func() {}) // 没有输出过 syslog 日志时不再启动后台发送线程
+	w.mu.Unlock()
+	if w.queue == nil {
+		return
+	}
+	close(w.queue)
+	select {
+	case <-w.done:
+	case <-time.After(timeout):
+		fmt.Printf("发送日志到 syslog 服务器超时（%v），丢弃剩余的 %d 行日志\n", timeout, len(w.queue))
+	}
+}
+
+// 丢弃一行日志
+func (w *syslogWriter) drop() {
+	w.dropped.Add(1)
+	metricSyslogDropped.Inc()
+}
+
+// 后台发送线程：依次发送队列中的日志，队列关闭且发送完后退出
+func (w *syslogWriter) run() {
+	defer close(w.done)
+	for msg := range w.queue {
+		w.send(msg)
+	}
+	w.closeConn()
+}
+
+// 发送一行日志（连接失败或发送失败时丢弃），同一次故障只输出一次错误，恢复后输出丢弃的行数
+// 不能使用 serviceLogger 输出（否则会再次进入 syslog 队列），直接输出到标准输出
+func (w *syslogWriter) send(msg syslogMessage) {
+	if w.addr != msg.addr { // 第一次发送或重载配置后地址改变
+		w.closeConn()
+		w.addr, w.retryAt, w.backoff, w.failing = msg.addr, time.Time{}, 0, false
+	}
+	if w.conn == nil {
+		if time.Now().Before(w.retryAt) {
+			w.drop()
+			return
+		}
+		if err := w.dial(); err != nil {
+			w.fail(fmt.Errorf("连接 syslog 服务器 %s 失败: %v", w.addr, err))
+			return
+		}
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err := w.conn.Write(w.format(msg)); err != nil {
+		w.closeConn()
+		w.fail(fmt.Errorf("发送日志到 syslog 服务器 %s 失败: %v", w.addr, err))
+		return
+	}
+	w.backoff = 0
+	if w.failing {
+		w.failing = false
+		fmt.Printf("发送日志到 syslog 服务器 %s 已恢复（期间丢弃 %d 行日志）\n", w.addr, w.dropped.Swap(0))
+	}
+}
+
+// 连接 syslog 服务器（地址已在加载配置时检查）
+func (w *syslogWriter) dial() error {
+	network, address, err := parseSyslogAddr(w.addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout(network, address, syslogTimeout)
+	if err != nil {
+		return err
+	}
+	if w.hostname == "" {
+		if w.hostname, err = os.Hostname(); err != nil || w.hostname == "" {
+			w.hostname = "-"
+		}
+		w.processID = os.Getpid()
+	}
+	w.conn = conn
+	return nil
+}
+
+// 连接或发送失败（丢弃该行日志），等待一段时间后再重新连接（每次失败等待时间翻倍，最长 syslogRetryMax）
+func (w *syslogWriter) fail(err error) {
+	w.drop()
+	w.backoff *= 2
+	if w.backoff < syslogRetryMin {
+		w.backoff = syslogRetryMin
+	} else if w.backoff > syslogRetryMax {
+		w.backoff = syslogRetryMax
+	}
+	w.retryAt = time.Now().Add(w.backoff)
+	if !w.failing {
+		w.failing = true
+		fmt.Printf("无法发送日志: %v\n", err)
+	}
+}
+
+// 关闭连接
+func (w *syslogWriter) closeConn() {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+// 格式化为 RFC 5424 消息：<PRI>1 时间 主机名 sniproxy 进程ID - - 消息（TCP 时前面加上消息长度）
+func (w *syslogWriter) format(msg syslogMessage) []byte {
+	s := fmt.Sprintf("<%d>1 %s %s sniproxy %d - - \xef\xbb\xbf%s", msg.priority, msg.time.Format("2006-01-02T15:04:05.000000Z07:00"), w.hostname, w.processID, msg.message) // 消息为 UTF-8，以 BOM 开头
+	if _, ok := w.conn.(*net.TCPConn); ok {
+		s = fmt.Sprintf("%d %s", len(s), s)
+	}
+	return []byte(s)
+}
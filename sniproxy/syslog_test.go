@@ -0,0 +1,123 @@
+package sniproxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogWriterUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	w := &syslogWriter{}
+	cfg := &Config{SyslogAddr: "udp://" + conn.LocalAddr().String(), SyslogFacility: "local0"}
+	w.write(cfg, LevelError, "测试消息")
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 2048)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<131>1 ") || !strings.Contains(msg, " sniproxy ") || !strings.HasSuffix(msg, " - - \xef\xbb\xbf测试消息") {
+		t.Errorf("收到的 syslog 消息为 %q", msg)
+	}
+}
+
+// syslog 服务器不读取数据（发送阻塞）时，输出日志不会等待，队列已满的日志被丢弃
+func TestSyslogWriterDoesNotBlock(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close() // 接受连接但不读取
+		}
+	}()
+	w := &syslogWriter{}
+	cfg := &Config{SyslogAddr: "tcp://" + l.Addr().String(), SyslogFacility: "daemon"}
+	line := strings.Repeat("x", 1024)
+	start := time.Now()
+	for i := 0; i < 100000; i++ {
+		w.write(cfg, LevelInfo, line)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("输出 100000 行日志用时 %v", d)
+	}
+	if w.dropped.Load() == 0 {
+		t.Error("发送阻塞时没有丢弃日志")
+	}
+}
+
+// 停止时发送完队列中剩余的日志，之后的日志被丢弃
+func TestSyslogWriterCloseFlushes(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		data, _ := io.ReadAll(c) // 发送完后关闭连接
+		received <- string(data)
+	}()
+	w := &syslogWriter{}
+	cfg := &Config{SyslogAddr: "tcp://" + l.Addr().String(), SyslogFacility: "daemon"}
+	for i := 0; i < 100; i++ {
+		w.write(cfg, LevelInfo, fmt.Sprintf("消息 %d", i))
+	}
+	w.close(syslogCloseTimeout)
+	w.write(cfg, LevelInfo, "停止后的消息")
+
+	var data string
+	select {
+	case data = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("没有收到日志")
+	}
+	var messages []string
+	for data != "" { // octet counting 分帧：长度 空格 消息
+		i := strings.IndexByte(data, ' ')
+		if i < 0 {
+			t.Fatalf("无效的 syslog 帧: %q", data)
+		}
+		n, err := strconv.Atoi(data[:i])
+		if err != nil || len(data) < i+1+n {
+			t.Fatalf("无效的 syslog 帧: %q", data)
+		}
+		frame := data[i+1 : i+1+n]
+		messages = append(messages, frame[strings.Index(frame, "\xef\xbb\xbf")+3:])
+		data = data[i+1+n:]
+	}
+	if len(messages) != 100 {
+		t.Fatalf("收到 %d 行日志, 期望 100 行", len(messages))
+	}
+	for i, msg := range messages {
+		if want := fmt.Sprintf("消息 %d", i); msg != want {
+			t.Errorf("第 %d 行日志为 %q, 期望 %q", i, msg, want)
+		}
+	}
+	if n := w.dropped.Load(); n != 1 {
+		t.Errorf("丢弃了 %d 行日志, 期望 1 行", n)
+	}
+}
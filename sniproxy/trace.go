@@ -0,0 +1,334 @@
+package sniproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOTLPServiceName = "sniproxy"      // 默认的 service.name（otlp_service_name）
+	traceQueueSize         = 4096            // 等待发送的 span 数量上限（超过时丢弃新的 span，不影响转发）
+	traceBatchSize         = 512             // 每次最多发送的 span 数量
+	traceFlushInterval     = 5 * time.Second // 定时发送的间隔
+	traceExportTimeout     = 10 * time.Second
+	traceCloseTimeout      = 2 * time.Second // 退出时等待未结束的连接追踪的时间
+)
+
+// OTLP span 类型（SpanKind）
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+)
+
+// 把每个 TCP 连接的追踪数据发送到 OTLP/HTTP 接收端（otlp_endpoint，JSON 编码，例如 OpenTelemetry Collector、Jaeger、Tempo）
+// 每个连接一个 span（connection），其下有读取并解析 ClientHello（read_client_hello）、连接目标（dial）、转发数据（forward）三个子 span
+// TLS 数据是加密的，不会向其中注入任何追踪信息，只记录各阶段的时间
+type traceExporter struct {
+	p      *Proxy
+	queue  chan *traceSpan
+	once   sync.Once
+	stop   chan struct{}
+	done   chan struct{}
+	client *http.Client
+
+	mu     sync.Mutex
+	active int           // 未结束的连接追踪数量
+	idle   chan struct{} // 退出时等待 active 变为 0
+	closed bool          // 已停止（之后结束的 span 直接发送）
+
+	exportMu sync.Mutex // 停止后结束的 span 在各连接的线程中发送
+	failing  bool       // 上一次发送是否失败（连续失败时只记录一次日志）
+}
+
+// 一个 span（结束后放入发送队列）
+type traceSpan struct {
+	exporter *traceExporter
+	cfg      *Config // 开始时的配置（接收端地址、service.name）
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte // 根 span 时为空
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    []traceAttr
+	err      string // 不为空时 status 为 ERROR
+}
+
+// span 属性（值为 string 或 int64）
+type traceAttr struct {
+	key   string
+	value any
+}
+
+// 一个连接的追踪（未配置 otlp_endpoint 时为空，所有方法都不做任何事）
+type connTrace struct {
+	mu   sync.Mutex
+	root *traceSpan
+}
+
+type traceContextKey struct{}
+
+// 创建追踪数据的发送器
+func newTraceExporter(p *Proxy) *traceExporter {
+	return &traceExporter{p: p, queue: make(chan *traceSpan, traceQueueSize), stop: make(chan struct{}), done: make(chan struct{}), client: &http.Client{Timeout: traceExportTimeout}}
+}
+
+// 开始追踪一个连接，返回带有该追踪的 ctx（之后通过 traceFrom 获取）
+func (p *Proxy) startTrace(ctx context.Context, cfg *Config, fields LogFields) (context.Context, *connTrace) {
+	if cfg.OTLPEndpoint == "" {
+		return ctx, nil
+	}
+	root := &traceSpan{exporter: p.tracer, cfg: cfg, name: "connection", kind: spanKindServer, start: time.Now()}
+	rand.Read(root.traceID[:])
+	rand.Read(root.spanID[:])
+	p.tracer.mu.Lock()
+	p.tracer.active++
+	p.tracer.mu.Unlock()
+	t := &connTrace{root: root}
+	t.attr("sniproxy.conn_id", fields.ID)
+	t.attr("client.address", fields.Client)
+	return context.WithValue(ctx, traceContextKey{}, t), t
+}
+
+// 获取 ctx 中的追踪（没有时为空）
+func traceFrom(ctx context.Context) *connTrace {
+	t, _ := ctx.Value(traceContextKey{}).(*connTrace)
+	return t
+}
+
+// 设置连接 span 的属性（相同的属性会被覆盖）
+func (t *connTrace) attr(key string, value any) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.root.attrs {
+		if t.root.attrs[i].key == key {
+			t.root.attrs[i].value = value
+			return
+		}
+	}
+	t.root.attrs = append(t.root.attrs, traceAttr{key, value})
+}
+
+// 开始一个阶段（子 span），结束时调用其 finish
+func (t *connTrace) phase(name string, kind int) *traceSpan {
+	if t == nil {
+		return nil
+	}
+	s := &traceSpan{exporter: t.root.exporter, cfg: t.root.cfg, traceID: t.root.traceID, parentID: t.root.spanID, name: name, kind: kind, start: time.Now()}
+	rand.Read(s.spanID[:])
+	return s
+}
+
+// 连接处理结束
+func (t *connTrace) finish() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.root.finish(nil)
+	t.mu.Unlock()
+	e := t.root.exporter
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.active--; e.active == 0 && e.idle != nil {
+		close(e.idle)
+		e.idle = nil
+	}
+}
+
+// 结束 span 并放入发送队列（err 不为空时标记为出错）
+func (s *traceSpan) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	if err != nil {
+		s.err = err.Error()
+	}
+	e := s.exporter
+	e.mu.Lock()
+	if e.closed { // 已停止（例如退出时强制关闭的连接），直接发送
+		e.mu.Unlock()
+		e.export([]*traceSpan{s})
+		return
+	}
+	defer e.mu.Unlock() // 持有锁放入队列，停止时 run 一定能取到
+	e.once.Do(func() { go e.run() })
+	select {
+	case e.queue <- s:
+	default: // 队列已满（例如接收端长时间无法连接），丢弃
+	}
+}
+
+// 设置子 span 的属性
+func (s *traceSpan) attr(key string, value any) {
+	if s != nil {
+		s.attrs = append(s.attrs, traceAttr{key, value})
+	}
+}
+
+// 定时批量发送队列中的 span，停止时发送剩余的 span
+func (e *traceExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+	var batch []*traceSpan
+	for {
+		select {
+		case s := <-e.queue:
+			if batch = append(batch, s); len(batch) >= traceBatchSize {
+				e.export(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			e.export(batch)
+			batch = nil
+		case <-e.stop:
+			for {
+				select {
+				case s := <-e.queue:
+					batch = append(batch, s)
+				default:
+					e.export(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// 等待未结束的连接追踪（最多 traceCloseTimeout），发送剩余的 span 并停止（退出时调用）
+// 停止后结束的 span 不再放入队列，而是直接发送
+func (e *traceExporter) close() {
+	e.mu.Lock()
+	if e.active > 0 && e.idle == nil {
+		e.idle = make(chan struct{})
+	}
+	idle := e.idle
+	e.mu.Unlock()
+	if idle != nil {
+		select {
+		case <-idle:
+		case <-time.After(traceCloseTimeout):
+		}
+	}
+
+	e.mu.Lock()
+	e.closed = true
+	started := true
+	e.once.Do(func() { started = false })
+	e.mu.Unlock()
+	if started {
+		close(e.stop)
+		<-e.done
+	}
+}
+
+// 发送一批 span（按开始时的配置中的接收端分组）
+func (e *traceExporter) export(batch []*traceSpan) {
+	e.exportMu.Lock()
+	defer e.exportMu.Unlock()
+	groups := make(map[*Config][]*traceSpan)
+	var order []*Config
+	for _, s := range batch {
+		if _, ok := groups[s.cfg]; !ok {
+			order = append(order, s.cfg)
+		}
+		groups[s.cfg] = append(groups[s.cfg], s)
+	}
+	for _, cfg := range order {
+		err := e.post(cfg, groups[cfg])
+		switch {
+		case err != nil && !e.failing:
+			e.p.serviceLogger(fmt.Sprintf("发送追踪数据到 %s 失败: %v", cfg.OTLPEndpoint, err), LevelWarn)
+		case err == nil && e.failing:
+			e.p.serviceLogger(fmt.Sprintf("发送追踪数据到 %s 已恢复", cfg.OTLPEndpoint), LevelInfo)
+		}
+		e.failing = err != nil
+	}
+}
+
+// 以 OTLP/HTTP JSON 格式发送 span
+func (e *traceExporter) post(cfg *Config, spans []*traceSpan) error {
+	body, err := json.Marshal(otlpRequest(cfg, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, cfg.OTLPEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range cfg.OTLPHeaders { // 例如接收端需要的认证头部
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP JSON 请求（ExportTraceServiceRequest），ID 为十六进制字符串，时间和整数为十进制字符串
+func otlpRequest(cfg *Config, spans []*traceSpan) map[string]any {
+	list := make([]map[string]any, 0, len(spans))
+	for _, s := range spans {
+		span := map[string]any{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+		}
+		if s.parentID != [8]byte{} {
+			span["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != "" {
+			span["status"] = map[string]any{"code": 2, "message": s.err}
+		}
+		list = append(list, span)
+	}
+	return map[string]any{"resourceSpans": []any{map[string]any{
+		"resource":   map[string]any{"attributes": otlpAttributes([]traceAttr{{"service.name", cfg.OTLPServiceName}})},
+		"scopeSpans": []any{map[string]any{"scope": map[string]any{"name": "sniproxy"}, "spans": list}},
+	}}}
+}
+
+// 转换为 OTLP 属性列表
+func otlpAttributes(attrs []traceAttr) []any {
+	list := make([]any, 0, len(attrs))
+	for _, a := range attrs {
+		var value map[string]any
+		switch v := a.value.(type) {
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		list = append(list, map[string]any{"key": a.key, "value": value})
+	}
+	return list
+}
@@ -0,0 +1,268 @@
+package sniproxy
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// OTLP/HTTP JSON 请求（ExportTraceServiceRequest）中本服务使用的字段，解码时不允许其它字段
+type otlpTestRequest struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []otlpTestKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Scope struct {
+				Name string `json:"name"`
+			} `json:"scope"`
+			Spans []otlpTestSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+type otlpTestSpan struct {
+	TraceID           string             `json:"traceId"`
+	SpanID            string             `json:"spanId"`
+	ParentSpanID      string             `json:"parentSpanId"`
+	Name              string             `json:"name"`
+	Kind              int                `json:"kind"`
+	StartTimeUnixNano string             `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string             `json:"endTimeUnixNano"`
+	Attributes        []otlpTestKeyValue `json:"attributes"`
+	Status            *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+type otlpTestKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue *string `json:"stringValue"`
+		IntValue    *string `json:"intValue"` // int64 在 JSON 中为十进制字符串
+	} `json:"value"`
+}
+
+// 检查是否为 size 个字节的十六进制 ID（不能全为 0）
+func checkOTLPID(t *testing.T, name, id string, size int) {
+	t.Helper()
+	b, err := hex.DecodeString(id)
+	if err != nil || len(b) != size || id != hex.EncodeToString(b) {
+		t.Errorf("%s %q 不是 %d 个字节的小写十六进制字符串", name, id, size)
+		return
+	}
+	if string(b) == string(make([]byte, size)) {
+		t.Errorf("%s 全为 0", name)
+	}
+}
+
+// 检查属性列表（每个属性只有一个值，intValue 为十进制字符串），返回属性的值
+func checkOTLPAttributes(t *testing.T, attrs []otlpTestKeyValue) map[string]string {
+	t.Helper()
+	values := make(map[string]string, len(attrs))
+	for _, a := range attrs {
+		switch v := a.Value; {
+		case v.StringValue != nil && v.IntValue == nil:
+			values[a.Key] = *v.StringValue
+		case v.IntValue != nil && v.StringValue == nil:
+			if _, err := strconv.ParseInt(*v.IntValue, 10, 64); err != nil {
+				t.Errorf("属性 %s 的 intValue %q 不是十进制整数", a.Key, *v.IntValue)
+			}
+			values[a.Key] = *v.IntValue
+		default:
+			t.Errorf("属性 %s 的值 %+v 无效", a.Key, v)
+		}
+		if a.Key == "" {
+			t.Error("属性名为空")
+		}
+	}
+	return values
+}
+
+// 检查 span 的字段是否符合 OTLP 的定义
+func checkOTLPSpan(t *testing.T, s otlpTestSpan) {
+	t.Helper()
+	checkOTLPID(t, s.Name+" traceId", s.TraceID, 16)
+	checkOTLPID(t, s.Name+" spanId", s.SpanID, 8)
+	if s.ParentSpanID != "" {
+		checkOTLPID(t, s.Name+" parentSpanId", s.ParentSpanID, 8)
+	}
+	if s.Name == "" {
+		t.Error("span 名称为空")
+	}
+	if s.Kind < 1 || s.Kind > 5 { // SPAN_KIND_INTERNAL - SPAN_KIND_CONSUMER
+		t.Errorf("%s 的 kind %d 无效", s.Name, s.Kind)
+	}
+	start, err1 := strconv.ParseUint(s.StartTimeUnixNano, 10, 64)
+	end, err2 := strconv.ParseUint(s.EndTimeUnixNano, 10, 64)
+	if err1 != nil || err2 != nil || start == 0 || end < start {
+		t.Errorf("%s 的时间 %q - %q 无效", s.Name, s.StartTimeUnixNano, s.EndTimeUnixNano)
+	}
+	if s.Status != nil && (s.Status.Code < 0 || s.Status.Code > 2) {
+		t.Errorf("%s 的 status.code %d 无效", s.Name, s.Status.Code)
+	}
+	checkOTLPAttributes(t, s.Attributes)
+}
+
+// 测试用的 OTLP/HTTP 接收端，按 OTLP 的定义解码收到的请求
+type otlpTestCollector struct {
+	t        *testing.T
+	server   *httptest.Server
+	mu       sync.Mutex
+	requests []otlpTestRequest
+	headers  []http.Header
+}
+
+func newOTLPTestCollector(t *testing.T) *otlpTestCollector {
+	c := &otlpTestCollector{t: t}
+	c.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" {
+			c.t.Errorf("收到的请求为 %s %s, 期望 POST /v1/traces", r.Method, r.URL.Path)
+		}
+		var req otlpTestRequest
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			c.t.Errorf("解码追踪数据出错: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		c.requests = append(c.requests, req)
+		c.headers = append(c.headers, r.Header)
+		c.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "{}")
+	}))
+	t.Cleanup(c.server.Close)
+	return c
+}
+
+// 收到的所有 span（检查 resource 与 scope）
+func (c *otlpTestCollector) spans(serviceName string) []otlpTestSpan {
+	c.t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var spans []otlpTestSpan
+	for _, req := range c.requests {
+		for _, rs := range req.ResourceSpans {
+			if got := checkOTLPAttributes(c.t, rs.Resource.Attributes)["service.name"]; got != serviceName {
+				c.t.Errorf("service.name 为 %q, 期望 %q", got, serviceName)
+			}
+			for _, ss := range rs.ScopeSpans {
+				if ss.Scope.Name != "sniproxy" {
+					c.t.Errorf("scope.name 为 %q, 期望 sniproxy", ss.Scope.Name)
+				}
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}
+	return spans
+}
+
+// 转发一个连接后发送的追踪数据符合 OTLP/HTTP JSON 的定义
+func TestTraceExportOTLP(t *testing.T) {
+	upstream := newTestUpstream(t, "upstream")
+	collector := newOTLPTestCollector(t)
+	p := newTestProxy(t, fmt.Sprintf(`
+rules: ["example.com=%s"]
+otlp_endpoint: %s/v1/traces
+otlp_headers: {Authorization: "Bearer token"}
+otlp_service_name: test-proxy
+`, upstream.addr(), collector.server.URL))
+	if reply := serveTestConn(t, p, testClientHello(t, "example.com")); reply != upstream.name {
+		t.Fatalf("收到的回复为 %q, 期望 %q", reply, upstream.name)
+	}
+	p.tracer.close()
+
+	for _, h := range collector.headers {
+		if h.Get("Content-Type") != "application/json" || h.Get("Authorization") != "Bearer token" {
+			t.Errorf("请求头部 Content-Type %q, Authorization %q 不正确", h.Get("Content-Type"), h.Get("Authorization"))
+		}
+	}
+	spans := make(map[string]otlpTestSpan)
+	for _, s := range collector.spans("test-proxy") {
+		checkOTLPSpan(t, s)
+		if _, ok := spans[s.Name]; ok {
+			t.Errorf("span %s 重复", s.Name)
+		}
+		spans[s.Name] = s
+	}
+	root, ok := spans["connection"]
+	if !ok {
+		t.Fatalf("没有收到 connection span（收到 %d 个 span）", len(spans))
+	}
+	if root.ParentSpanID != "" || root.Kind != spanKindServer {
+		t.Errorf("connection span 的 parentSpanId %q, kind %d 不正确", root.ParentSpanID, root.Kind)
+	}
+	attrs := checkOTLPAttributes(t, root.Attributes)
+	for key, want := range map[string]string{
+		"tls.client.server_name": "example.com",
+		"sniproxy.target":        upstream.addr(),
+		"sniproxy.bytes_down":    strconv.Itoa(len(upstream.name)),
+	} {
+		if attrs[key] != want {
+			t.Errorf("connection span 的属性 %s 为 %q, 期望 %q", key, attrs[key], want)
+		}
+	}
+	for name, kind := range map[string]int{"read_client_hello": spanKindInternal, "dial": spanKindClient, "forward": spanKindInternal} {
+		s, ok := spans[name]
+		switch {
+		case !ok:
+			t.Errorf("没有收到 %s span", name)
+		case s.TraceID != root.TraceID || s.ParentSpanID != root.SpanID:
+			t.Errorf("%s span 的 traceId %s, parentSpanId %s 与 connection span 不对应", name, s.TraceID, s.ParentSpanID)
+		case s.Kind != kind:
+			t.Errorf("%s span 的 kind 为 %d, 期望 %d", name, s.Kind, kind)
+		}
+	}
+}
+
+// 停止发送时等待未结束的连接追踪，停止后结束的 span 直接发送，不会丢弃
+func TestTraceSpansEndingAfterClose(t *testing.T) {
+	collector := newOTLPTestCollector(t)
+	p := newTestProxy(t, fmt.Sprintf("allow_all_hosts: true\notlp_endpoint: %s/v1/traces\n", collector.server.URL))
+	cfg := p.getConfig()
+	_, trace := p.startTrace(context.Background(), cfg, LogFields{ID: "a", Client: "127.0.0.1:1"})
+	relay := trace.phase("forward", spanKindInternal)
+
+	closed := make(chan struct{})
+	go func() {
+		p.tracer.close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("还有未结束的连接追踪时停止了发送")
+	case <-time.After(100 * time.Millisecond):
+	}
+	relay.finish(nil)
+	trace.finish()
+	select {
+	case <-closed:
+	case <-time.After(traceCloseTimeout + time.Second):
+		t.Fatal("连接追踪结束后没有停止发送")
+	}
+
+	_, late := p.startTrace(context.Background(), cfg, LogFields{ID: "b", Client: "127.0.0.1:2"})
+	late.finish()
+
+	got := make(map[string]int)
+	for _, s := range collector.spans(defaultOTLPServiceName) {
+		checkOTLPSpan(t, s)
+		got[s.Name+" "+checkOTLPAttributes(t, s.Attributes)["sniproxy.conn_id"]]++
+	}
+	for _, want := range []string{"forward ", "connection a", "connection b"} {
+		if got[want] != 1 {
+			t.Errorf("span %q 收到 %d 次, 期望 1 次（收到 %v）", want, got[want], got)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package sniproxy
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// 透明代理模式（transparent）
+const (
+	transparentRedirect = "redirect" // iptables REDIRECT/DNAT，通过 SO_ORIGINAL_DST 获取原始目标地址
+	transparentTProxy   = "tproxy"   // iptables TPROXY，本端地址即为原始目标地址（监听需要 IP_TRANSPARENT）
+)
+
+// 监听 socket 的选项（在监听前设置），没有需要设置的选项时返回 nil
+func listenControl(cfg *Config) func(network, address string, c syscall.RawConn) error {
+	if cfg.Transparent != transparentTProxy && !cfg.ReusePort {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var opErr error
+		err := c.Control(func(fd uintptr) {
+			if cfg.Transparent == transparentTProxy {
+				opErr = setTransparent(fd)
+			}
+			if opErr == nil && cfg.ReusePort {
+				opErr = setReusePort(fd)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return opErr
+	}
+}
+
+// 获取透明代理模式下连接的原始目标地址
+func originalDst(c net.Conn, mode string) (*net.TCPAddr, error) {
+	if mode == transparentTProxy { // TPROXY 不会修改目标地址
+		addr, ok := c.LocalAddr().(*net.TCPAddr)
+		if !ok {
+			return nil, errors.New("不是 TCP 连接")
+		}
+		return addr, nil
+	}
+	tcpConn, ok := c.(*net.TCPConn)
+	if !ok {
+		return nil, errors.New("不是 TCP 连接")
+	}
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	ipv4 := c.RemoteAddr().(*net.TCPAddr).IP.To4() != nil // 监听 IPv6 socket 时 IPv4 客户端也是通过 IPv4 连接跟踪转换的
+	var addr *net.TCPAddr
+	var opErr error
+	if err := raw.Control(func(fd uintptr) {
+		addr, opErr = getOriginalDst(fd, ipv4)
+	}); err != nil {
+		return nil, err
+	}
+	return addr, opErr
+}
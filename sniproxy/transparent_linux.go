@@ -0,0 +1,42 @@
+package sniproxy
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	soOriginalDst   = 80 // SO_ORIGINAL_DST、IP6T_SO_ORIGINAL_DST（syscall 中没有定义）
+	ipv6Transparent = 75 // IPV6_TRANSPARENT（syscall 中没有定义）
+)
+
+// 允许监听 socket 接受目标地址不是本机的连接（IP_TRANSPARENT，需要 root 或 CAP_NET_ADMIN 权限）
+func setTransparent(fd uintptr) error {
+	err4 := syscall.SetsockoptInt(int(fd), syscall.SOL_IP, syscall.IP_TRANSPARENT, 1)
+	err6 := syscall.SetsockoptInt(int(fd), syscall.SOL_IPV6, ipv6Transparent, 1)
+	if err4 != nil && err6 != nil { // 监听 IPv4 或 IPv6 socket 时只有其中一个能设置成功
+		return fmt.Errorf("设置 IP_TRANSPARENT 失败（需要 root 或 CAP_NET_ADMIN 权限）: %v", err4)
+	}
+	return nil
+}
+
+// 获取经过 REDIRECT/DNAT 的连接的原始目标地址（SO_ORIGINAL_DST）
+func getOriginalDst(fd uintptr, ipv4 bool) (*net.TCPAddr, error) {
+	if ipv4 { // 返回 sockaddr_in（16 字节），借用 IPv6Mreq 结构读取
+		mreq, err := syscall.GetsockoptIPv6Mreq(int(fd), syscall.SOL_IP, soOriginalDst)
+		if err != nil {
+			return nil, fmt.Errorf("获取 SO_ORIGINAL_DST 失败: %v", err)
+		}
+		b := mreq.Multiaddr
+		return &net.TCPAddr{IP: net.IPv4(b[4], b[5], b[6], b[7]), Port: int(b[2])<<8 | int(b[3])}, nil
+	}
+	// 返回 sockaddr_in6（28 字节），借用 IPv6MTUInfo 结构读取
+	info, err := syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.SOL_IPV6, soOriginalDst)
+	if err != nil {
+		return nil, fmt.Errorf("获取 IP6T_SO_ORIGINAL_DST 失败: %v", err)
+	}
+	port := (*[2]byte)(unsafe.Pointer(&info.Addr.Port)) // 网络字节序
+	return &net.TCPAddr{IP: net.IP(info.Addr.Addr[:]), Port: int(port[0])<<8 | int(port[1])}, nil
+}
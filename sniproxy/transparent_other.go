@@ -0,0 +1,18 @@
+//go:build !linux
+
+package sniproxy
+
+import (
+	"errors"
+	"net"
+)
+
+// 允许监听 socket 接受目标地址不是本机的连接（仅支持 Linux）
+func setTransparent(fd uintptr) error {
+	return errors.New("transparent 仅支持 Linux 系统")
+}
+
+// 获取原始目标地址（仅支持 Linux）
+func getOriginalDst(fd uintptr, ipv4 bool) (*net.TCPAddr, error) {
+	return nil, errors.New("transparent 仅支持 Linux 系统")
+}
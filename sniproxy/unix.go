@@ -0,0 +1,99 @@
+package sniproxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	unixAddrPrefix        = "unix:" // listen_addr 中 Unix socket 地址的前缀（例如 unix:/run/sniproxy.sock）
+	defaultUnixSocketMode = "0660"  // 默认的 Unix socket 文件权限（所有者和同组用户可以连接）
+)
+
+// 如果监听地址是 Unix socket（unix:路径），返回其路径
+func unixSocketPath(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, unixAddrPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(addr, unixAddrPrefix), true
+}
+
+// 检查 Unix socket 地址的格式
+func checkUnixAddr(addr string) error {
+	if path, _ := unixSocketPath(addr); path == "" {
+		return fmt.Errorf("%s 缺少 socket 文件路径", addr)
+	}
+	return nil
+}
+
+// 解析 unix_socket_mode（八进制的文件权限，例如 0660）
+func parseUnixSocketMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("%s（需要为八进制的文件权限，例如 0660）", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// 监听 Unix socket（已存在的 socket 文件没有进程在监听时先删除，例如上次异常退出时留下的），并设置文件权限
+// 关闭监听时会自动删除 socket 文件
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s 已存在且不是 socket 文件", path)
+		}
+		if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s 已有其他进程在监听", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("设置 %s 的权限失败: %v", path, err)
+	}
+	return &unixListener{Listener: l}, nil
+}
+
+// Unix socket 监听，接受的连接的地址显示为 127.0.0.1（Unix socket 只能由本机进程连接，
+// 这样 allowed_clients、按 IP 限制等仍可正常工作，前面的进程可以通过 PROXY protocol 传入真实的客户端地址）
+type unixListener struct {
+	net.Listener
+}
+
+var unixPeerAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+
+func (l *unixListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &unixConn{Conn: c}, nil
+}
+
+// Unix socket 连接
+type unixConn struct {
+	net.Conn
+}
+
+func (c *unixConn) RemoteAddr() net.Addr { return unixPeerAddr }
+func (c *unixConn) LocalAddr() net.Addr  { return unixPeerAddr }
+
+// 半关闭（客户端发送完毕后通知目标）
+func (c *unixConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.New("不支持半关闭")
+}
@@ -0,0 +1,146 @@
+package sniproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWebhookInterval = 30 // 默认两次发送 webhook 的最短间隔（秒），期间的事件合并后一起发送
+	webhookTimeout         = 10 * time.Second
+	maxWebhookEvents       = 100 // 每次最多发送的不同事件数量（超过时丢弃新的事件，只增加已有事件的次数）
+)
+
+// webhook 事件类型（webhook_events）
+const (
+	eventDialFailure = "dial_failure" // 连接目标失败（key 为目标地址）
+	eventCircuitOpen = "circuit_open" // 目标连续连接失败达到 circuit_breaker_failures，开始熔断（key 为目标地址）
+	eventBlocked     = "blocked"      // SNI 域名命中 blocked_hosts 被拒绝（key 为 SNI 域名）
+	eventReload      = "reload"       // 重载配置（成功或失败）
+)
+
+var webhookEventTypes = []string{eventDialFailure, eventCircuitOpen, eventBlocked, eventReload}
+
+// 发送的事件类型（按 webhookEventTypes 的顺序）
+func (c *Config) enabledWebhookEvents() []string {
+	var events []string
+	for _, event := range webhookEventTypes {
+		if c.webhookEvents[event] {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// 一个（合并后的）事件
+type webhookEvent struct {
+	Type      string `json:"type"`
+	Key       string `json:"key,omitempty"`
+	Message   string `json:"message"` // 最后一次的日志内容
+	Count     int    `json:"count"`   // 合并的次数
+	FirstSeen string `json:"first_seen"`
+	LastSeen  string `json:"last_seen"`
+}
+
+// 发送事件通知到 webhook_url（POST JSON），同一类型、同一 key 的事件在 webhook_interval 内合并为一个并计数，
+// 两次发送至少间隔 webhook_interval 秒，避免大量事件（例如目标宕机时每个连接都失败）冲击 webhook
+type webhookNotifier struct {
+	p        *Proxy
+	mu       sync.Mutex
+	cfg      *Config // 最后一个事件时的配置（发送时使用其中的 webhook_url）
+	pending  map[string]*webhookEvent
+	order    []string // 事件的先后顺序
+	timer    *time.Timer
+	lastSent time.Time
+	client   *http.Client
+	sendMu   sync.Mutex // 同时只发送一次（webhook_interval 小于发送耗时时）
+	failing  bool       // 上一次发送是否失败（连续失败时只记录一次日志）
+}
+
+// 创建 webhook 通知
+func newWebhookNotifier(p *Proxy) *webhookNotifier {
+	return &webhookNotifier{p: p, pending: make(map[string]*webhookEvent), client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// 记录一个事件（未配置 webhook_url 或该类型不在 webhook_events 中时忽略），在下一次发送时一起发送
+func (n *webhookNotifier) notify(cfg *Config, typ, key, message string) {
+	if cfg.WebhookURL == "" || !cfg.webhookEvents[typ] {
+		return
+	}
+	now := time.Now().Format(time.RFC3339)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.cfg = cfg
+	id := typ + "\x00" + key
+	if e, ok := n.pending[id]; ok {
+		e.Count++
+		e.Message, e.LastSeen = message, now
+	} else if len(n.pending) < maxWebhookEvents {
+		n.pending[id] = &webhookEvent{Type: typ, Key: key, Message: message, Count: 1, FirstSeen: now, LastSeen: now}
+		n.order = append(n.order, id)
+	}
+	if n.timer == nil { // 距上一次发送已经超过间隔时立即发送，否则等到间隔结束
+		delay := time.Until(n.lastSent.Add(time.Duration(cfg.WebhookInterval) * time.Second))
+		n.timer = time.AfterFunc(delay, n.flush)
+	}
+}
+
+// 发送已记录的事件
+func (n *webhookNotifier) flush() {
+	n.mu.Lock()
+	cfg, order, pending := n.cfg, n.order, n.pending
+	n.pending, n.order, n.timer, n.lastSent = make(map[string]*webhookEvent), nil, nil, time.Now()
+	n.mu.Unlock()
+	if len(order) == 0 {
+		return
+	}
+	events := make([]*webhookEvent, len(order))
+	for i, id := range order {
+		events[i] = pending[id]
+	}
+	n.sendMu.Lock()
+	defer n.sendMu.Unlock()
+	err := n.post(cfg, events)
+	switch {
+	case err != nil && !n.failing:
+		n.p.serviceLogger(fmt.Sprintf("发送 webhook 通知到 %s 失败: %v", cfg.WebhookURL, err), LevelWarn)
+	case err == nil && n.failing:
+		n.p.serviceLogger(fmt.Sprintf("发送 webhook 通知到 %s 已恢复", cfg.WebhookURL), LevelInfo)
+	}
+	n.failing = err != nil
+}
+
+// 立即发送剩余的事件（退出时调用）
+func (n *webhookNotifier) close() {
+	n.mu.Lock()
+	timer := n.timer
+	n.mu.Unlock()
+	if timer != nil && timer.Stop() { // 已经开始发送时不需要再发送
+		n.flush()
+	}
+}
+
+// 以 JSON 格式发送事件：{"source": "sniproxy", "hostname": "主机名", "events": [...]}
+func (n *webhookNotifier) post(cfg *Config, events []*webhookEvent) error {
+	hostname, _ := os.Hostname()
+	body, err := json.Marshal(map[string]any{"source": "sniproxy", "hostname": hostname, "events": events})
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(cfg.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return nil
+}
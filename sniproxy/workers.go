@@ -0,0 +1,61 @@
+package sniproxy
+
+import "context"
+
+// 工作线程队列已满时的处理方式（worker_queue_full）
+const (
+	workerQueueBlock = "block" // 等待队列空出位置（默认），期间不接受新连接，新连接在内核的监听队列中等待
+	workerQueueDrop  = "drop"  // 直接关闭新连接
+)
+
+// 固定数量的工作线程（num_workers），接受的连接先放入队列，再由空闲的工作线程处理（包括转发，直到连接结束）
+// 为 nil 时（未配置 num_workers）每个连接启动一个新线程处理，不限制数量
+type workerPool struct {
+	jobs chan func()
+	full string
+}
+
+// 创建工作线程池（workers 为 0 时返回 nil，即不使用工作线程），queue 为队列长度
+func newWorkerPool(workers, queue int, full string) *workerPool {
+	if workers <= 0 {
+		return nil
+	}
+	p := &workerPool{jobs: make(chan func(), queue), full: full}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range p.jobs { // 队列关闭后处理完剩余的连接再退出
+				job()
+			}
+		}()
+	}
+	return p
+}
+
+// 提交一个连接，队列已满时按 worker_queue_full 等待或放弃（ctx 被取消时也放弃），放弃时返回 false
+func (p *workerPool) submit(ctx context.Context, job func()) bool {
+	if p == nil {
+		go job()
+		return true
+	}
+	if p.full == workerQueueDrop {
+		select {
+		case p.jobs <- job:
+			return true
+		default:
+			return false
+		}
+	}
+	select {
+	case p.jobs <- job:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// 关闭队列（所有监听都已停止接受连接后调用），工作线程处理完已提交的连接后退出
+func (p *workerPool) close() {
+	if p != nil {
+		close(p.jobs)
+	}
+}